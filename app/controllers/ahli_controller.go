@@ -0,0 +1,218 @@
+package controllers
+
+import (
+	"time"
+
+	"github.com/gilanghuda/sobi-backend/app/models"
+	"github.com/gilanghuda/sobi-backend/app/queries"
+	"github.com/gilanghuda/sobi-backend/pkg/database"
+	"github.com/gilanghuda/sobi-backend/pkg/utils"
+	"github.com/gofiber/fiber/v2"
+)
+
+func GetAhliBookings(c *fiber.Ctx) error {
+	authHeader := c.Get("Authorization")
+	ahliID, err := utils.ExtractUserIDFromHeader(authHeader)
+	if err != nil {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	scope := c.Query("scope", "upcoming")
+	if scope != "upcoming" && scope != "past" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "scope must be upcoming or past"})
+	}
+	limit := c.QueryInt("limit", 50)
+	if limit <= 0 || limit > 200 {
+		limit = 50
+	}
+
+	q := queries.BookingQueries{DB: database.DB}
+	bookings, err := q.GetBookingsByAhli(ahliID, scope == "upcoming", limit)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "failed to get bookings"})
+	}
+	return c.Status(fiber.StatusOK).JSON(bookings)
+}
+
+func GetAhliEarnings(c *fiber.Ctx) error {
+	authHeader := c.Get("Authorization")
+	ahliID, err := utils.ExtractUserIDFromHeader(authHeader)
+	if err != nil {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	period := c.Query("period", "month")
+	if period != "day" && period != "week" && period != "month" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "period must be day, week or month"})
+	}
+
+	to := time.Now()
+	from := to.AddDate(-1, 0, 0)
+	if s := c.Query("from"); s != "" {
+		if from, err = time.Parse("2006-01-02", s); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "invalid from, use YYYY-MM-DD"})
+		}
+	}
+	if s := c.Query("to"); s != "" {
+		t, err := time.Parse("2006-01-02", s)
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "invalid to, use YYYY-MM-DD"})
+		}
+		to = t.AddDate(0, 0, 1)
+	}
+
+	q := queries.AhliQueries{DB: database.DB}
+	periods, err := q.GetEarningsByPeriod(ahliID, period, from, to)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "failed to get earnings"})
+	}
+
+	var total int64
+	for _, p := range periods {
+		total += p.Total
+	}
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{"period": period, "total": total, "periods": periods})
+}
+
+func GetAhliPendingPayouts(c *fiber.Ctx) error {
+	authHeader := c.Get("Authorization")
+	ahliID, err := utils.ExtractUserIDFromHeader(authHeader)
+	if err != nil {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	q := queries.LedgerQueries{DB: database.DB}
+	count, total, err := q.GetPendingEarnings(ahliID)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "failed to get pending payouts"})
+	}
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{"transactions": count, "amount": total})
+}
+
+func GetAhliClients(c *fiber.Ctx) error {
+	authHeader := c.Get("Authorization")
+	ahliID, err := utils.ExtractUserIDFromHeader(authHeader)
+	if err != nil {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	limit := c.QueryInt("limit", 50)
+	if limit <= 0 || limit > 200 {
+		limit = 50
+	}
+
+	q := queries.AhliQueries{DB: database.DB}
+	clients, err := q.GetClients(ahliID, limit)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "failed to get clients"})
+	}
+	return c.Status(fiber.StatusOK).JSON(clients)
+}
+
+func GetAhliProfile(c *fiber.Ctx) error {
+	authHeader := c.Get("Authorization")
+	ahliID, err := utils.ExtractUserIDFromHeader(authHeader)
+	if err != nil {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	q := queries.AhliQueries{DB: database.DB}
+	ahli, err := q.GetAhliByID(ahliID)
+	if err != nil {
+		if err.Error() == "ahli not found" {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": err.Error()})
+		}
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "failed to get ahli profile"})
+	}
+	return c.Status(fiber.StatusOK).JSON(ahli)
+}
+
+func UpdateAhliProfile(c *fiber.Ctx) error {
+	authHeader := c.Get("Authorization")
+	ahliID, err := utils.ExtractUserIDFromHeader(authHeader)
+	if err != nil {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	req := &models.UpdateAhliProfileRequest{}
+	if err := c.BodyParser(req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "invalid body"})
+	}
+
+	if req.Price != nil && *req.Price < 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "price must not be negative"})
+	}
+	if req.OpenTime != nil && *req.OpenTime != "" {
+		if _, err := parseClock(*req.OpenTime); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "invalid open_time format, use HH:MM or HH:MM:SS"})
+		}
+	}
+	cats := []string{}
+	if req.Category != nil {
+		cats = append(cats, *req.Category)
+	}
+	if req.Specializations != nil {
+		cats = append(cats, *req.Specializations...)
+	}
+	for _, cat := range cats {
+		ok, err := categoryAllowed(cat, models.CategoryScopeAhli)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "unable to validate category"})
+		}
+		if !ok {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "unknown ahli category: " + cat})
+		}
+	}
+	if req.Availability != nil {
+		for _, a := range *req.Availability {
+			if a.DayOfWeek < 0 || a.DayOfWeek > 6 {
+				return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "day_of_week must be between 0 (Sunday) and 6"})
+			}
+			start, err1 := parseClock(a.StartTime)
+			end, err2 := parseClock(a.EndTime)
+			if err1 != nil || err2 != nil {
+				return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "invalid availability time, use HH:MM"})
+			}
+			if !end.After(start) {
+				return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "availability end_time must be after start_time"})
+			}
+		}
+	}
+
+	q := queries.AhliQueries{DB: database.DB}
+	if err := q.UpdateAhliProfile(ahliID, req); err != nil {
+		if err.Error() == "ahli not found" {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": err.Error()})
+		}
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	ahli, err := q.GetAhliByID(ahliID)
+	if err != nil {
+		return c.Status(fiber.StatusOK).JSON(fiber.Map{"message": "ahli profile updated"})
+	}
+	return c.Status(fiber.StatusOK).JSON(ahli)
+}
+
+func GetAhliPriceHistory(c *fiber.Ctx) error {
+	authHeader := c.Get("Authorization")
+	ahliID, err := utils.ExtractUserIDFromHeader(authHeader)
+	if err != nil {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	q := queries.AhliQueries{DB: database.DB}
+	history, err := q.GetPriceHistory(ahliID, 100)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "failed to get price history"})
+	}
+	return c.Status(fiber.StatusOK).JSON(history)
+}
+
+// parseClock parses a time of day given as HH:MM or HH:MM:SS
+func parseClock(s string) (time.Time, error) {
+	if t, err := time.Parse("15:04:05", s); err == nil {
+		return t, nil
+	}
+	return time.Parse("15:04", s)
+}
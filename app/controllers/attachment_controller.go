@@ -0,0 +1,219 @@
+package controllers
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gilanghuda/sobi-backend/app/models"
+	"github.com/gilanghuda/sobi-backend/app/queries"
+	"github.com/gilanghuda/sobi-backend/pkg/database"
+	"github.com/gilanghuda/sobi-backend/pkg/storage"
+	"github.com/gilanghuda/sobi-backend/pkg/utils"
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+)
+
+var (
+	storeOnce sync.Once
+	store     storage.Store
+)
+
+// blobStore returns the storage used for chat attachments
+func blobStore() storage.Store {
+	storeOnce.Do(func() {
+		store = storage.FromEnv()
+	})
+	return store
+}
+
+const thumbnailSize = 320
+
+// attachmentKinds lists, per message type, the sniffed MIME types accepted and the maximum upload size.
+// Voice notes recorded in browsers and phones sniff as webm or mp4 containers.
+var attachmentKinds = map[string]struct {
+	mimes   map[string]string // MIME type -> file extension
+	maxSize int64
+}{
+	models.MessageTypeImage: {map[string]string{"image/jpeg": ".jpg", "image/png": ".png", "image/gif": ".gif", "image/webp": ".webp"}, 10 << 20},
+	models.MessageTypeAudio: {map[string]string{"audio/mpeg": ".mp3", "audio/wave": ".wav", "application/ogg": ".ogg", "video/webm": ".webm", "video/mp4": ".m4a"}, 20 << 20},
+	models.MessageTypeFile:  {map[string]string{"application/pdf": ".pdf", "image/jpeg": ".jpg", "image/png": ".png"}, 20 << 20},
+}
+
+// AttachmentBodyLimit is the largest request body an attachment upload needs: the biggest file
+// allowed above plus room for the other form fields.
+const AttachmentBodyLimit = 21 << 20
+
+// withAttachmentURLs points the attachments of a message at the download endpoint
+func withAttachmentURLs(m models.Message) models.Message {
+	for i := range m.Attachments {
+		a := &m.Attachments[i]
+		a.URL = "/chat/attachments/" + a.ID.String()
+		if a.ThumbnailKey != "" {
+			a.ThumbnailURL = a.URL + "?thumb=1"
+		}
+	}
+	return m
+}
+
+// PostAttachmentMessage sends an image, voice note or file to a room as a multipart upload
+// with the fields room_id, type, an optional text caption and the file itself.
+func PostAttachmentMessage(c *fiber.Ctx) error {
+	userID, err := utils.ExtractUserIDFromHeader(c.Get("Authorization"))
+	if err != nil {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": err.Error()})
+	}
+	roomID, err := uuid.Parse(c.FormValue("room_id"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "invalid room_id"})
+	}
+	msgType := c.FormValue("type")
+	kind, ok := attachmentKinds[msgType]
+	if !ok {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "type must be image, audio or file"})
+	}
+	if status, err := checkRoomMember(roomID, userID); err != nil {
+		return c.Status(status).JSON(fiber.Map{"error": err.Error()})
+	}
+	if status, err := checkRoomActive(roomID); err != nil {
+		return c.Status(status).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	fh, err := c.FormFile("file")
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "file required"})
+	}
+	if fh.Size > kind.maxSize {
+		return c.Status(fiber.StatusRequestEntityTooLarge).JSON(fiber.Map{"error": fmt.Sprintf("file must be at most %d MB", kind.maxSize>>20)})
+	}
+	f, err := fh.Open()
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "unable to read file"})
+	}
+	data, err := io.ReadAll(io.LimitReader(f, kind.maxSize+1))
+	f.Close()
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "unable to read file"})
+	}
+	if int64(len(data)) > kind.maxSize {
+		return c.Status(fiber.StatusRequestEntityTooLarge).JSON(fiber.Map{"error": fmt.Sprintf("file must be at most %d MB", kind.maxSize>>20)})
+	}
+
+	// the declared content type is not trusted; the type is sniffed from the content
+	mime := strings.SplitN(http.DetectContentType(data), ";", 2)[0]
+	ext, ok := kind.mimes[mime]
+	if !ok {
+		return c.Status(fiber.StatusUnsupportedMediaType).JSON(fiber.Map{"error": "unsupported " + msgType + " format: " + mime})
+	}
+
+	name := filepath.Base(fh.Filename)
+	if name == "." || name == "/" || name == "" {
+		name = "attachment" + ext
+	}
+	if len(name) > 200 {
+		name = name[len(name)-200:]
+	}
+
+	now := time.Now()
+	a := models.Attachment{ID: uuid.New(), FileName: name, MimeType: mime, Size: int64(len(data)), CreatedAt: now}
+	a.StorageKey = fmt.Sprintf("attachments/%s/%s%s", roomID, a.ID, ext)
+	if msgType == models.MessageTypeImage && mime != "image/webp" {
+		thumb, w, h, err := utils.Thumbnail(data, thumbnailSize)
+		if err != nil {
+			log.Printf("event=thumbnail_error attachment=%s err=%v", a.ID, err)
+		} else {
+			a.ThumbnailKey = fmt.Sprintf("attachments/%s/%s_thumb.jpg", roomID, a.ID)
+			a.Width, a.Height = &w, &h
+			if _, err := blobStore().Put(c.Context(), a.ThumbnailKey, bytes.NewReader(thumb)); err != nil {
+				return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "failed to store thumbnail"})
+			}
+		}
+	}
+	if _, err := blobStore().Put(c.Context(), a.StorageKey, bytes.NewReader(data)); err != nil {
+		removeAttachmentBlobs(a)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "failed to store file"})
+	}
+
+	m := &models.Message{ID: uuid.New(), RoomID: roomID, UserID: userID, Type: msgType, Text: c.FormValue("text"), Visible: true, CreatedAt: now}
+	if status, err := setReplyTo(m, c.FormValue("reply_to_message_id")); err != nil {
+		removeAttachmentBlobs(a)
+		return c.Status(status).JSON(fiber.Map{"error": err.Error()})
+	}
+	a.MessageID = m.ID
+	m.Attachments = []models.Attachment{a}
+	q := queries.ChatQueries{DB: database.DB}
+	if err := q.CreateMessageWithAttachments(m); err != nil {
+		removeAttachmentBlobs(a)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "failed to create message"})
+	}
+
+	*m = withAttachmentURLs(*m)
+	go func(msg *models.Message) {
+		messageChan <- msg
+	}(m)
+	return c.Status(fiber.StatusCreated).JSON(m)
+}
+
+// removeAttachmentBlobs cleans up the stored files of an attachment that was never saved
+func removeAttachmentBlobs(a models.Attachment) {
+	for _, key := range []string{a.StorageKey, a.ThumbnailKey} {
+		if key == "" {
+			continue
+		}
+		if err := blobStore().Delete(context.Background(), key); err != nil {
+			log.Printf("event=storage_cleanup_error key=%s err=%v", key, err)
+		}
+	}
+}
+
+// GetAttachment streams an attachment, or its thumbnail with ?thumb=1, to a member of its room
+func GetAttachment(c *fiber.Ctx) error {
+	userID, err := utils.ExtractUserIDFromHeader(c.Get("Authorization"))
+	if err != nil {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": err.Error()})
+	}
+	id, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "invalid attachment id"})
+	}
+
+	q := queries.ChatQueries{DB: database.DB}
+	a, roomID, deleted, err := q.GetAttachment(id)
+	if err != nil {
+		if err.Error() == "attachment not found" {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": err.Error()})
+		}
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "failed to get attachment"})
+	}
+	if status, err := checkRoomMember(roomID, userID); err != nil {
+		return c.Status(status).JSON(fiber.Map{"error": err.Error()})
+	}
+	if deleted {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "attachment not found"})
+	}
+
+	key, contentType := a.StorageKey, a.MimeType
+	if c.QueryBool("thumb") && a.ThumbnailKey != "" {
+		key, contentType = a.ThumbnailKey, "image/jpeg"
+	}
+	rc, err := blobStore().Get(c.Context(), key)
+	if err != nil {
+		if err == storage.ErrNotFound {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "attachment not found"})
+		}
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "failed to read attachment"})
+	}
+
+	c.Set(fiber.HeaderContentType, contentType)
+	c.Set(fiber.HeaderContentDisposition, fmt.Sprintf(`inline; filename=%q`, a.FileName))
+	c.Set(fiber.HeaderCacheControl, "private, max-age=86400")
+	c.Set("X-Content-Type-Options", "nosniff")
+	return c.Status(fiber.StatusOK).SendStream(rc)
+}
@@ -0,0 +1,112 @@
+package controllers
+
+import (
+	"time"
+
+	"github.com/gilanghuda/sobi-backend/app/models"
+	"github.com/gilanghuda/sobi-backend/app/queries"
+	"github.com/gilanghuda/sobi-backend/pkg/database"
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+)
+
+// categoryAllowed checks slug against the managed category taxonomy for the given scope. An empty
+// slug is never allowed; callers where the category is optional skip the check instead.
+func categoryAllowed(slug, scope string) (bool, error) {
+	if slug == "" {
+		return false, nil
+	}
+	q := queries.CategoryQueries{DB: database.DB}
+	return q.IsValidCategory(slug, scope)
+}
+
+func GetCategories(c *fiber.Ctx) error {
+	q := queries.CategoryQueries{DB: database.DB}
+	cats, err := q.GetCategories(c.Query("scope"), false)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "failed to get categories"})
+	}
+	return c.Status(fiber.StatusOK).JSON(cats)
+}
+
+func AdminGetCategories(c *fiber.Ctx) error {
+	q := queries.CategoryQueries{DB: database.DB}
+	cats, err := q.GetCategories(c.Query("scope"), true)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "failed to get categories"})
+	}
+	return c.Status(fiber.StatusOK).JSON(cats)
+}
+
+func CreateCategory(c *fiber.Ctx) error {
+	req := &models.CategoryRequest{}
+	if err := c.BodyParser(req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "invalid body"})
+	}
+	if err := validate.Struct(req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	active := true
+	if req.Active != nil {
+		active = *req.Active
+	}
+	cat := &models.Category{ID: uuid.New(), Slug: req.Slug, Name: req.Name, Description: req.Description, Scopes: req.Scopes, Active: active, CreatedAt: time.Now(), UpdatedAt: time.Now()}
+	q := queries.CategoryQueries{DB: database.DB}
+	if err := q.CreateCategory(cat); err != nil {
+		if err.Error() == "category slug already exists" {
+			return c.Status(fiber.StatusConflict).JSON(fiber.Map{"error": err.Error()})
+		}
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "failed to create category"})
+	}
+	return c.Status(fiber.StatusCreated).JSON(cat)
+}
+
+func UpdateCategory(c *fiber.Ctx) error {
+	id, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "invalid id"})
+	}
+	req := &models.UpdateCategoryRequest{}
+	if err := c.BodyParser(req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "invalid body"})
+	}
+	if err := validate.Struct(req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	q := queries.CategoryQueries{DB: database.DB}
+	cat, err := q.GetCategoryByID(id)
+	if err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "category not found"})
+	}
+	if req.Slug != "" && req.Slug != cat.Slug {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "category slug cannot be changed"})
+	}
+	cat.Name = req.Name
+	cat.Description = req.Description
+	cat.Scopes = req.Scopes
+	if req.Active != nil {
+		cat.Active = *req.Active
+	}
+	if err := q.UpdateCategory(&cat); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "failed to update category"})
+	}
+	cat.UpdatedAt = time.Now()
+	return c.Status(fiber.StatusOK).JSON(cat)
+}
+
+func DeleteCategory(c *fiber.Ctx) error {
+	id, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "invalid id"})
+	}
+	q := queries.CategoryQueries{DB: database.DB}
+	if err := q.DeactivateCategory(id); err != nil {
+		if err.Error() == "category not found" {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": err.Error()})
+		}
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "failed to delete category"})
+	}
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{"message": "category deactivated"})
+}
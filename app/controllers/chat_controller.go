@@ -2,7 +2,9 @@ package controllers
 
 import (
 	"encoding/json"
+	"errors"
 	"log"
+	"strings"
 	"sync"
 	"time"
 
@@ -81,6 +83,30 @@ func WsHandler(c *websocket.Conn) {
 	}
 }
 
+const (
+	defaultMessagePageSize = 50
+	maxMessagePageSize     = 200
+
+	defaultSearchPageSize = 20
+	maxSearchPageSize     = 50
+)
+
+// checkRoomMember returns the HTTP status and error to answer with when the user may not access the room
+func checkRoomMember(roomID, userID uuid.UUID) (int, error) {
+	q := queries.ChatQueries{DB: database.DB}
+	member, err := q.IsRoomMember(roomID, userID)
+	if err != nil {
+		if err.Error() == "room not found" {
+			return fiber.StatusNotFound, err
+		}
+		return fiber.StatusInternalServerError, err
+	}
+	if !member {
+		return fiber.StatusForbidden, errors.New("you are not a member of this room")
+	}
+	return fiber.StatusOK, nil
+}
+
 func CreateRoom(c *fiber.Ctx) error {
 	authHeader := c.Get("Authorization")
 	userID, err := utils.ExtractUserIDFromHeader(authHeader)
@@ -92,15 +118,71 @@ func CreateRoom(c *fiber.Ctx) error {
 	if err := c.BodyParser(req); err != nil {
 		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "invalid body"})
 	}
+	// the category is optional for rooms; only a supplied one has to be in the taxonomy
+	if req.Category != "" {
+		ok, err := categoryAllowed(req.Category, models.CategoryScopeRoom)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "unable to validate category"})
+		}
+		if !ok {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "unknown room category"})
+		}
+	}
 
 	var targetPtr *uuid.UUID
-	if req.TargetID != "" {
+	if req.IsGroup {
+		// group rooms are support circles moderated by an ahli
+		uq := queries.UserQueries{DB: database.DB}
+		owner, err := uq.GetUserByID(userID)
+		if err != nil {
+			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "user not found"})
+		}
+		if owner.UserRole != utils.RoleAhli && owner.UserRole != utils.RoleAdmin {
+			return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"error": "only ahli can create group rooms"})
+		}
+	} else if req.TargetID != "" {
 		if tid, err := uuid.Parse(req.TargetID); err == nil {
 			targetPtr = &tid
 		}
 	}
 
-	r := &models.Room{ID: uuid.New(), OwnerID: userID, TargetID: targetPtr, Category: req.Category, Visible: req.Visible, CreatedAt: time.Now(), UpdatedAt: time.Now()}
+	var bookingPtr *uuid.UUID
+	if req.BookingID != "" {
+		if req.IsGroup {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "group rooms cannot be booked"})
+		}
+		bid, err := uuid.Parse(req.BookingID)
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "invalid booking_id"})
+		}
+		bq := queries.BookingQueries{DB: database.DB}
+		b, err := bq.GetBookingByID(bid)
+		if err != nil {
+			if err.Error() == "booking not found" {
+				return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": err.Error()})
+			}
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "failed to get booking"})
+		}
+		// the room is between the two parties of the booking
+		other := b.AhliID
+		if userID == b.AhliID {
+			other = b.UserID
+		} else if userID != b.UserID {
+			return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"error": "booking does not belong to you"})
+		}
+		if targetPtr != nil && *targetPtr != other {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "target_id does not match the booking"})
+		}
+		if b.Status != "confirmed" {
+			return c.Status(fiber.StatusConflict).JSON(fiber.Map{"error": "booking is not confirmed"})
+		}
+		if !b.EndAt.After(time.Now()) {
+			return c.Status(fiber.StatusConflict).JSON(fiber.Map{"error": "booking has already ended"})
+		}
+		targetPtr, bookingPtr = &other, &b.ID
+	}
+
+	r := &models.Room{ID: uuid.New(), OwnerID: userID, TargetID: targetPtr, BookingID: bookingPtr, Category: req.Category, Visible: req.Visible, IsGroup: req.IsGroup, CreatedAt: time.Now(), UpdatedAt: time.Now()}
 	q := queries.ChatQueries{DB: database.DB}
 	if err := q.CreateRoom(r); err != nil {
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "failed to create room"})
@@ -114,8 +196,12 @@ func GetRoomsByUser(c *fiber.Ctx) error {
 	if err != nil {
 		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": err.Error()})
 	}
+	status := c.Query("status")
+	if status != "" && status != models.RoomStatusActive && status != models.RoomStatusEnded && status != models.RoomStatusArchived {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "status must be active, ended or archived"})
+	}
 	q := queries.ChatQueries{DB: database.DB}
-	rooms, err := q.GetRoomsByUser(userID)
+	rooms, err := q.GetRoomsByUser(userID, status)
 	if err != nil {
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "failed to get rooms"})
 	}
@@ -136,6 +222,12 @@ func PostMessage(c *fiber.Ctx) error {
 	if err != nil {
 		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "invalid room_id"})
 	}
+	if status, err := checkRoomMember(roomID, userID); err != nil {
+		return c.Status(status).JSON(fiber.Map{"error": err.Error()})
+	}
+	if status, err := checkRoomActive(roomID); err != nil {
+		return c.Status(status).JSON(fiber.Map{"error": err.Error()})
+	}
 	vis := true
 	if p.Visible != nil {
 		vis = *p.Visible
@@ -147,6 +239,9 @@ func PostMessage(c *fiber.Ctx) error {
 		Text:      p.Text,
 		Visible:   vis,
 		CreatedAt: time.Now()}
+	if status, err := setReplyTo(m, p.ReplyToMessageID); err != nil {
+		return c.Status(status).JSON(fiber.Map{"error": err.Error()})
+	}
 	q := queries.ChatQueries{DB: database.DB}
 	if err := q.CreateMessage(m); err != nil {
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "failed to create message"})
@@ -174,39 +269,132 @@ func GetMessagesByRoom(c *fiber.Ctx) error {
 	if err != nil {
 		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "invalid room_id"})
 	}
-	limit := 100
+	if status, err := checkRoomMember(roomID, userID); err != nil {
+		return c.Status(status).JSON(fiber.Map{"error": err.Error()})
+	}
+	limit := c.QueryInt("limit", defaultMessagePageSize)
+	if limit <= 0 {
+		limit = defaultMessagePageSize
+	}
+	if limit > maxMessagePageSize {
+		limit = maxMessagePageSize
+	}
+	before, after := c.Query("before"), c.Query("after")
+	if before != "" && after != "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "use either before or after, not both"})
+	}
+
 	q := queries.ChatQueries{DB: database.DB}
-	msgs, err := q.GetMessagesByRoom(roomID, limit)
+	// since_id syncs a reconnecting client: everything newer than its last seen message
+	if s := c.Query("since_id"); s != "" {
+		if before != "" || after != "" {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "since_id cannot be combined with before or after"})
+		}
+		sinceID, err := uuid.Parse(s)
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "invalid since_id"})
+		}
+		if after, err = q.GetMessageCursor(roomID, sinceID); err != nil {
+			if err.Error() == "message not found" {
+				return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": err.Error()})
+			}
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "failed to get messages"})
+		}
+	}
+
+	msgs, prev, next, err := q.GetMessagesByRoom(roomID, before, after, limit)
 	if err != nil {
+		if err.Error() == "invalid cursor" {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+		}
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "failed to get messages"})
 	}
+	if prev != "" {
+		c.Set("X-Prev-Cursor", prev)
+	}
+	if next != "" {
+		c.Set("X-Next-Cursor", next)
+	}
 
 	type Message struct {
-		ID        uuid.UUID `json:"id"`
-		RoomID    uuid.UUID `json:"room_id"`
-		UserID    uuid.UUID `json:"user_id"`
-		Text      string    `json:"text"`
-		Visible   bool      `json:"visible"`
-		CreatedAt time.Time `json:"created_at"`
-		IsMe      bool      `json:"is_me"`
+		models.Message
+		IsMe bool `json:"is_me"`
 	}
 
 	out := make([]Message, 0, len(msgs))
 	for _, m := range msgs {
-		out = append(out, Message{
-			ID:        m.ID,
-			RoomID:    m.RoomID,
-			UserID:    m.UserID,
-			Text:      m.Text,
-			Visible:   m.Visible,
-			CreatedAt: m.CreatedAt,
-			IsMe:      m.UserID == userID,
-		})
+		out = append(out, Message{Message: withAttachmentURLs(m), IsMe: m.UserID == userID})
 	}
 
 	return c.Status(fiber.StatusOK).JSON(out)
 }
 
+// SearchMessages searches the text of messages in the caller's rooms. q accepts web search syntax;
+// room_id and from/to (YYYY-MM-DD, inclusive) narrow the results, limit/offset page through them.
+func SearchMessages(c *fiber.Ctx) error {
+	userID, err := utils.ExtractUserIDFromHeader(c.Get("Authorization"))
+	if err != nil {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": err.Error()})
+	}
+	text := strings.TrimSpace(c.Query("q"))
+	if len([]rune(text)) < 2 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "q must be at least 2 characters"})
+	}
+	if len(text) > 200 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "q must be at most 200 characters"})
+	}
+
+	var roomID *uuid.UUID
+	if s := c.Query("room_id"); s != "" {
+		id, err := uuid.Parse(s)
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "invalid room_id"})
+		}
+		if status, err := checkRoomMember(id, userID); err != nil {
+			return c.Status(status).JSON(fiber.Map{"error": err.Error()})
+		}
+		roomID = &id
+	}
+	var from, to *time.Time
+	if s := c.Query("from"); s != "" {
+		t, err := time.ParseInLocation("2006-01-02", s, time.Local)
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "invalid from, use YYYY-MM-DD"})
+		}
+		from = &t
+	}
+	if s := c.Query("to"); s != "" {
+		t, err := time.ParseInLocation("2006-01-02", s, time.Local)
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "invalid to, use YYYY-MM-DD"})
+		}
+		t = t.AddDate(0, 0, 1)
+		to = &t
+	}
+	if from != nil && to != nil && !to.After(*from) {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "to must not be before from"})
+	}
+
+	limit := c.QueryInt("limit", defaultSearchPageSize)
+	if limit <= 0 {
+		limit = defaultSearchPageSize
+	}
+	if limit > maxSearchPageSize {
+		limit = maxSearchPageSize
+	}
+	offset := c.QueryInt("offset", 0)
+	if offset < 0 {
+		offset = 0
+	}
+
+	q := queries.ChatQueries{DB: database.DB}
+	results, err := q.SearchMessages(userID, text, roomID, from, to, limit, offset)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "failed to search messages"})
+	}
+	return c.Status(fiber.StatusOK).JSON(results)
+}
+
 func GetRecentChats(c *fiber.Ctx) error {
 	authHeader := c.Get("Authorization")
 	userID, err := utils.ExtractUserIDFromHeader(authHeader)
@@ -238,6 +426,7 @@ func GetRecentChatsAsTarget(c *fiber.Ctx) error {
 	return c.Status(fiber.StatusOK).JSON(recent)
 }
 
+// GetActiveRoom returns the caller's current session: their most recent active direct room
 func GetActiveRoom(c *fiber.Ctx) error {
 	authHeader := c.Get("Authorization")
 	userID, err := utils.ExtractUserIDFromHeader(authHeader)
@@ -245,34 +434,8 @@ func GetActiveRoom(c *fiber.Ctx) error {
 		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": err.Error()})
 	}
 
-	payload := struct {
-		Time string `json:"time"`
-	}{}
-	if err := c.BodyParser(&payload); err != nil {
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "invalid body"})
-	}
-	if payload.Time == "" {
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "time required"})
-	}
-
-	var t time.Time
-	var parseErr error
-	layouts := []string{"2006-01-02 15:04", "2006-01-02 15:04:05", "2006-01-02"}
-	for _, lay := range layouts {
-		t, parseErr = time.Parse(lay, payload.Time)
-		if parseErr == nil {
-			break
-		}
-	}
-	if parseErr != nil {
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "invalid time format. use YYYY-MM-DD or YYYY-MM-DD HH:MM[:SS]"})
-	}
-
-	endTime := t
-	startTime := t.Add(-30 * time.Minute)
-
 	q := queries.ChatQueries{DB: database.DB}
-	r, err := q.GetActiveRoom(userID, startTime, endTime)
+	r, err := q.GetActiveRoom(userID)
 	if err != nil {
 		if err.Error() == "no active room" {
 			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "no active room"})
@@ -25,6 +25,13 @@ func CreateUserGoal(c *fiber.Ctx) error {
 	if err := c.BodyParser(req); err != nil {
 		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid request body"})
 	}
+	ok, err := categoryAllowed(req.GoalCategory, models.CategoryScopeGoal)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Unable to validate goal_category"})
+	}
+	if !ok {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Unknown goal_category"})
+	}
 
 	startDate, err := time.Parse("2006-01-02", req.StartDate)
 	if err != nil {
@@ -194,6 +201,13 @@ func CreateMission(c *fiber.Ctx) error {
 	if err := c.BodyParser(&payload); err != nil {
 		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid request body"})
 	}
+	ok, err := categoryAllowed(payload.Category, models.CategoryScopeGoal)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Unable to validate category"})
+	}
+	if !ok {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Unknown category"})
+	}
 	m := &models.Mission{ID: uuid.New(), DayNumber: payload.DayNumber, Focus: payload.Focus, Category: payload.Category}
 	mq := queries.MissionsQueries{DB: database.DB}
 	if err := mq.CreateMission(m); err != nil {
@@ -0,0 +1,159 @@
+package controllers
+
+import (
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/gilanghuda/sobi-backend/app/models"
+	"github.com/gilanghuda/sobi-backend/app/queries"
+	"github.com/gilanghuda/sobi-backend/pkg/database"
+	"github.com/gilanghuda/sobi-backend/pkg/utils"
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+)
+
+const defaultCommissionPercent = 20
+
+// platformCommissionPercent reads PLATFORM_COMMISSION_PERCENT, defaulting to 20%
+func platformCommissionPercent() int64 {
+	v, err := strconv.ParseInt(os.Getenv("PLATFORM_COMMISSION_PERCENT"), 10, 64)
+	if err != nil || v < 0 || v > 100 {
+		return defaultCommissionPercent
+	}
+	return v
+}
+
+// postTransactionToLedger books a completed transaction into the ledger; failures are logged
+// so a webhook is never rejected because of bookkeeping.
+func postTransactionToLedger(txID uuid.UUID) {
+	tq := queries.TransactionQueries{DB: database.DB}
+	t, err := tq.GetTransactionByID(txID)
+	if err != nil {
+		log.Printf("event=ledger_error transaction=%s err=%v", txID, err)
+		return
+	}
+	commission := platformCommissionPercent()
+	if t.Kind == models.TransactionKindTip {
+		commission = tipCommissionPercent()
+	}
+	lq := queries.LedgerQueries{DB: database.DB}
+	if err := lq.PostTransactionPayment(t, commission); err != nil {
+		log.Printf("event=ledger_error transaction=%s err=%v", txID, err)
+	}
+}
+
+func CreatePayoutBatch(c *fiber.Ctx) error {
+	authHeader := c.Get("Authorization")
+	adminID, err := utils.ExtractUserIDFromHeader(authHeader)
+	if err != nil {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	p := &models.CreatePayoutBatchRequest{}
+	if err := c.BodyParser(p); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "invalid body"})
+	}
+
+	var ahliID *uuid.UUID
+	if p.AhliID != "" {
+		id, err := uuid.Parse(p.AhliID)
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "invalid ahli_id"})
+		}
+		ahliID = &id
+	}
+	until := time.Now()
+	if p.Until != "" {
+		t, err := time.Parse("2006-01-02", p.Until)
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "invalid until, use YYYY-MM-DD"})
+		}
+		until = t.AddDate(0, 0, 1)
+	}
+
+	q := queries.LedgerQueries{DB: database.DB}
+	payouts, err := q.CreatePayoutBatch(adminID, ahliID, until)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "failed to create payout batch"})
+	}
+
+	var total int64
+	for _, po := range payouts {
+		total += po.Amount
+	}
+	return c.Status(fiber.StatusCreated).JSON(fiber.Map{"payouts": payouts, "total": total})
+}
+
+func GetPayouts(c *fiber.Ctx) error {
+	var ahliID *uuid.UUID
+	if s := c.Query("ahli_id"); s != "" {
+		id, err := uuid.Parse(s)
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "invalid ahli_id"})
+		}
+		ahliID = &id
+	}
+	limit := c.QueryInt("limit", 100)
+	if limit <= 0 || limit > 500 {
+		limit = 100
+	}
+
+	q := queries.LedgerQueries{DB: database.DB}
+	payouts, err := q.GetPayouts(ahliID, limit)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "failed to get payouts"})
+	}
+	return c.Status(fiber.StatusOK).JSON(payouts)
+}
+
+// ExportSettlement writes the monthly settlement of one ahli as CSV
+func ExportSettlement(c *fiber.Ctx) error {
+	ahliID, err := uuid.Parse(c.Query("ahli_id"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "valid ahli_id required"})
+	}
+	month, err := time.ParseInLocation("2006-01", c.Query("month"), time.Local)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "month required, use YYYY-MM"})
+	}
+
+	q := queries.LedgerQueries{DB: database.DB}
+	rows, err := q.GetSettlement(ahliID, month, month.AddDate(0, 1, 0))
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "failed to get settlement"})
+	}
+
+	var buf bytes.Buffer
+	w := csv.NewWriter(&buf)
+	_ = w.Write([]string{"transaction_id", "completed_at", "user_payment", "platform_commission", "ahli_earnings", "status", "payout_id"})
+	var payment, commission, earnings int64
+	for _, r := range rows {
+		payoutID := ""
+		if r.PayoutID != nil {
+			payoutID = r.PayoutID.String()
+		}
+		_ = w.Write([]string{
+			r.TransactionID.String(),
+			r.CompletedAt.Format(time.RFC3339),
+			strconv.FormatInt(r.UserPayment, 10),
+			strconv.FormatInt(r.Commission, 10),
+			strconv.FormatInt(r.AhliEarnings, 10),
+			r.Status,
+			payoutID,
+		})
+		payment += r.UserPayment
+		commission += r.Commission
+		earnings += r.AhliEarnings
+	}
+	_ = w.Write([]string{"total", "", strconv.FormatInt(payment, 10), strconv.FormatInt(commission, 10), strconv.FormatInt(earnings, 10), "", ""})
+	w.Flush()
+
+	c.Set(fiber.HeaderContentType, "text/csv")
+	c.Set(fiber.HeaderContentDisposition, fmt.Sprintf(`attachment; filename="settlement-%s-%s.csv"`, ahliID, month.Format("2006-01")))
+	return c.Status(fiber.StatusOK).Send(buf.Bytes())
+}
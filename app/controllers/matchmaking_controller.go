@@ -2,6 +2,7 @@ package controllers
 
 import (
 	"encoding/json"
+	"errors"
 	"log"
 	"net/http"
 	"sync"
@@ -10,6 +11,8 @@ import (
 	"github.com/gofiber/websocket/v2"
 	"github.com/google/uuid"
 
+	"github.com/gilanghuda/sobi-backend/app/queries"
+	"github.com/gilanghuda/sobi-backend/pkg/database"
 	"github.com/gilanghuda/sobi-backend/pkg/utils"
 
 	"github.com/gilanghuda/sobi-backend/app/models"
@@ -111,6 +114,9 @@ func oppositeRole(role string) string {
 	return "pencerita"
 }
 
+// typingTimeoutSeconds is how long a typing indicator stays visible without a new typing_start
+const typingTimeoutSeconds = 10
+
 // Global matcher instance (single in-memory instance)
 var Matcher = NewMatchMaker(60 * time.Second)
 
@@ -118,6 +124,66 @@ var Matcher = NewMatchMaker(60 * time.Second)
 var roomMembersMu sync.RWMutex
 var roomMembers = make(map[string][]uuid.UUID)
 
+// roomMembersFor returns the members of a room a websocket user wants to message, or an
+// error when the user is anonymous or not a member. Matched rooms are known in memory;
+// other rooms are looked up in the database.
+func roomMembersFor(roomID string, userID uuid.UUID) ([]uuid.UUID, error) {
+	if userID == uuid.Nil {
+		return nil, errors.New("authentication required")
+	}
+	if roomID == "" {
+		return nil, errors.New("room_id required")
+	}
+	roomMembersMu.RLock()
+	members, ok := roomMembers[roomID]
+	roomMembersMu.RUnlock()
+	if !ok {
+		id, err := uuid.Parse(roomID)
+		if err != nil {
+			return nil, errors.New("invalid room_id")
+		}
+		cq := queries.ChatQueries{DB: database.DB}
+		if members, err = cq.GetRoomMemberIDs(id); err != nil {
+			return nil, err
+		}
+	}
+	for _, m := range members {
+		if m == userID {
+			return members, nil
+		}
+	}
+	return nil, errors.New("you are not a member of this room")
+}
+
+// routeRoomMessage relays a websocket chat message to the other members of its room. These messages
+// are not stored, so the room's activity is recorded here to keep it from being closed as idle.
+func routeRoomMessage(userID uuid.UUID, payload map[string]interface{}) {
+	roomID, _ := payload["room_id"].(string)
+	members, err := roomMembersFor(roomID, userID)
+	rid, _ := uuid.Parse(roomID)
+	if err == nil {
+		// matched rooms that failed to persist are only known in memory and stay routable
+		if status, activeErr := checkRoomActive(rid); activeErr != nil && status != fiber.StatusNotFound {
+			err = activeErr
+		}
+	}
+	if err != nil {
+		_ = utils.DefaultNotifier.Send(userID, map[string]string{"event": "error", "room_id": roomID, "error": err.Error()})
+		log.Printf("event=ws_message_rejected user=%s room=%s err=%v", userID, roomID, err)
+		return
+	}
+	cq := queries.ChatQueries{DB: database.DB}
+	if err := cq.TouchRoom(rid); err != nil {
+		log.Printf("event=room_touch_error room=%s err=%v", roomID, err)
+	}
+	for _, member := range members {
+		if member == userID {
+			continue
+		}
+		_ = utils.DefaultNotifier.Send(member, payload)
+	}
+}
+
 // WsHandlerFiber is Fiber-compatible websocket handler. Accepts token query param and extracts user id.
 func WsHandlerFiber(c *websocket.Conn) {
 	// accept token from query string (frontend may pass JWT here)
@@ -131,6 +197,9 @@ func WsHandlerFiber(c *websocket.Conn) {
 	// register connection (userID may be uuid.Nil if unauthenticated)
 	utils.DefaultNotifier.Register(userID, c)
 	log.Printf("event=ws_connected user=%s", userID.String())
+	if userID != uuid.Nil {
+		go announcePresence(userID, true, time.Now())
+	}
 
 	// read loop to detect close and to route incoming messages
 	for {
@@ -152,24 +221,42 @@ func WsHandlerFiber(c *websocket.Conn) {
 
 		evt, _ := payload["event"].(string)
 		if evt == "message" {
+			routeRoomMessage(userID, payload)
+			continue
+		}
+		if evt == "read" {
 			roomID, _ := payload["room_id"].(string)
-			if roomID != "" {
-				roomMembersMu.RLock()
-				members := roomMembers[roomID]
-				roomMembersMu.RUnlock()
-				for _, member := range members {
-					if userID != uuid.Nil && member == userID {
-						continue
-					}
-					_ = utils.DefaultNotifier.Send(member, payload)
+			if _, err := roomMembersFor(roomID, userID); err != nil {
+				_ = utils.DefaultNotifier.Send(userID, map[string]string{"event": "error", "room_id": roomID, "error": err.Error()})
+				continue
+			}
+			var messageID *uuid.UUID
+			if s, _ := payload["message_id"].(string); s != "" {
+				if id, err := uuid.Parse(s); err == nil {
+					messageID = &id
 				}
-			} else {
-				// broadcast to all other connected users
-				for _, uid := range utils.DefaultNotifier.ActiveUserIDs() {
-					if userID != uuid.Nil && uid == userID {
-						continue
-					}
-					_ = utils.DefaultNotifier.Send(uid, payload)
+			}
+			rid, _ := uuid.Parse(roomID)
+			if _, err := markRoomRead(rid, userID, messageID); err != nil {
+				_ = utils.DefaultNotifier.Send(userID, map[string]string{"event": "error", "room_id": roomID, "error": err.Error()})
+			}
+			continue
+		}
+		if evt == "typing_start" || evt == "typing_stop" {
+			roomID, _ := payload["room_id"].(string)
+			members, err := roomMembersFor(roomID, userID)
+			if err != nil {
+				_ = utils.DefaultNotifier.Send(userID, map[string]string{"event": "error", "room_id": roomID, "error": err.Error()})
+				continue
+			}
+			typing := map[string]interface{}{"event": evt, "room_id": roomID, "user_id": userID}
+			if evt == "typing_start" {
+				// clients drop the indicator on their own if typing_stop never arrives
+				typing["expires_in"] = typingTimeoutSeconds
+			}
+			for _, member := range members {
+				if member != userID {
+					_ = utils.DefaultNotifier.Send(member, typing)
 				}
 			}
 			continue
@@ -177,7 +264,10 @@ func WsHandlerFiber(c *websocket.Conn) {
 		// handle other events if needed
 	}
 
-	utils.DefaultNotifier.Unregister(userID)
+	// only drop the registration if a newer connection of the same user has not replaced it
+	if utils.DefaultNotifier.UnregisterConn(userID, c) && userID != uuid.Nil {
+		onWsDisconnected(userID)
+	}
 
 	// Snapshot categories under lock to avoid double-locking when calling removeByUser
 	Matcher.mu.Lock()
@@ -224,6 +314,13 @@ func MatchmakingHandler(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "category required", http.StatusBadRequest)
 		return
 	}
+	if ok, err := categoryAllowed(req.Category, models.CategoryScopeRoom); err != nil {
+		http.Error(w, "unable to validate category", http.StatusInternalServerError)
+		return
+	} else if !ok {
+		http.Error(w, "unknown category", http.StatusBadRequest)
+		return
+	}
 
 	opp := Matcher.dequeueOpposite(req.Category, req.Role)
 	if opp == nil {
@@ -234,16 +331,26 @@ func MatchmakingHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	listenerID := opp.UserID
+	if req.Role == "pendengar" {
+		listenerID = userID
+	}
 	room := &models.Room{
-		ID:        uuid.New(),
-		OwnerID:   userID,
-		TargetID:  &opp.UserID,
-		Category:  req.Category,
-		Visible:   true,
-		CreatedAt: time.Now(),
-		UpdatedAt: time.Now(),
-	}
-	// In-memory placeholder: in production save to DB.
+		ID:         uuid.New(),
+		OwnerID:    userID,
+		TargetID:   &opp.UserID,
+		ListenerID: &listenerID,
+		Category:   req.Category,
+		Visible:    true,
+		CreatedAt:  time.Now(),
+		UpdatedAt:  time.Now(),
+	}
+	// the room is persisted so the pencerita can tip the pendengar afterwards;
+	// routing keeps working from memory if the insert fails
+	cq := queries.ChatQueries{DB: database.DB}
+	if err := cq.CreateRoom(room); err != nil {
+		log.Printf("event=room_persist_error room=%s err=%v", room.ID, err)
+	}
 	log.Printf("event=match_success room=%s user1=%s user2=%s category=%s", room.ID, userID, opp.UserID, req.Category)
 
 	// register room members so WS handler can route messages
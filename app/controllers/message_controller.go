@@ -0,0 +1,256 @@
+package controllers
+
+import (
+	"errors"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/gilanghuda/sobi-backend/app/models"
+	"github.com/gilanghuda/sobi-backend/app/queries"
+	"github.com/gilanghuda/sobi-backend/pkg/database"
+	"github.com/gilanghuda/sobi-backend/pkg/utils"
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+)
+
+// messageEditWindow is how long after sending a message its sender may edit or delete it
+func messageEditWindow() time.Duration {
+	return envMinutes("MESSAGE_EDIT_WINDOW_MINUTES", 15)
+}
+
+// loadMessage fetches the message named by the :id param; deleted messages count as missing
+func loadMessage(c *fiber.Ctx) (models.Message, int, error) {
+	id, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return models.Message{}, fiber.StatusBadRequest, errors.New("invalid message id")
+	}
+	q := queries.ChatQueries{DB: database.DB}
+	m, err := q.GetMessageByID(id)
+	if err != nil {
+		if err.Error() == "message not found" {
+			return m, fiber.StatusNotFound, err
+		}
+		return m, fiber.StatusInternalServerError, err
+	}
+	if m.DeletedAt != nil {
+		return m, fiber.StatusNotFound, errors.New("message not found")
+	}
+	return m, fiber.StatusOK, nil
+}
+
+// setReplyTo makes m a reply to the message with the given id, which must be a live message in the same room
+func setReplyTo(m *models.Message, replyTo string) (int, error) {
+	if replyTo == "" {
+		return fiber.StatusOK, nil
+	}
+	id, err := uuid.Parse(replyTo)
+	if err != nil {
+		return fiber.StatusBadRequest, errors.New("invalid reply_to_message_id")
+	}
+	q := queries.ChatQueries{DB: database.DB}
+	preview, roomID, err := q.GetMessagePreview(id)
+	if err != nil {
+		if err.Error() == "message not found" {
+			return fiber.StatusBadRequest, errors.New("replied message not found")
+		}
+		return fiber.StatusInternalServerError, err
+	}
+	if roomID != m.RoomID {
+		return fiber.StatusBadRequest, errors.New("replied message not found")
+	}
+	if preview.Deleted {
+		return fiber.StatusBadRequest, errors.New("cannot reply to a deleted message")
+	}
+	m.ReplyToID = &id
+	m.ReplyTo = &preview
+	return fiber.StatusOK, nil
+}
+
+// validEmoji accepts a single emoji (possibly a multi-codepoint sequence) and rejects plain text
+func validEmoji(e string) bool {
+	if e == "" || len(e) > 64 || utf8.RuneCountInString(e) > 8 || !utf8.ValidString(e) {
+		return false
+	}
+	for _, r := range e {
+		if r < 0x80 && r != '#' && r != '*' && !unicode.IsDigit(r) {
+			return false
+		}
+		if unicode.IsSpace(r) || unicode.IsLetter(r) || unicode.IsControl(r) || (r >= 0x80 && unicode.IsNumber(r)) {
+			return false
+		}
+	}
+	// keycap sequences such as 1️⃣ are the only place digits, # and * may appear
+	if strings.ContainsAny(e, "0123456789#*") && !strings.ContainsRune(e, '\u20e3') {
+		return false
+	}
+	return true
+}
+
+// reactionTarget resolves the message and checks the caller may react to it
+func reactionTarget(c *fiber.Ctx) (models.Message, uuid.UUID, int, error) {
+	userID, err := utils.ExtractUserIDFromHeader(c.Get("Authorization"))
+	if err != nil {
+		return models.Message{}, userID, fiber.StatusUnauthorized, err
+	}
+	m, status, err := loadMessage(c)
+	if err != nil {
+		return m, userID, status, err
+	}
+	if status, err := checkRoomMember(m.RoomID, userID); err != nil {
+		return m, userID, status, err
+	}
+	return m, userID, fiber.StatusOK, nil
+}
+
+// AddReaction adds the caller's emoji reaction to a message; reacting twice with the same emoji is a no-op
+func AddReaction(c *fiber.Ctx) error {
+	req := &models.ReactionRequest{}
+	if err := c.BodyParser(req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "invalid body"})
+	}
+	if !validEmoji(req.Emoji) {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "emoji must be a single emoji"})
+	}
+	m, userID, status, err := reactionTarget(c)
+	if err != nil {
+		return c.Status(status).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	q := queries.ChatQueries{DB: database.DB}
+	added, err := q.AddReaction(m.ID, userID, req.Emoji)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "failed to add reaction"})
+	}
+	reactions, err := q.GetReactions(m.ID)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "failed to get reactions"})
+	}
+	if added {
+		broadcastToRoom(m.RoomID, map[string]interface{}{"event": "reaction_added", "message_id": m.ID, "room_id": m.RoomID, "user_id": userID, "emoji": req.Emoji, "reactions": reactions}, uuid.Nil)
+	}
+	return c.Status(fiber.StatusOK).JSON(reactions)
+}
+
+// RemoveReaction takes back the caller's reaction given in the emoji query param
+func RemoveReaction(c *fiber.Ctx) error {
+	emoji := c.Query("emoji")
+	if !validEmoji(emoji) {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "emoji must be a single emoji"})
+	}
+	m, userID, status, err := reactionTarget(c)
+	if err != nil {
+		return c.Status(status).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	q := queries.ChatQueries{DB: database.DB}
+	removed, err := q.RemoveReaction(m.ID, userID, emoji)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "failed to remove reaction"})
+	}
+	if !removed {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "reaction not found"})
+	}
+	reactions, err := q.GetReactions(m.ID)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "failed to get reactions"})
+	}
+	broadcastToRoom(m.RoomID, map[string]interface{}{"event": "reaction_removed", "message_id": m.ID, "room_id": m.RoomID, "user_id": userID, "emoji": emoji, "reactions": reactions}, uuid.Nil)
+	return c.Status(fiber.StatusOK).JSON(reactions)
+}
+
+// EditMessage lets the sender change the text of their own message within the edit window
+func EditMessage(c *fiber.Ctx) error {
+	userID, err := utils.ExtractUserIDFromHeader(c.Get("Authorization"))
+	if err != nil {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": err.Error()})
+	}
+	req := &models.EditMessageRequest{}
+	if err := c.BodyParser(req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "invalid body"})
+	}
+	if strings.TrimSpace(req.Text) == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "text required"})
+	}
+
+	m, status, err := loadMessage(c)
+	if err != nil {
+		return c.Status(status).JSON(fiber.Map{"error": err.Error()})
+	}
+	if m.UserID != userID {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"error": "you can only edit your own messages"})
+	}
+	if time.Since(m.CreatedAt) > messageEditWindow() {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"error": "the edit window for this message has passed"})
+	}
+	if req.Text == m.Text {
+		return c.Status(fiber.StatusOK).JSON(m)
+	}
+
+	q := queries.ChatQueries{DB: database.DB}
+	if err := q.EditMessage(&m, userID, req.Text); err != nil {
+		if err.Error() == "message not found" {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": err.Error()})
+		}
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "failed to edit message"})
+	}
+	broadcastToRoom(m.RoomID, map[string]interface{}{"event": "message_edited", "id": m.ID, "room_id": m.RoomID, "user_id": m.UserID, "text": m.Text, "edited_at": m.EditedAt}, uuid.Nil)
+	return c.Status(fiber.StatusOK).JSON(m)
+}
+
+// DeleteMessage soft-deletes a message. Senders can delete their own messages within the edit
+// window; the owner and moderators of a group room can remove any message in it.
+func DeleteMessage(c *fiber.Ctx) error {
+	userID, err := utils.ExtractUserIDFromHeader(c.Get("Authorization"))
+	if err != nil {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": err.Error()})
+	}
+	m, status, err := loadMessage(c)
+	if err != nil {
+		return c.Status(status).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	q := queries.ChatQueries{DB: database.DB}
+	allowed := m.UserID == userID && time.Since(m.CreatedAt) <= messageEditWindow()
+	if !allowed {
+		room, err := q.GetRoomByID(m.RoomID)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "failed to get room"})
+		}
+		role, err := q.GetRoomMemberRole(m.RoomID, userID)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "failed to check room membership"})
+		}
+		allowed = room.IsGroup && (role == models.RoomRoleOwner || role == models.RoomRoleModerator)
+	}
+	if !allowed {
+		if m.UserID == userID {
+			return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"error": "the edit window for this message has passed"})
+		}
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"error": "you can only delete your own messages"})
+	}
+
+	if err := q.DeleteMessage(&m, userID); err != nil {
+		if err.Error() == "message not found" {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": err.Error()})
+		}
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "failed to delete message"})
+	}
+	broadcastToRoom(m.RoomID, map[string]interface{}{"event": "message_deleted", "id": m.ID, "room_id": m.RoomID, "deleted_by": userID, "deleted_at": m.DeletedAt}, uuid.Nil)
+	return c.Status(fiber.StatusOK).JSON(m)
+}
+
+// GetMessageAudits shows moderators the original text of an edited or deleted message
+func GetMessageAudits(c *fiber.Ctx) error {
+	id, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "invalid message id"})
+	}
+	q := queries.ChatQueries{DB: database.DB}
+	audits, err := q.GetMessageAudits(id)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "failed to get message audits"})
+	}
+	return c.Status(fiber.StatusOK).JSON(audits)
+}
@@ -1,12 +1,11 @@
 package controllers
 
 import (
-	"encoding/json"
 	"log"
 
 	"github.com/gilanghuda/sobi-backend/app/queries"
 	"github.com/gilanghuda/sobi-backend/pkg/database"
-	"github.com/gofiber/websocket/v2"
+	"github.com/gilanghuda/sobi-backend/pkg/utils"
 	"github.com/google/uuid"
 )
 
@@ -18,61 +17,35 @@ func StartMessageDispatcher() {
 				"id":         msg.ID,
 				"room_id":    msg.RoomID,
 				"user_id":    msg.UserID,
+				"type":       msg.Type,
 				"text":       msg.Text,
 				"visible":    msg.Visible,
 				"created_at": msg.CreatedAt,
 			}
-			data, err := json.Marshal(payload)
-			if err != nil {
-				log.Printf("dispatcher: marshal error: %v", err)
-				continue
+			if len(msg.Attachments) > 0 {
+				payload["attachments"] = msg.Attachments
 			}
-
-			q := queries.ChatQueries{DB: database.DB}
-			room, err := q.GetRoomByID(msg.RoomID)
-			if err != nil {
-				log.Printf("dispatcher: failed to get room %v: %v", msg.RoomID, err)
-				// fallback: notify message sender
-				sendPayloadToUser(msg.UserID, data)
-				continue
-			}
-
-			sendPayloadToUser(room.OwnerID, data)
-			if room.TargetID != nil && *room.TargetID != room.OwnerID {
-				sendPayloadToUser(*room.TargetID, data)
+			if msg.ReplyToID != nil {
+				payload["reply_to_message_id"] = msg.ReplyToID
+				payload["reply_to"] = msg.ReplyTo
 			}
+			broadcastToRoom(msg.RoomID, payload, uuid.Nil)
 		}
 	}()
 }
 
-func sendPayloadToUser(uid uuid.UUID, data []byte) {
-	clientsMu.RLock()
-	connsMap, ok := clientsByUser[uid]
-	if !ok || len(connsMap) == 0 {
-		clientsMu.RUnlock()
+// broadcastToRoom sends an event to every member of a room except one user (uuid.Nil for none)
+func broadcastToRoom(roomID uuid.UUID, payload map[string]interface{}, except uuid.UUID) {
+	q := queries.ChatQueries{DB: database.DB}
+	members, err := q.GetRoomMemberIDs(roomID)
+	if err != nil {
+		log.Printf("dispatcher: failed to get members of room %v: %v", roomID, err)
 		return
 	}
-	conns := make([]*client, 0, len(connsMap))
-	for c := range connsMap {
-		conns = append(conns, c)
-	}
-	clientsMu.RUnlock()
-
-	for _, c := range conns {
-		if c == nil || c.conn == nil {
+	for _, uid := range members {
+		if uid == except {
 			continue
 		}
-		if err := c.conn.WriteMessage(websocket.TextMessage, data); err != nil {
-			log.Printf("dispatcher: write error to user %v: %v", uid, err)
-			clientsMu.Lock()
-			if m, ok := clientsByUser[uid]; ok {
-				delete(m, c)
-				if len(m) == 0 {
-					delete(clientsByUser, uid)
-				}
-			}
-			clientsMu.Unlock()
-			_ = c.conn.Close()
-		}
+		_ = utils.DefaultNotifier.Send(uid, payload)
 	}
 }
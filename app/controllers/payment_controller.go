@@ -0,0 +1,77 @@
+package controllers
+
+import (
+	"log"
+	"sync"
+
+	"github.com/gilanghuda/sobi-backend/app/queries"
+	"github.com/gilanghuda/sobi-backend/pkg/database"
+	"github.com/gilanghuda/sobi-backend/pkg/payment"
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+)
+
+var (
+	providerOnce sync.Once
+	provider     payment.Provider
+)
+
+// paymentProvider returns the gateway selected by PAYMENT_PROVIDER. The fake provider
+// delivers its simulated callbacks through the same path as real webhooks.
+func paymentProvider() payment.Provider {
+	providerOnce.Do(func() {
+		provider = payment.FromEnv()
+		if f, ok := provider.(*payment.Fake); ok {
+			f.Callback = func(body []byte) {
+				if _, err := receivePaymentNotification(body); err != nil {
+					log.Printf("event=fake_payment_callback err=%s", err)
+				}
+			}
+		}
+		log.Printf("event=payment_provider name=%s", provider.Name())
+	})
+	return provider
+}
+
+// SetPaymentProvider replaces the gateway read from the environment, e.g. with a payment.Fake in tests.
+// It must be called before the first payment is handled.
+func SetPaymentProvider(p payment.Provider) {
+	providerOnce.Do(func() {})
+	provider = p
+}
+
+// SimulatePayment drives a fake provider charge to a Midtrans status such as settlement or expire
+func SimulatePayment(c *fiber.Ctx) error {
+	f, ok := paymentProvider().(*payment.Fake)
+	if !ok {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "payment simulation is only available with the fake provider"})
+	}
+
+	id, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "invalid id"})
+	}
+	req := struct {
+		TransactionStatus string `json:"transaction_status"`
+	}{}
+	if err := c.BodyParser(&req); err != nil || req.TransactionStatus == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "transaction_status is required"})
+	}
+
+	q := queries.TransactionQueries{DB: database.DB}
+	tx, err := q.GetTransactionByID(id)
+	if err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "transaction not found"})
+	}
+	f.Track(tx.ID.String(), tx.Amount)
+
+	if _, err := f.Simulate(tx.ID.String(), req.TransactionStatus); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	tx, err = q.GetTransactionByID(id)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "failed to reload transaction"})
+	}
+	return c.Status(fiber.StatusOK).JSON(tx)
+}
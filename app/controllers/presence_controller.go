@@ -0,0 +1,115 @@
+package controllers
+
+import (
+	"log"
+	"strings"
+	"time"
+
+	"github.com/gilanghuda/sobi-backend/app/models"
+	"github.com/gilanghuda/sobi-backend/app/queries"
+	"github.com/gilanghuda/sobi-backend/pkg/database"
+	"github.com/gilanghuda/sobi-backend/pkg/utils"
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+)
+
+const maxPresenceUsers = 100
+
+// presenceOf reports the connection state of users: online comes from the notifier, last seen
+// from this process when known and from the users table otherwise
+func presenceOf(ids []uuid.UUID) ([]models.Presence, error) {
+	uq := queries.UserQueries{DB: database.DB}
+	stored, err := uq.GetLastSeen(ids)
+	if err != nil {
+		return nil, err
+	}
+	out := make([]models.Presence, 0, len(ids))
+	for _, id := range ids {
+		p := models.Presence{UserID: id, Online: utils.DefaultNotifier.IsOnline(id)}
+		if t, ok := utils.DefaultNotifier.LastSeen(id); ok {
+			p.LastSeen = &t
+		} else if t, ok := stored[id]; ok {
+			p.LastSeen = &t
+		}
+		out = append(out, p)
+	}
+	return out, nil
+}
+
+// announcePresence pushes a presence event to the connected users sharing a room with userID
+func announcePresence(userID uuid.UUID, online bool, at time.Time) {
+	cq := queries.ChatQueries{DB: database.DB}
+	contacts, err := cq.GetContactIDs(userID)
+	if err != nil {
+		log.Printf("event=presence_error user=%s err=%v", userID, err)
+		return
+	}
+	payload := map[string]interface{}{"event": "presence", "user_id": userID, "online": online}
+	if !online {
+		payload["last_seen"] = at
+	}
+	for _, id := range contacts {
+		if utils.DefaultNotifier.IsOnline(id) {
+			_ = utils.DefaultNotifier.Send(id, payload)
+		}
+	}
+}
+
+// onWsDisconnected records the last seen time and tells contacts the user went offline
+func onWsDisconnected(userID uuid.UUID) {
+	now := time.Now()
+	uq := queries.UserQueries{DB: database.DB}
+	if err := uq.UpdateLastSeen(userID, now); err != nil {
+		log.Printf("event=presence_error user=%s err=%v", userID, err)
+	}
+	announcePresence(userID, false, now)
+}
+
+// GetPresence returns online state and last seen time for a comma separated list of user_ids.
+// Only the caller and users who share a room with them can be looked up.
+func GetPresence(c *fiber.Ctx) error {
+	userID, err := utils.ExtractUserIDFromHeader(c.Get("Authorization"))
+	if err != nil {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": err.Error()})
+	}
+	raw := c.Query("user_ids")
+	if raw == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "user_ids required"})
+	}
+	ids := []uuid.UUID{}
+	seen := map[uuid.UUID]bool{}
+	for _, s := range strings.Split(raw, ",") {
+		id, err := uuid.Parse(strings.TrimSpace(s))
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "invalid user id: " + s})
+		}
+		if !seen[id] {
+			seen[id] = true
+			ids = append(ids, id)
+		}
+	}
+	if len(ids) > maxPresenceUsers {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "at most 100 user_ids per request"})
+	}
+
+	cq := queries.ChatQueries{DB: database.DB}
+	contacts, err := cq.GetContactIDs(userID)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "failed to get contacts"})
+	}
+	allowed := map[uuid.UUID]bool{userID: true}
+	for _, id := range contacts {
+		allowed[id] = true
+	}
+	for _, id := range ids {
+		if !allowed[id] {
+			return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"error": "not a contact: " + id.String()})
+		}
+	}
+
+	presence, err := presenceOf(ids)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "failed to get presence"})
+	}
+	return c.Status(fiber.StatusOK).JSON(presence)
+}
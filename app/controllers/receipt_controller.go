@@ -0,0 +1,143 @@
+package controllers
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"strings"
+	"time"
+
+	"github.com/gilanghuda/sobi-backend/app/models"
+	"github.com/gilanghuda/sobi-backend/app/queries"
+	"github.com/gilanghuda/sobi-backend/pkg/database"
+	"github.com/gilanghuda/sobi-backend/pkg/utils"
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+)
+
+var receiptTemplate = template.Must(template.New("receipt").Funcs(template.FuncMap{
+	"rupiah": formatRupiah,
+	"date":   func(t time.Time) string { return t.Format("02 Jan 2006 15:04") },
+	"net":    func(t models.Transaction) int64 { return t.Amount - t.RefundedAmount },
+	"gross":  func(t models.Transaction) int64 { return t.Amount + t.DiscountAmount },
+}).Parse(`<!DOCTYPE html>
+<html lang="id">
+<head>
+<meta charset="utf-8">
+<title>Receipt {{.Number}}</title>
+<style>
+body { font-family: Helvetica, Arial, sans-serif; color: #222; max-width: 640px; margin: 32px auto; }
+h1 { font-size: 22px; margin-bottom: 4px; }
+table { width: 100%; border-collapse: collapse; margin-top: 16px; }
+td { padding: 6px 0; border-bottom: 1px solid #eee; }
+td.v { text-align: right; }
+.total td { font-weight: bold; border-bottom: none; }
+</style>
+</head>
+<body>
+<h1>Sobi - Payment Receipt</h1>
+<div>No. {{.Number}}</div>
+<table>
+<tr><td>Transaction ID</td><td class="v">{{.Transaction.ID}}</td></tr>
+<tr><td>Date</td><td class="v">{{date .Transaction.CreatedAt}}</td></tr>
+<tr><td>Billed to</td><td class="v">{{.UserName}}{{if .UserEmail}} ({{.UserEmail}}){{end}}</td></tr>
+<tr><td>Ahli</td><td class="v">{{.AhliName}}{{if .AhliCategory}} - {{.AhliCategory}}{{end}}</td></tr>
+{{if .SessionStartAt}}<tr><td>Session</td><td class="v">{{date .SessionStartAt}}{{if .SessionEndAt}} - {{date .SessionEndAt}}{{end}}</td></tr>{{end}}
+<tr><td>Status</td><td class="v">{{.Transaction.Status}}</td></tr>
+{{if .Transaction.DiscountAmount}}<tr><td>Price</td><td class="v">{{rupiah (gross .Transaction)}}</td></tr>
+<tr><td>Voucher discount</td><td class="v">-{{rupiah .Transaction.DiscountAmount}}</td></tr>{{end}}
+<tr><td>Amount paid</td><td class="v">{{rupiah .Transaction.Amount}}</td></tr>
+{{if .Transaction.RefundedAmount}}<tr><td>Refunded</td><td class="v">-{{rupiah .Transaction.RefundedAmount}}</td></tr>{{end}}
+<tr class="total"><td>Total</td><td class="v">{{rupiah (net .Transaction)}}</td></tr>
+</table>
+<p style="font-size:12px;color:#777">Issued {{date .IssuedAt}}</p>
+</body>
+</html>
+`))
+
+// formatRupiah formats an amount as Rp 150.000
+func formatRupiah(amount int64) string {
+	s := fmt.Sprintf("%d", amount)
+	neg := strings.HasPrefix(s, "-")
+	s = strings.TrimPrefix(s, "-")
+	var b strings.Builder
+	for i, r := range s {
+		if i > 0 && (len(s)-i)%3 == 0 {
+			b.WriteByte('.')
+		}
+		b.WriteRune(r)
+	}
+	if neg {
+		return "-Rp " + b.String()
+	}
+	return "Rp " + b.String()
+}
+
+func receiptLines(r models.Receipt) []string {
+	lines := []string{
+		"# Sobi - Payment Receipt",
+		"No. " + r.Number,
+		"",
+		"Transaction ID: " + r.Transaction.ID.String(),
+		"Date: " + r.Transaction.CreatedAt.Format("02 Jan 2006 15:04"),
+		"Billed to: " + r.UserName + " (" + r.UserEmail + ")",
+		"Ahli: " + r.AhliName + " - " + r.AhliCategory,
+	}
+	if r.SessionStartAt != nil {
+		session := "Session: " + r.SessionStartAt.Format("02 Jan 2006 15:04")
+		if r.SessionEndAt != nil {
+			session += " - " + r.SessionEndAt.Format("15:04")
+		}
+		lines = append(lines, session)
+	}
+	lines = append(lines, "Status: "+r.Transaction.Status, "")
+	if r.Transaction.DiscountAmount > 0 {
+		lines = append(lines, "Price: "+formatRupiah(r.Transaction.Amount+r.Transaction.DiscountAmount), "Voucher discount: -"+formatRupiah(r.Transaction.DiscountAmount))
+	}
+	lines = append(lines, "Amount paid: "+formatRupiah(r.Transaction.Amount))
+	if r.Transaction.RefundedAmount > 0 {
+		lines = append(lines, "Refunded: -"+formatRupiah(r.Transaction.RefundedAmount))
+	}
+	lines = append(lines, "Total: "+formatRupiah(r.Transaction.Amount-r.Transaction.RefundedAmount), "", "Issued "+r.IssuedAt.Format("02 Jan 2006 15:04"))
+	return lines
+}
+
+// GetTransactionReceipt renders the receipt of a paid transaction as HTML (default) or PDF (?format=pdf)
+func GetTransactionReceipt(c *fiber.Ctx) error {
+	userID, err := utils.ExtractUserIDFromHeader(c.Get("Authorization"))
+	if err != nil {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": err.Error()})
+	}
+	id, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "invalid id"})
+	}
+	format := c.Query("format", "html")
+	if format != "html" && format != "pdf" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "format must be html or pdf"})
+	}
+
+	q := queries.TransactionQueries{DB: database.DB}
+	r, err := q.GetReceipt(id)
+	if err != nil || (r.Transaction.UserID != userID && r.Transaction.AhliID != userID) {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "transaction not found"})
+	}
+	if !models.Refundable(r.Transaction.Status) && r.Transaction.Status != models.TransactionRefunded {
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{"error": "receipts are only available for paid transactions"})
+	}
+
+	filename := "receipt-" + r.Number
+	if format == "pdf" {
+		c.Set(fiber.HeaderContentType, "application/pdf")
+		c.Set(fiber.HeaderContentDisposition, `attachment; filename="`+filename+`.pdf"`)
+		return c.Status(fiber.StatusOK).Send(utils.SimplePDF(receiptLines(r)))
+	}
+
+	var buf bytes.Buffer
+	if err := receiptTemplate.Execute(&buf, r); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "failed to render receipt"})
+	}
+	c.Set(fiber.HeaderContentType, fiber.MIMETextHTMLCharsetUTF8)
+	c.Set(fiber.HeaderContentDisposition, `inline; filename="`+filename+`.html"`)
+	return c.Status(fiber.StatusOK).Send(buf.Bytes())
+}
@@ -0,0 +1,373 @@
+package controllers
+
+import (
+	"context"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/gilanghuda/sobi-backend/app/models"
+	"github.com/gilanghuda/sobi-backend/app/queries"
+	"github.com/gilanghuda/sobi-backend/pkg/database"
+	"github.com/gilanghuda/sobi-backend/pkg/utils"
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+)
+
+// RequestRefund lets the paying user ask for a full or partial refund of a transaction
+func RequestRefund(c *fiber.Ctx) error {
+	authHeader := c.Get("Authorization")
+	userID, err := utils.ExtractUserIDFromHeader(authHeader)
+	if err != nil {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	txID, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "invalid id"})
+	}
+	p := &models.CreateRefundRequest{}
+	if err := c.BodyParser(p); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "invalid body"})
+	}
+	p.Reason = strings.TrimSpace(p.Reason)
+	if p.Reason == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "reason is required"})
+	}
+
+	tq := queries.TransactionQueries{DB: database.DB}
+	tx, err := tq.GetTransactionByID(txID)
+	if err != nil || tx.UserID != userID {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "transaction not found"})
+	}
+	if !models.Refundable(tx.Status) {
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{"error": "only paid transactions can be refunded"})
+	}
+	remaining := tx.Amount - tx.RefundedAmount
+	if p.Amount == 0 {
+		p.Amount = remaining
+	}
+	if p.Amount < 0 || p.Amount > remaining {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "amount must be between 1 and the refundable amount"})
+	}
+
+	r := &models.RefundRequest{ID: uuid.New(), TransactionID: tx.ID, UserID: tx.UserID, AhliID: tx.AhliID, RequestedBy: &userID, Amount: p.Amount, Reason: p.Reason, Status: models.RefundPending, CreatedAt: time.Now(), UpdatedAt: time.Now()}
+	q := queries.RefundQueries{DB: database.DB}
+	if err := q.CreateRefundRequest(r); err != nil {
+		if err.Error() == "a refund for this transaction is already open" {
+			return c.Status(fiber.StatusConflict).JSON(fiber.Map{"error": err.Error()})
+		}
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "failed to create refund request"})
+	}
+	return c.Status(fiber.StatusCreated).JSON(r)
+}
+
+// GetTransactionRefunds lists the refund requests of a transaction to its user or ahli
+func GetTransactionRefunds(c *fiber.Ctx) error {
+	authHeader := c.Get("Authorization")
+	userID, err := utils.ExtractUserIDFromHeader(authHeader)
+	if err != nil {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	txID, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "invalid id"})
+	}
+	tq := queries.TransactionQueries{DB: database.DB}
+	tx, err := tq.GetTransactionByID(txID)
+	if err != nil || (tx.UserID != userID && tx.AhliID != userID) {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "transaction not found"})
+	}
+
+	q := queries.RefundQueries{DB: database.DB}
+	list, err := q.GetRefundRequests("", &txID, nil, 100)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "failed to get refund requests"})
+	}
+	return c.Status(fiber.StatusOK).JSON(list)
+}
+
+func AdminGetRefunds(c *fiber.Ctx) error {
+	return listRefunds(c, nil)
+}
+
+func AdminApproveRefund(c *fiber.Ctx) error {
+	return decideRefund(c, nil, true)
+}
+
+func AdminRejectRefund(c *fiber.Ctx) error {
+	return decideRefund(c, nil, false)
+}
+
+func GetAhliRefunds(c *fiber.Ctx) error {
+	ahliID, err := utils.ExtractUserIDFromHeader(c.Get("Authorization"))
+	if err != nil {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": err.Error()})
+	}
+	return listRefunds(c, &ahliID)
+}
+
+func AhliApproveRefund(c *fiber.Ctx) error {
+	ahliID, err := utils.ExtractUserIDFromHeader(c.Get("Authorization"))
+	if err != nil {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": err.Error()})
+	}
+	return decideRefund(c, &ahliID, true)
+}
+
+func AhliRejectRefund(c *fiber.Ctx) error {
+	ahliID, err := utils.ExtractUserIDFromHeader(c.Get("Authorization"))
+	if err != nil {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": err.Error()})
+	}
+	return decideRefund(c, &ahliID, false)
+}
+
+// listRefunds lists refund requests, limited to one ahli's transactions when ahliID is set
+func listRefunds(c *fiber.Ctx, ahliID *uuid.UUID) error {
+	status := c.Query("status")
+	limit := c.QueryInt("limit", 100)
+	if limit <= 0 || limit > 500 {
+		limit = 100
+	}
+	q := queries.RefundQueries{DB: database.DB}
+	list, err := q.GetRefundRequests(status, nil, ahliID, limit)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "failed to get refund requests"})
+	}
+	return c.Status(fiber.StatusOK).JSON(list)
+}
+
+// decideRefund approves or rejects a pending refund request. An ahli may only decide
+// requests on their own transactions; admins pass a nil ahliID.
+func decideRefund(c *fiber.Ctx, ahliID *uuid.UUID, approve bool) error {
+	deciderID, err := utils.ExtractUserIDFromHeader(c.Get("Authorization"))
+	if err != nil {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": err.Error()})
+	}
+	id, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "invalid id"})
+	}
+	p := &models.RefundDecisionRequest{}
+	if len(c.Body()) > 0 {
+		if err := c.BodyParser(p); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "invalid body"})
+		}
+	}
+
+	q := queries.RefundQueries{DB: database.DB}
+	r, err := q.GetRefundRequest(id)
+	if err != nil || (ahliID != nil && r.AhliID != *ahliID) {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "refund request not found"})
+	}
+	if r.Status != models.RefundPending {
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{"error": "refund request is already " + r.Status})
+	}
+
+	var note *string
+	if p.Note != "" {
+		note = &p.Note
+	}
+	if !approve {
+		ok, err := q.DecideRefundRequest(r.ID, models.RefundRejected, r.Amount, deciderID, note)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
+		}
+		if !ok {
+			return c.Status(fiber.StatusConflict).JSON(fiber.Map{"error": "refund request was already decided"})
+		}
+		r, _ = q.GetRefundRequest(r.ID)
+		return c.Status(fiber.StatusOK).JSON(r)
+	}
+
+	if p.Amount != 0 {
+		if p.Amount < 0 || p.Amount > r.Amount {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "approved amount must be between 1 and the requested amount"})
+		}
+		r.Amount = p.Amount
+	}
+	ok, err := q.DecideRefundRequest(r.ID, models.RefundApproved, r.Amount, deciderID, note)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
+	}
+	if !ok {
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{"error": "refund request was already decided"})
+	}
+
+	if err := executeRefund(r); err != nil {
+		r, _ = q.GetRefundRequest(r.ID)
+		return c.Status(fiber.StatusBadGateway).JSON(fiber.Map{"error": err.Error(), "refund": r})
+	}
+	r, _ = q.GetRefundRequest(r.ID)
+	return c.Status(fiber.StatusOK).JSON(r)
+}
+
+// executeRefund sends an approved refund to the payment provider and books it locally.
+// A provider failure marks the request failed; a booking failure after the provider refunded
+// leaves it provider_refunded so retryProviderRefunds can finish it.
+func executeRefund(r models.RefundRequest) error {
+	q := queries.RefundQueries{DB: database.DB}
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	if _, err := paymentProvider().Refund(ctx, r.TransactionID.String(), r.ID.String(), r.Amount, r.Reason); err != nil {
+		log.Printf("event=refund_failed refund=%s transaction=%s err=%v", r.ID, r.TransactionID, err)
+		_ = q.FailRefundRequest(r.ID, err.Error())
+		return err
+	}
+
+	if err := bookRefund(r); err != nil {
+		_ = q.MarkRefundProviderRefunded(r.ID, err.Error())
+		return err
+	}
+	return nil
+}
+
+// bookRefund records a refund the provider already accepted: the transaction, the ledger, the booking
+// and the credits of a refunded package
+func bookRefund(r models.RefundRequest) error {
+	q := queries.RefundQueries{DB: database.DB}
+	tx, err := q.CompleteRefund(r)
+	if err != nil {
+		log.Printf("event=refund_booking_failed refund=%s transaction=%s err=%v", r.ID, r.TransactionID, err)
+		return err
+	}
+
+	lq := queries.LedgerQueries{DB: database.DB}
+	if err := lq.PostTransactionRefund(tx, r.ID, r.Amount); err != nil {
+		log.Printf("event=ledger_error transaction=%s refund=%s err=%v", tx.ID, r.ID, err)
+	}
+	if tx.Status == models.TransactionRefunded {
+		bq := queries.BookingQueries{DB: database.DB}
+		_ = bq.UpdateBookingStatusByTransaction(tx.ID, "cancelled")
+	}
+	if tx.Kind == models.TransactionKindPackage {
+		// the buyer does not keep the sessions they got their money back for
+		sq := queries.SubscriptionQueries{DB: database.DB}
+		if err := sq.RevokeRefundedCredits(tx.ID, r.Amount, tx.Status == models.TransactionRefunded); err != nil {
+			log.Printf("event=credit_revoke_failed refund=%s transaction=%s err=%v", r.ID, tx.ID, err)
+		}
+	}
+	log.Printf("event=refund_completed refund=%s transaction=%s amount=%d status=%s", r.ID, tx.ID, r.Amount, tx.Status)
+	return nil
+}
+
+// retryProviderRefunds books refunds the provider accepted but that could not be recorded at the time
+func retryProviderRefunds() {
+	q := queries.RefundQueries{DB: database.DB}
+	refunds, err := q.GetRefundRequests(models.RefundProviderRefunded, nil, nil, reconcileBatchSize)
+	if err != nil {
+		log.Printf("event=refund_retry_error err=%v", err)
+		return
+	}
+	for _, r := range refunds {
+		_ = bookRefund(r)
+	}
+}
+
+// refundTransaction opens an approved refund of everything not yet refunded on tx and executes it
+func refundTransaction(tx models.Transaction, decidedBy uuid.UUID, requestedBy *uuid.UUID, reason string) (models.RefundRequest, error) {
+	r := models.RefundRequest{ID: uuid.New(), TransactionID: tx.ID, UserID: tx.UserID, AhliID: tx.AhliID, RequestedBy: requestedBy, Amount: tx.Amount - tx.RefundedAmount, Reason: reason, Status: models.RefundPending, CreatedAt: time.Now(), UpdatedAt: time.Now()}
+	rq := queries.RefundQueries{DB: database.DB}
+	if err := rq.CreateRefundRequest(&r); err != nil {
+		log.Printf("event=refund_failed transaction=%s err=%v", tx.ID, err)
+		return r, err
+	}
+	if _, err := rq.DecideRefundRequest(r.ID, models.RefundApproved, r.Amount, decidedBy, nil); err != nil {
+		return r, err
+	}
+	return r, executeRefund(r)
+}
+
+// refundCancelledBooking returns a payment that completed after its booking had been cancelled
+func refundCancelledBooking(tx models.Transaction) {
+	if !models.Refundable(tx.Status) || tx.Amount-tx.RefundedAmount <= 0 {
+		return
+	}
+	r, err := refundTransaction(tx, tx.AhliID, nil, "payment received for a cancelled booking")
+	if err != nil {
+		log.Printf("event=late_payment_refund_failed transaction=%s refund=%s err=%v", tx.ID, r.ID, err)
+		return
+	}
+	log.Printf("event=late_payment_refunded transaction=%s refund=%s", tx.ID, r.ID)
+}
+
+// CancelAhliBooking lets an ahli cancel one of their bookings; a paid booking is refunded in full
+func CancelAhliBooking(c *fiber.Ctx) error {
+	ahliID, err := utils.ExtractUserIDFromHeader(c.Get("Authorization"))
+	if err != nil {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": err.Error()})
+	}
+	id, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "invalid id"})
+	}
+	p := &models.CancelBookingRequest{}
+	if len(c.Body()) > 0 {
+		if err := c.BodyParser(p); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "invalid body"})
+		}
+	}
+
+	bq := queries.BookingQueries{DB: database.DB}
+	b, err := bq.GetBookingByID(id)
+	if err != nil || b.AhliID != ahliID {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "booking not found"})
+	}
+	ok, err := bq.CancelBooking(b.ID)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
+	}
+	if !ok {
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{"error": "booking is already " + b.Status})
+	}
+	b.Status = "cancelled"
+	if b.SubscriptionID != nil {
+		sq := queries.SubscriptionQueries{DB: database.DB}
+		if err := sq.RestoreCredit(*b.SubscriptionID); err != nil {
+			log.Printf("event=credit_restore_failed booking=%s subscription=%s err=%v", b.ID, *b.SubscriptionID, err)
+		}
+	}
+
+	resp := fiber.Map{"booking": b}
+	if b.TransactionID == nil {
+		return c.Status(fiber.StatusOK).JSON(resp)
+	}
+	tq := queries.TransactionQueries{DB: database.DB}
+	tx, err := tq.GetTransactionByID(*b.TransactionID)
+	if err != nil {
+		return c.Status(fiber.StatusOK).JSON(resp)
+	}
+	if tx.Status == models.TransactionPending {
+		// close the charge so the user cannot pay for the cancelled session; if the provider refuses
+		// because it was just paid, the completion is refunded by onTransactionStatusChanged
+		ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+		err := paymentProvider().Expire(ctx, tx.ID.String())
+		cancel()
+		if err != nil {
+			log.Printf("event=payment_expire_failed transaction=%s err=%v", tx.ID, err)
+			resp["payment_error"] = err.Error()
+			return c.Status(fiber.StatusOK).JSON(resp)
+		}
+		applyTransactionStatus(tx, models.TransactionExpired)
+		return c.Status(fiber.StatusOK).JSON(resp)
+	}
+	if !models.Refundable(tx.Status) || tx.Amount-tx.RefundedAmount <= 0 {
+		return c.Status(fiber.StatusOK).JSON(resp)
+	}
+
+	reason := "booking cancelled by ahli"
+	if s := strings.TrimSpace(p.Reason); s != "" {
+		reason += ": " + s
+	}
+	r, err := refundTransaction(tx, ahliID, &ahliID, reason)
+	if err != nil {
+		resp["refund_error"] = err.Error()
+	}
+	rq := queries.RefundQueries{DB: database.DB}
+	if refund, err := rq.GetRefundRequest(r.ID); err == nil {
+		resp["refund"] = refund
+	}
+	return c.Status(fiber.StatusOK).JSON(resp)
+}
@@ -0,0 +1,85 @@
+package controllers
+
+import (
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/gilanghuda/sobi-backend/app/models"
+	"github.com/gilanghuda/sobi-backend/app/queries"
+	"github.com/gilanghuda/sobi-backend/pkg/database"
+	"github.com/gofiber/fiber/v2"
+)
+
+// GetRevenueReport aggregates transactions for admins by day, week, month, ahli or category,
+// as JSON or, with ?format=csv, as a CSV download
+func GetRevenueReport(c *fiber.Ctx) error {
+	groupBy := c.Query("group_by", "day")
+	if !queries.ValidRevenueGroup(groupBy) {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "group_by must be day, week, month, ahli or category"})
+	}
+	kind := c.Query("kind")
+	if kind != "" && kind != models.TransactionKindConsultation && kind != models.TransactionKindPackage && kind != models.TransactionKindTip {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "kind must be consultation, package or tip"})
+	}
+
+	var err error
+	to := time.Now()
+	from := to.AddDate(0, 0, -30)
+	if s := c.Query("from"); s != "" {
+		if from, err = time.ParseInLocation("2006-01-02", s, time.Local); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "invalid from, use YYYY-MM-DD"})
+		}
+	}
+	if s := c.Query("to"); s != "" {
+		t, err := time.ParseInLocation("2006-01-02", s, time.Local)
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "invalid to, use YYYY-MM-DD"})
+		}
+		to = t.AddDate(0, 0, 1)
+	}
+	if !to.After(from) {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "to must not be before from"})
+	}
+
+	q := queries.ReportQueries{DB: database.DB}
+	rows, err := q.GetRevenueReport(groupBy, from, to, kind)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "failed to get report"})
+	}
+	report := models.RevenueReport{GroupBy: groupBy, From: from, To: to, Kind: kind, Rows: rows, Totals: models.RevenueRow{Key: "total"}}
+	for _, r := range rows {
+		report.Totals.Add(r)
+	}
+
+	if c.Query("format") != "csv" {
+		return c.Status(fiber.StatusOK).JSON(report)
+	}
+
+	var buf bytes.Buffer
+	w := csv.NewWriter(&buf)
+	_ = w.Write([]string{groupBy, "label", "transactions", "paid", "failed", "expired", "pending", "success_rate", "gross", "discounts", "refunded", "net"})
+	for _, r := range append(rows, report.Totals) {
+		_ = w.Write([]string{
+			r.Key,
+			r.Label,
+			strconv.Itoa(r.Transactions),
+			strconv.Itoa(r.Paid),
+			strconv.Itoa(r.Failed),
+			strconv.Itoa(r.Expired),
+			strconv.Itoa(r.Pending),
+			strconv.FormatFloat(r.SuccessRate, 'f', 4, 64),
+			strconv.FormatInt(r.Gross, 10),
+			strconv.FormatInt(r.Discounts, 10),
+			strconv.FormatInt(r.Refunded, 10),
+			strconv.FormatInt(r.Net, 10),
+		})
+	}
+	w.Flush()
+
+	c.Set(fiber.HeaderContentType, "text/csv")
+	c.Set(fiber.HeaderContentDisposition, fmt.Sprintf(`attachment; filename="revenue-%s-%s-%s.csv"`, groupBy, from.Format("20060102"), to.AddDate(0, 0, -1).Format("20060102")))
+	return c.Status(fiber.StatusOK).Send(buf.Bytes())
+}
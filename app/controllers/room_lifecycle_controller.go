@@ -0,0 +1,162 @@
+package controllers
+
+import (
+	"errors"
+	"log"
+	"time"
+
+	"github.com/gilanghuda/sobi-backend/app/models"
+	"github.com/gilanghuda/sobi-backend/app/queries"
+	"github.com/gilanghuda/sobi-backend/pkg/database"
+	"github.com/gilanghuda/sobi-backend/pkg/utils"
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+)
+
+// roomIdleTimeout is how long a direct room without a booking stays open without any activity
+func roomIdleTimeout() time.Duration {
+	return envMinutes("ROOM_IDLE_TIMEOUT_MINUTES", 30)
+}
+
+// StartRoomJobs closes rooms whose booking is over and rooms that went idle, every
+// ROOM_JOB_INTERVAL_MINUTES.
+func StartRoomJobs() {
+	interval := envMinutes("ROOM_JOB_INTERVAL_MINUTES", 1)
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for range ticker.C {
+			closeRooms()
+		}
+	}()
+}
+
+func closeRooms() {
+	q := queries.ChatQueries{DB: database.DB}
+	rooms, err := q.EndBookedRooms(time.Now())
+	if err != nil {
+		log.Printf("event=room_close_error reason=booking err=%v", err)
+	}
+	idle, err := q.EndIdleRooms(time.Now().Add(-roomIdleTimeout()))
+	if err != nil {
+		log.Printf("event=room_close_error reason=idle err=%v", err)
+	}
+	for _, r := range append(rooms, idle...) {
+		log.Printf("event=room_closed room=%s reason=%s", r.ID, r.EndReason)
+		onRoomEnded(r)
+	}
+}
+
+// onRoomEnded stops websocket routing for the room and tells its members the session is over
+func onRoomEnded(r models.Room) {
+	roomMembersMu.Lock()
+	delete(roomMembers, r.ID.String())
+	roomMembersMu.Unlock()
+
+	broadcastToRoom(r.ID, map[string]interface{}{"event": "room_ended", "room_id": r.ID, "ended_by": r.EndedBy, "reason": r.EndReason, "ended_at": r.EndedAt}, uuid.Nil)
+}
+
+// checkRoomActive returns the HTTP status and error to answer with when the room no longer takes messages
+func checkRoomActive(roomID uuid.UUID) (int, error) {
+	q := queries.ChatQueries{DB: database.DB}
+	room, err := q.GetRoomByID(roomID)
+	if err != nil {
+		if err.Error() == "room not found" {
+			return fiber.StatusNotFound, err
+		}
+		return fiber.StatusInternalServerError, errors.New("failed to get room")
+	}
+	if room.Status != models.RoomStatusActive {
+		return fiber.StatusConflict, errors.New("this room has ended")
+	}
+	return fiber.StatusOK, nil
+}
+
+// canCloseRoom reports whether the user may end or archive the room: either participant of a
+// direct room, or the owner and moderators of a group room
+func canCloseRoom(room models.Room, userID uuid.UUID) (int, error) {
+	q := queries.ChatQueries{DB: database.DB}
+	role, err := q.GetRoomMemberRole(room.ID, userID)
+	if err != nil {
+		return fiber.StatusInternalServerError, errors.New("failed to check room membership")
+	}
+	if role == "" {
+		return fiber.StatusForbidden, errors.New("you are not a member of this room")
+	}
+	if room.IsGroup && role != models.RoomRoleOwner && role != models.RoomRoleModerator {
+		return fiber.StatusForbidden, errors.New("only the owner or a moderator can close a group room")
+	}
+	return fiber.StatusOK, nil
+}
+
+// loadRoom fetches the room named by the :id param
+func loadRoom(c *fiber.Ctx) (models.Room, int, error) {
+	id, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return models.Room{}, fiber.StatusBadRequest, errors.New("invalid room id")
+	}
+	q := queries.ChatQueries{DB: database.DB}
+	room, err := q.GetRoomByID(id)
+	if err != nil {
+		if err.Error() == "room not found" {
+			return room, fiber.StatusNotFound, err
+		}
+		return room, fiber.StatusInternalServerError, errors.New("failed to get room")
+	}
+	return room, fiber.StatusOK, nil
+}
+
+// EndRoomSession ends the session in a room; its history stays readable but no new messages are accepted
+func EndRoomSession(c *fiber.Ctx) error {
+	userID, err := utils.ExtractUserIDFromHeader(c.Get("Authorization"))
+	if err != nil {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": err.Error()})
+	}
+	room, status, err := loadRoom(c)
+	if err != nil {
+		return c.Status(status).JSON(fiber.Map{"error": err.Error()})
+	}
+	if status, err := canCloseRoom(room, userID); err != nil {
+		return c.Status(status).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	q := queries.ChatQueries{DB: database.DB}
+	ended, ok, err := q.EndRoom(room.ID, &userID, models.RoomEndReasonMember)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "failed to end room"})
+	}
+	if !ok {
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{"error": "room is not active"})
+	}
+	onRoomEnded(ended)
+	return c.Status(fiber.StatusOK).JSON(ended)
+}
+
+// ArchiveRoom hides an ended room from room lists
+func ArchiveRoom(c *fiber.Ctx) error {
+	userID, err := utils.ExtractUserIDFromHeader(c.Get("Authorization"))
+	if err != nil {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": err.Error()})
+	}
+	room, status, err := loadRoom(c)
+	if err != nil {
+		return c.Status(status).JSON(fiber.Map{"error": err.Error()})
+	}
+	if status, err := canCloseRoom(room, userID); err != nil {
+		return c.Status(status).JSON(fiber.Map{"error": err.Error()})
+	}
+	if room.Status == models.RoomStatusActive {
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{"error": "end the session before archiving the room"})
+	}
+
+	q := queries.ChatQueries{DB: database.DB}
+	ok, err := q.ArchiveRoom(room.ID)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "failed to archive room"})
+	}
+	if !ok {
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{"error": "room is already archived"})
+	}
+	room.Status = models.RoomStatusArchived
+	return c.Status(fiber.StatusOK).JSON(room)
+}
@@ -0,0 +1,51 @@
+package controllers
+
+import (
+	"database/sql"
+	"testing"
+
+	"github.com/gilanghuda/sobi-backend/pkg/database"
+	"github.com/google/uuid"
+)
+
+func TestWebsocketMessageKeepsRoomActive(t *testing.T) {
+	stub := &stubDB{}
+	database.DB = sql.OpenDB(stubConnector{stub})
+
+	sender, other, room := uuid.New(), uuid.New(), uuid.New()
+	roomMembersMu.Lock()
+	roomMembers[room.String()] = []uuid.UUID{sender, other}
+	roomMembersMu.Unlock()
+	t.Cleanup(func() {
+		roomMembersMu.Lock()
+		delete(roomMembers, room.String())
+		roomMembersMu.Unlock()
+	})
+
+	routeRoomMessage(sender, map[string]interface{}{"event": "message", "room_id": room.String(), "text": "halo"})
+
+	if n := len(stub.executed("UPDATE rooms SET last_activity_at")); n != 1 {
+		t.Fatalf("room activity recorded %d times, want 1", n)
+	}
+}
+
+func TestWebsocketMessageFromOutsiderLeavesRoomUntouched(t *testing.T) {
+	stub := &stubDB{}
+	database.DB = sql.OpenDB(stubConnector{stub})
+
+	member, outsider, room := uuid.New(), uuid.New(), uuid.New()
+	roomMembersMu.Lock()
+	roomMembers[room.String()] = []uuid.UUID{member}
+	roomMembersMu.Unlock()
+	t.Cleanup(func() {
+		roomMembersMu.Lock()
+		delete(roomMembers, room.String())
+		roomMembersMu.Unlock()
+	})
+
+	routeRoomMessage(outsider, map[string]interface{}{"event": "message", "room_id": room.String(), "text": "halo"})
+
+	if n := len(stub.executed("UPDATE rooms")); n != 0 {
+		t.Fatalf("rejected message recorded room activity")
+	}
+}
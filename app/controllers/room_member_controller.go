@@ -0,0 +1,231 @@
+package controllers
+
+import (
+	"log"
+	"time"
+
+	"github.com/gilanghuda/sobi-backend/app/models"
+	"github.com/gilanghuda/sobi-backend/app/queries"
+	"github.com/gilanghuda/sobi-backend/pkg/database"
+	"github.com/gilanghuda/sobi-backend/pkg/utils"
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+)
+
+// roomMemberEvent tells the members of a room that someone joined or left
+func roomMemberEvent(event string, roomID, userID uuid.UUID, role string) {
+	broadcastToRoom(roomID, map[string]interface{}{"event": event, "room_id": roomID, "user_id": userID, "role": role}, uuid.Nil)
+}
+
+// GetRoomMembers lists the participants of a room the caller belongs to
+func GetRoomMembers(c *fiber.Ctx) error {
+	userID, err := utils.ExtractUserIDFromHeader(c.Get("Authorization"))
+	if err != nil {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": err.Error()})
+	}
+	roomID, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "invalid room id"})
+	}
+	if status, err := checkRoomMember(roomID, userID); err != nil {
+		return c.Status(status).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	q := queries.ChatQueries{DB: database.DB}
+	members, err := q.GetRoomMembers(roomID)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "failed to get room members"})
+	}
+	return c.Status(fiber.StatusOK).JSON(members)
+}
+
+// JoinRoom adds the caller to a visible group room; hidden groups are invite only
+func JoinRoom(c *fiber.Ctx) error {
+	userID, err := utils.ExtractUserIDFromHeader(c.Get("Authorization"))
+	if err != nil {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": err.Error()})
+	}
+	roomID, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "invalid room id"})
+	}
+
+	q := queries.ChatQueries{DB: database.DB}
+	room, err := q.GetRoomByID(roomID)
+	if err != nil {
+		if err.Error() == "room not found" {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": err.Error()})
+		}
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "failed to get room"})
+	}
+	if !room.IsGroup {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "only group rooms can be joined"})
+	}
+	if room.Status != models.RoomStatusActive {
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{"error": "this room has ended"})
+	}
+	if !room.Visible {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"error": "this room is invite only"})
+	}
+
+	m := &models.RoomMember{RoomID: roomID, UserID: userID, Role: models.RoomRoleMember, JoinedAt: time.Now()}
+	if err := q.AddRoomMember(m); err != nil {
+		if err.Error() == "user is already a member of this room" {
+			return c.Status(fiber.StatusConflict).JSON(fiber.Map{"error": err.Error()})
+		}
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "failed to join room"})
+	}
+	roomMemberEvent("member_joined", roomID, userID, m.Role)
+	return c.Status(fiber.StatusCreated).JSON(m)
+}
+
+// LeaveRoom removes the caller from a group room; the owner cannot leave their own room
+func LeaveRoom(c *fiber.Ctx) error {
+	userID, err := utils.ExtractUserIDFromHeader(c.Get("Authorization"))
+	if err != nil {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": err.Error()})
+	}
+	roomID, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "invalid room id"})
+	}
+
+	q := queries.ChatQueries{DB: database.DB}
+	role, err := q.GetRoomMemberRole(roomID, userID)
+	if err != nil {
+		if err.Error() == "room not found" {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": err.Error()})
+		}
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "failed to leave room"})
+	}
+	if role == "" {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"error": "you are not a member of this room"})
+	}
+	if role == models.RoomRoleOwner {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "the owner cannot leave the room"})
+	}
+
+	if err := q.RemoveRoomMember(roomID, userID); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "failed to leave room"})
+	}
+	roomMemberEvent("member_left", roomID, userID, role)
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{"message": "left room"})
+}
+
+// InviteRoomMember adds a user to a group room. Moderators can invite members;
+// only the owner can add moderators.
+func InviteRoomMember(c *fiber.Ctx) error {
+	userID, err := utils.ExtractUserIDFromHeader(c.Get("Authorization"))
+	if err != nil {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": err.Error()})
+	}
+	roomID, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "invalid room id"})
+	}
+	req := &models.InviteRoomMemberRequest{}
+	if err := c.BodyParser(req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "invalid body"})
+	}
+	inviteeID, err := uuid.Parse(req.UserID)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "invalid user_id"})
+	}
+	if req.Role == "" {
+		req.Role = models.RoomRoleMember
+	}
+	if req.Role != models.RoomRoleMember && req.Role != models.RoomRoleModerator {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "role must be member or moderator"})
+	}
+
+	q := queries.ChatQueries{DB: database.DB}
+	room, err := q.GetRoomByID(roomID)
+	if err != nil {
+		if err.Error() == "room not found" {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": err.Error()})
+		}
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "failed to get room"})
+	}
+	if !room.IsGroup {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "members can only be invited to group rooms"})
+	}
+	if room.Status != models.RoomStatusActive {
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{"error": "this room has ended"})
+	}
+	role, err := q.GetRoomMemberRole(roomID, userID)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "failed to check room membership"})
+	}
+	if role != models.RoomRoleOwner && role != models.RoomRoleModerator {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"error": "only the owner or a moderator can invite"})
+	}
+	if req.Role == models.RoomRoleModerator && role != models.RoomRoleOwner {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"error": "only the owner can add moderators"})
+	}
+
+	m := &models.RoomMember{RoomID: roomID, UserID: inviteeID, Role: req.Role, InvitedBy: &userID, JoinedAt: time.Now()}
+	if err := q.AddRoomMember(m); err != nil {
+		switch err.Error() {
+		case "user is already a member of this room":
+			return c.Status(fiber.StatusConflict).JSON(fiber.Map{"error": err.Error()})
+		case "user not found":
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": err.Error()})
+		}
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "failed to invite member"})
+	}
+
+	roomMemberEvent("member_joined", roomID, inviteeID, m.Role)
+	if err := utils.DefaultNotifier.Send(inviteeID, map[string]interface{}{"event": "room_invited", "room_id": roomID, "invited_by": userID, "role": m.Role}); err != nil {
+		log.Printf("event=notify_error user=%s err=%v", inviteeID, err)
+	}
+	return c.Status(fiber.StatusCreated).JSON(m)
+}
+
+// markRoomRead advances the member's read position and tells the other members about it
+func markRoomRead(roomID, userID uuid.UUID, messageID *uuid.UUID) (models.ReadReceipt, error) {
+	q := queries.ChatQueries{DB: database.DB}
+	rr, moved, err := q.MarkRoomRead(roomID, userID, messageID)
+	if err != nil || !moved {
+		return rr, err
+	}
+	broadcastToRoom(roomID, map[string]interface{}{"event": "read", "room_id": roomID, "user_id": userID, "message_id": rr.MessageID, "read_at": rr.ReadAt}, userID)
+	return rr, nil
+}
+
+// MarkRoomRead marks a room as read by the caller up to a message, or up to the newest message
+func MarkRoomRead(c *fiber.Ctx) error {
+	userID, err := utils.ExtractUserIDFromHeader(c.Get("Authorization"))
+	if err != nil {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": err.Error()})
+	}
+	roomID, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "invalid room id"})
+	}
+	req := &models.MarkReadRequest{}
+	if len(c.Body()) > 0 {
+		if err := c.BodyParser(req); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "invalid body"})
+		}
+	}
+	var messageID *uuid.UUID
+	if req.MessageID != "" {
+		id, err := uuid.Parse(req.MessageID)
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "invalid message_id"})
+		}
+		messageID = &id
+	}
+	if status, err := checkRoomMember(roomID, userID); err != nil {
+		return c.Status(status).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	rr, err := markRoomRead(roomID, userID, messageID)
+	if err != nil {
+		if err.Error() == "message not found" {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": err.Error()})
+		}
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "failed to mark room read"})
+	}
+	return c.Status(fiber.StatusOK).JSON(rr)
+}
@@ -0,0 +1,379 @@
+package controllers
+
+import (
+	"context"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/gilanghuda/sobi-backend/app/models"
+	"github.com/gilanghuda/sobi-backend/app/queries"
+	"github.com/gilanghuda/sobi-backend/pkg/database"
+	"github.com/gilanghuda/sobi-backend/pkg/payment"
+	"github.com/gilanghuda/sobi-backend/pkg/utils"
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+)
+
+const subscriptionBatchSize = 200
+
+// GetPackages lists the active session packages, optionally of one ahli (?ahli_id=)
+func GetPackages(c *fiber.Ctx) error {
+	var ahliID *uuid.UUID
+	if s := c.Query("ahli_id"); s != "" {
+		id, err := uuid.Parse(s)
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "invalid ahli_id"})
+		}
+		ahliID = &id
+	}
+	q := queries.SubscriptionQueries{DB: database.DB}
+	list, err := q.GetPackages(ahliID, false)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "failed to get packages"})
+	}
+	return c.Status(fiber.StatusOK).JSON(list)
+}
+
+func GetAhliPackages(c *fiber.Ctx) error {
+	ahliID, err := utils.ExtractUserIDFromHeader(c.Get("Authorization"))
+	if err != nil {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": err.Error()})
+	}
+	q := queries.SubscriptionQueries{DB: database.DB}
+	list, err := q.GetPackages(&ahliID, true)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "failed to get packages"})
+	}
+	return c.Status(fiber.StatusOK).JSON(list)
+}
+
+func validatePackageRequest(p *models.SessionPackageRequest) string {
+	p.Name = strings.TrimSpace(p.Name)
+	if p.Name == "" {
+		return "name is required"
+	}
+	if p.Sessions <= 0 {
+		return "sessions must be positive"
+	}
+	if p.Price <= 0 {
+		return "price must be positive"
+	}
+	if p.PeriodDays == 0 {
+		p.PeriodDays = 30
+	}
+	if p.PeriodDays < 0 {
+		return "period_days must be positive"
+	}
+	return ""
+}
+
+func CreateAhliPackage(c *fiber.Ctx) error {
+	ahliID, err := utils.ExtractUserIDFromHeader(c.Get("Authorization"))
+	if err != nil {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": err.Error()})
+	}
+	p := &models.SessionPackageRequest{}
+	if err := c.BodyParser(p); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "invalid body"})
+	}
+	if msg := validatePackageRequest(p); msg != "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": msg})
+	}
+
+	pkg := &models.SessionPackage{ID: uuid.New(), AhliID: ahliID, Name: p.Name, Description: p.Description, Sessions: p.Sessions, Price: p.Price, PeriodDays: p.PeriodDays, Recurring: p.Recurring, Active: true, CreatedAt: time.Now(), UpdatedAt: time.Now()}
+	if p.Active != nil {
+		pkg.Active = *p.Active
+	}
+	q := queries.SubscriptionQueries{DB: database.DB}
+	if err := q.CreatePackage(pkg); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "failed to create package"})
+	}
+	return c.Status(fiber.StatusCreated).JSON(pkg)
+}
+
+func UpdateAhliPackage(c *fiber.Ctx) error {
+	ahliID, err := utils.ExtractUserIDFromHeader(c.Get("Authorization"))
+	if err != nil {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": err.Error()})
+	}
+	id, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "invalid id"})
+	}
+	p := &models.SessionPackageRequest{}
+	if err := c.BodyParser(p); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "invalid body"})
+	}
+	if msg := validatePackageRequest(p); msg != "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": msg})
+	}
+
+	q := queries.SubscriptionQueries{DB: database.DB}
+	pkg, err := q.GetPackageByID(id)
+	if err != nil || pkg.AhliID != ahliID {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "package not found"})
+	}
+	pkg.Name, pkg.Description, pkg.Sessions, pkg.Price, pkg.PeriodDays, pkg.Recurring = p.Name, p.Description, p.Sessions, p.Price, p.PeriodDays, p.Recurring
+	if p.Active != nil {
+		pkg.Active = *p.Active
+	}
+	if err := q.UpdatePackage(&pkg); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "failed to update package"})
+	}
+	return c.Status(fiber.StatusOK).JSON(pkg)
+}
+
+// DeleteAhliPackage deactivates a package; existing subscriptions run until their period ends
+func DeleteAhliPackage(c *fiber.Ctx) error {
+	ahliID, err := utils.ExtractUserIDFromHeader(c.Get("Authorization"))
+	if err != nil {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": err.Error()})
+	}
+	id, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "invalid id"})
+	}
+	q := queries.SubscriptionQueries{DB: database.DB}
+	pkg, err := q.GetPackageByID(id)
+	if err != nil || pkg.AhliID != ahliID {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "package not found"})
+	}
+	pkg.Active = false
+	if err := q.UpdatePackage(&pkg); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "failed to delete package"})
+	}
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{"message": "package deactivated"})
+}
+
+// packageItem describes a package payment for the gateway
+func packageItem(pkg models.SessionPackage) payment.Item {
+	return payment.Item{ID: "package-" + pkg.ID.String()[:8], Name: "Paket " + pkg.Name, Price: pkg.Price, Quantity: 1, Category: "package", MerchantName: "Sobi"}
+}
+
+// CreateSubscription starts a subscription to a package; it becomes active once the payment completes
+func CreateSubscription(c *fiber.Ctx) error {
+	userID, err := utils.ExtractUserIDFromHeader(c.Get("Authorization"))
+	if err != nil {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": err.Error()})
+	}
+	p := &models.CreateSubscriptionRequest{}
+	if err := c.BodyParser(p); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "invalid body"})
+	}
+	pkgID, err := uuid.Parse(p.PackageID)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "invalid package_id"})
+	}
+
+	q := queries.SubscriptionQueries{DB: database.DB}
+	pkg, err := q.GetPackageByID(pkgID)
+	if err != nil || !pkg.Active {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "package not found"})
+	}
+	if pkg.AhliID == userID {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "cannot subscribe to your own package"})
+	}
+
+	sub := &models.Subscription{ID: uuid.New(), UserID: userID, AhliID: pkg.AhliID, PackageID: pkg.ID, Status: models.SubscriptionPending, AutoRenew: p.AutoRenew && pkg.Recurring, CreatedAt: time.Now(), UpdatedAt: time.Now()}
+	tx := &models.Transaction{ID: uuid.New(), UserID: userID, AhliID: pkg.AhliID, Kind: models.TransactionKindPackage, SubscriptionID: &sub.ID, Amount: pkg.Price, Status: models.TransactionPending, CreatedAt: time.Now(), UpdatedAt: time.Now()}
+
+	if err := q.CreateSubscription(sub, tx); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "failed to create subscription"})
+	}
+
+	charge, err := paymentProvider().CreateCharge(c.Context(), chargeRequest(tx, packageItem(pkg)))
+	if err != nil {
+		// failing the transaction cancels the pending subscription
+		applyTransactionStatus(*tx, models.TransactionFailed)
+		return c.Status(fiber.StatusBadGateway).JSON(fiber.Map{"error": "failed to create payment"})
+	}
+	tx.PaymentURL = charge.PaymentURL
+	tx.SnapToken = charge.Token
+	tq := queries.TransactionQueries{DB: database.DB}
+	if err := tq.SetTransactionPayment(tx.ID, tx.PaymentURL, tx.SnapToken); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "failed to create subscription"})
+	}
+	sub.PackageName, sub.PackagePrice = pkg.Name, pkg.Price
+	return c.Status(fiber.StatusCreated).JSON(models.CreateSubscriptionResponse{Subscription: *sub, TransactionID: tx.ID, PaymentURL: tx.PaymentURL, SnapToken: tx.SnapToken})
+}
+
+func GetMySubscriptions(c *fiber.Ctx) error {
+	userID, err := utils.ExtractUserIDFromHeader(c.Get("Authorization"))
+	if err != nil {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": err.Error()})
+	}
+	q := queries.SubscriptionQueries{DB: database.DB}
+	list, err := q.GetSubscriptionsByUser(userID)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "failed to get subscriptions"})
+	}
+	return c.Status(fiber.StatusOK).JSON(list)
+}
+
+// CancelSubscription turns off auto renewal; remaining credits stay usable until the period ends
+func CancelSubscription(c *fiber.Ctx) error {
+	userID, err := utils.ExtractUserIDFromHeader(c.Get("Authorization"))
+	if err != nil {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": err.Error()})
+	}
+	id, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "invalid id"})
+	}
+	q := queries.SubscriptionQueries{DB: database.DB}
+	if err := q.SetAutoRenew(id, userID, false); err != nil {
+		if err.Error() == "subscription not found" {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": err.Error()})
+		}
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "failed to cancel subscription"})
+	}
+	sub, err := q.GetSubscriptionByID(id)
+	if err != nil {
+		return c.Status(fiber.StatusOK).JSON(fiber.Map{"message": "subscription will not renew"})
+	}
+	return c.Status(fiber.StatusOK).JSON(sub)
+}
+
+// CreateCreditBooking books a session with the subscription's ahli, paid with one credit
+func CreateCreditBooking(c *fiber.Ctx) error {
+	userID, err := utils.ExtractUserIDFromHeader(c.Get("Authorization"))
+	if err != nil {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": err.Error()})
+	}
+	p := &models.CreditBookingRequest{}
+	if err := c.BodyParser(p); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "invalid body"})
+	}
+	subID, err := uuid.Parse(p.SubscriptionID)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "invalid subscription_id"})
+	}
+	startAt, err := parseBookingTime(p.StartAt)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "invalid start_at, use RFC3339 or YYYY-MM-DD HH:MM"})
+	}
+	if !startAt.After(time.Now()) {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "start_at must be in the future"})
+	}
+	duration, err := bookingDuration(p.DurationMinutes)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	q := queries.SubscriptionQueries{DB: database.DB}
+	sub, err := q.GetSubscriptionByID(subID)
+	if err != nil || sub.UserID != userID {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "subscription not found"})
+	}
+	// a credit is worth its share of the package price, which is what the ahli earned for it
+	price := int64(0)
+	if sub.CreditsTotal > 0 {
+		price = sub.PackagePrice / int64(sub.CreditsTotal)
+	}
+
+	b := &models.Booking{ID: uuid.New(), UserID: userID, StartAt: startAt, EndAt: startAt.Add(duration), Price: price, Status: "confirmed", CreatedAt: time.Now(), UpdatedAt: time.Now()}
+	if err := q.CreateCreditBooking(subID, userID, b); err != nil {
+		switch err.Error() {
+		case "subscription not found":
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": err.Error()})
+		case "subscription is not active", "no credits left in this period", "ahli already has a session at that time":
+			return c.Status(fiber.StatusConflict).JSON(fiber.Map{"error": err.Error()})
+		case "session must start before the subscription period ends":
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+		}
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "failed to create booking"})
+	}
+	return c.Status(fiber.StatusCreated).JSON(b)
+}
+
+// onPackagePaymentChanged activates or renews a subscription when its payment completes
+func onPackagePaymentChanged(tx models.Transaction, status string) {
+	q := queries.SubscriptionQueries{DB: database.DB}
+	switch status {
+	case models.TransactionCompleted:
+		sub, err := q.ActivateSubscription(tx.ID)
+		if err != nil {
+			log.Printf("event=subscription_error transaction=%s err=%v", tx.ID, err)
+		} else {
+			log.Printf("event=subscription_activated subscription=%s transaction=%s period_end=%s", sub.ID, tx.ID, sub.CurrentPeriodEnd)
+		}
+		postTransactionToLedger(tx.ID)
+	case models.TransactionFailed, models.TransactionExpired:
+		if err := q.FailSubscriptionPayment(tx.ID); err != nil {
+			log.Printf("event=subscription_error transaction=%s err=%v", tx.ID, err)
+		}
+	}
+}
+
+// StartSubscriptionJobs periodically opens renewal payments for subscriptions ending within
+// SUBSCRIPTION_RENEWAL_LEAD_MINUTES and expires subscriptions whose period is over.
+func StartSubscriptionJobs() {
+	interval := envMinutes("SUBSCRIPTION_JOB_INTERVAL_MINUTES", 60)
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for range ticker.C {
+			renewSubscriptions()
+			expireSubscriptions()
+		}
+	}()
+}
+
+func renewSubscriptions() {
+	lead := envMinutes("SUBSCRIPTION_RENEWAL_LEAD_MINUTES", 24*60)
+	q := queries.SubscriptionQueries{DB: database.DB}
+	subs, err := q.GetSubscriptionsDueForRenewal(time.Now().Add(lead), subscriptionBatchSize)
+	if err != nil {
+		log.Printf("event=subscription_renewal_error err=%v", err)
+		return
+	}
+	for _, sub := range subs {
+		pkg, err := q.GetPackageByID(sub.PackageID)
+		if err != nil {
+			log.Printf("event=subscription_renewal_error subscription=%s err=%v", sub.ID, err)
+			continue
+		}
+		tx := &models.Transaction{ID: uuid.New(), UserID: sub.UserID, AhliID: sub.AhliID, Kind: models.TransactionKindPackage, SubscriptionID: &sub.ID, Amount: pkg.Price, Status: models.TransactionPending, CreatedAt: time.Now(), UpdatedAt: time.Now()}
+
+		if err := q.CreateRenewalTransaction(sub.ID, tx); err != nil {
+			log.Printf("event=subscription_renewal_error subscription=%s err=%v", sub.ID, err)
+			continue
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+		charge, err := paymentProvider().CreateCharge(ctx, chargeRequest(tx, packageItem(pkg)))
+		cancel()
+		if err != nil {
+			// failing the transaction clears the open renewal so the next run retries it
+			applyTransactionStatus(*tx, models.TransactionFailed)
+			log.Printf("event=subscription_renewal_error subscription=%s err=%v", sub.ID, err)
+			continue
+		}
+		tq := queries.TransactionQueries{DB: database.DB}
+		if err := tq.SetTransactionPayment(tx.ID, charge.PaymentURL, charge.Token); err != nil {
+			log.Printf("event=subscription_renewal_error subscription=%s err=%v", sub.ID, err)
+			continue
+		}
+		log.Printf("event=subscription_renewal_opened subscription=%s transaction=%s amount=%d", sub.ID, tx.ID, tx.Amount)
+	}
+}
+
+func expireSubscriptions() {
+	now := time.Now()
+	q := queries.SubscriptionQueries{DB: database.DB}
+	if n, err := q.RollOverSubscriptions(now); err != nil {
+		log.Printf("event=subscription_rollover_error err=%v", err)
+	} else if n > 0 {
+		log.Printf("event=subscriptions_rolled_over count=%d", n)
+	}
+	// unpaid subscriptions are given the same window as the reconciler gives pending payments
+	n, err := q.ExpireSubscriptions(now, now.Add(-envMinutes("RECONCILE_EXPIRE_AFTER_MINUTES", 24*60)))
+	if err != nil {
+		log.Printf("event=subscription_expiry_error err=%v", err)
+		return
+	}
+	if n > 0 {
+		log.Printf("event=subscriptions_expired count=%d", n)
+	}
+}
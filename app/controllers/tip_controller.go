@@ -0,0 +1,149 @@
+package controllers
+
+import (
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/gilanghuda/sobi-backend/app/models"
+	"github.com/gilanghuda/sobi-backend/app/queries"
+	"github.com/gilanghuda/sobi-backend/pkg/database"
+	"github.com/gilanghuda/sobi-backend/pkg/payment"
+	"github.com/gilanghuda/sobi-backend/pkg/utils"
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+)
+
+const (
+	minTipAmount = 1000
+	maxTipAmount = 1000000
+)
+
+// tipCommissionPercent reads TIP_COMMISSION_PERCENT; tips go to the listener in full by default
+func tipCommissionPercent() int64 {
+	v, err := strconv.ParseInt(os.Getenv("TIP_COMMISSION_PERCENT"), 10, 64)
+	if err != nil || v < 0 || v > 100 {
+		return 0
+	}
+	return v
+}
+
+func tipItem(tx *models.Transaction) payment.Item {
+	return payment.Item{ID: "tip-" + tx.ID.String()[:8], Name: "Dukungan untuk pendengar", Price: tx.Amount, Quantity: 1, Category: "tip", MerchantName: "Sobi"}
+}
+
+// SendTip charges an optional pay-what-you-can tip for the pendengar of a matched room once its session ended.
+// Only members of the room can tip, and only listeners who opted in can receive one.
+func SendTip(c *fiber.Ctx) error {
+	userID, err := utils.ExtractUserIDFromHeader(c.Get("Authorization"))
+	if err != nil {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": err.Error()})
+	}
+	roomID, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "invalid room id"})
+	}
+	p := &models.CreateTipRequest{}
+	if err := c.BodyParser(p); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "invalid body"})
+	}
+	if p.Amount < minTipAmount || p.Amount > maxTipAmount {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "amount must be between " + strconv.Itoa(minTipAmount) + " and " + strconv.Itoa(maxTipAmount)})
+	}
+
+	cq := queries.ChatQueries{DB: database.DB}
+	room, err := cq.GetRoomByID(roomID)
+	if err != nil {
+		if err.Error() == "room not found" {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": err.Error()})
+		}
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "failed to get room"})
+	}
+	if status, err := checkRoomMember(room.ID, userID); err != nil {
+		return c.Status(status).JSON(fiber.Map{"error": err.Error()})
+	}
+	if room.Status == models.RoomStatusActive {
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{"error": "tips can be sent once the session has ended"})
+	}
+	if room.ListenerID == nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "this room has no listener to tip"})
+	}
+	if *room.ListenerID == userID {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "you cannot tip yourself"})
+	}
+
+	uq := queries.UserQueries{DB: database.DB}
+	listener, err := uq.GetUserByID(*room.ListenerID)
+	if err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "listener not found"})
+	}
+	if listener.UserRole == "ahli" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "ahli sessions are paid through bookings"})
+	}
+	if !listener.AcceptsTips {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "this listener does not accept tips"})
+	}
+
+	tx := &models.Transaction{ID: uuid.New(), UserID: userID, AhliID: listener.ID, Kind: models.TransactionKindTip, RoomID: &room.ID, Amount: p.Amount, Status: models.TransactionPending, CreatedAt: time.Now(), UpdatedAt: time.Now()}
+	tq := queries.TransactionQueries{DB: database.DB}
+	if err := tq.CreateTransaction(tx); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "failed to create transaction"})
+	}
+
+	charge, err := paymentProvider().CreateCharge(c.Context(), chargeRequest(tx, tipItem(tx)))
+	if err != nil {
+		applyTransactionStatus(*tx, models.TransactionFailed)
+		return c.Status(http.StatusBadGateway).JSON(fiber.Map{"error": "failed to create payment"})
+	}
+	tx.PaymentURL = charge.PaymentURL
+	tx.SnapToken = charge.Token
+	if err := tq.SetTransactionPayment(tx.ID, tx.PaymentURL, tx.SnapToken); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "failed to create transaction"})
+	}
+	return c.Status(fiber.StatusCreated).JSON(models.CreateTransactionResponse{ID: tx.ID, PaymentURL: tx.PaymentURL, SnapToken: tx.SnapToken, Amount: tx.Amount})
+}
+
+// onTipPaid lets the listener know a tip arrived; the sender is not revealed
+func onTipPaid(tx models.Transaction) {
+	payload := map[string]interface{}{"event": "tip_received", "transaction_id": tx.ID.String(), "amount": tx.Amount}
+	if tx.RoomID != nil {
+		payload["room_id"] = tx.RoomID.String()
+	}
+	if err := utils.DefaultNotifier.Send(tx.AhliID, payload); err != nil {
+		log.Printf("event=notify_error user=%s err=%v", tx.AhliID, err)
+	}
+}
+
+// GetListenerEarnings summarizes the tips received by the caller as a pendengar
+func GetListenerEarnings(c *fiber.Ctx) error {
+	userID, err := utils.ExtractUserIDFromHeader(c.Get("Authorization"))
+	if err != nil {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": err.Error()})
+	}
+	limit := c.QueryInt("limit", 20)
+	if limit <= 0 || limit > 100 {
+		limit = 20
+	}
+
+	uq := queries.UserQueries{DB: database.DB}
+	user, err := uq.GetUserByID(userID)
+	if err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "user not found"})
+	}
+
+	res := models.ListenerEarnings{AcceptsTips: user.AcceptsTips}
+	q := queries.TipQueries{DB: database.DB}
+	if res.Tips, res.Total, res.PaidOut, err = q.GetTipTotals(userID); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "failed to get earnings"})
+	}
+	lq := queries.LedgerQueries{DB: database.DB}
+	if _, res.Pending, err = lq.GetPendingEarnings(userID); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "failed to get earnings"})
+	}
+	if res.Recent, err = q.GetReceivedTips(userID, limit); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "failed to get earnings"})
+	}
+	return c.Status(fiber.StatusOK).JSON(res)
+}
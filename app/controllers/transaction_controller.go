@@ -1,19 +1,41 @@
 package controllers
 
 import (
-	"bytes"
 	"encoding/json"
+	"fmt"
+	"log"
+	"math"
 	"net/http"
+	"strconv"
 	"time"
 
 	"github.com/gilanghuda/sobi-backend/app/models"
 	"github.com/gilanghuda/sobi-backend/app/queries"
 	"github.com/gilanghuda/sobi-backend/pkg/database"
+	"github.com/gilanghuda/sobi-backend/pkg/payment"
 	"github.com/gilanghuda/sobi-backend/pkg/utils"
 	"github.com/gofiber/fiber/v2"
+	"github.com/golang-jwt/jwt/v5"
 	"github.com/google/uuid"
 )
 
+const (
+	defaultBookingMinutes = 60
+	// sessions are sold at a flat price, so one payment cannot block the ahli's calendar for longer
+	maxBookingMinutes = 180
+)
+
+// bookingDuration validates the requested session length, defaulting to defaultBookingMinutes
+func bookingDuration(minutes int) (time.Duration, error) {
+	if minutes <= 0 {
+		minutes = defaultBookingMinutes
+	}
+	if minutes > maxBookingMinutes {
+		return 0, fmt.Errorf("duration_minutes must be at most %d", maxBookingMinutes)
+	}
+	return time.Duration(minutes) * time.Minute, nil
+}
+
 func CreateTransaction(c *fiber.Ctx) error {
 	authHeader := c.Get("Authorization")
 	userID, err := utils.ExtractUserIDFromHeader(authHeader)
@@ -25,7 +47,7 @@ func CreateTransaction(c *fiber.Ctx) error {
 	if err := c.BodyParser(p); err != nil {
 		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "invalid body"})
 	}
-	if p.AhliID == "" || p.Amount <= 0 {
+	if p.AhliID == "" || (p.Amount <= 0 && p.StartAt == "") {
 		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "ahli_id and positive amount are required"})
 	}
 	ahliID, err := uuid.Parse(p.AhliID)
@@ -35,22 +57,165 @@ func CreateTransaction(c *fiber.Ctx) error {
 
 	tx := &models.Transaction{ID: uuid.New(), UserID: userID, AhliID: ahliID, Amount: p.Amount, Status: "pending", CreatedAt: time.Now(), UpdatedAt: time.Now()}
 
-	paymentURL, err := utils.CreateMidtransTransaction(tx.ID.String(), tx.Amount)
-	if err != nil {
-		return c.Status(http.StatusBadGateway).JSON(fiber.Map{"error": "failed to create midtrans transaction"})
+	var booking *models.Booking
+	if p.StartAt != "" {
+		startAt, err := parseBookingTime(p.StartAt)
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "invalid start_at, use RFC3339 or YYYY-MM-DD HH:MM"})
+		}
+		if !startAt.After(time.Now()) {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "start_at must be in the future"})
+		}
+		duration, err := bookingDuration(p.DurationMinutes)
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+		}
+		endAt := startAt.Add(duration)
+		bq := queries.BookingQueries{DB: database.DB}
+		overlap, err := bq.HasOverlappingBooking(ahliID, startAt, endAt)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "failed to check ahli schedule"})
+		}
+		if overlap {
+			return c.Status(fiber.StatusConflict).JSON(fiber.Map{"error": "ahli already has a session at that time"})
+		}
+		// bookings are charged at the ahli's current price, so later price changes never touch them
+		aq := queries.AhliQueries{DB: database.DB}
+		price, err := aq.GetAhliPrice(ahliID)
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "ahli not found"})
+		}
+		if price <= 0 {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "ahli has no price set"})
+		}
+		tx.Amount = int64(math.Round(price))
+		booking = &models.Booking{ID: uuid.New(), UserID: userID, AhliID: ahliID, TransactionID: &tx.ID, StartAt: startAt, EndAt: endAt, Price: tx.Amount, Status: "pending", CreatedAt: time.Now(), UpdatedAt: time.Now()}
 	}
-	tx.PaymentURL = paymentURL
 
+	var categories []string
+	items := []payment.Item{consultationItem(tx, booking)}
+	if p.VoucherCode != "" {
+		categories = ahliCategories(ahliID)
+		vq := queries.VoucherQueries{DB: database.DB}
+		_, discount, err := vq.QuoteVoucher(p.VoucherCode, userID, ahliID, categories, tx.Amount)
+		if err != nil {
+			return voucherErrorResponse(c, err)
+		}
+		tx.Amount -= discount
+		tx.DiscountAmount = discount
+		items = append(items, payment.Item{ID: "voucher", Name: "Voucher " + queries.NormalizeVoucherCode(p.VoucherCode), Price: -discount, Quantity: 1, Category: "discount"})
+	}
+
+	// the transaction, the booking and the voucher reservation are stored before the charge is
+	// opened, so a rejected booking or voucher never leaves a payable charge at the provider
+	switch {
+	case p.VoucherCode != "":
+		vq := queries.VoucherQueries{DB: database.DB}
+		if err := vq.CreateTransactionWithVoucher(tx, p.VoucherCode, categories, booking); err != nil {
+			if err.Error() == "ahli already has a session at that time" {
+				return c.Status(fiber.StatusConflict).JSON(fiber.Map{"error": err.Error()})
+			}
+			return voucherErrorResponse(c, err)
+		}
+	case booking != nil:
+		q := queries.TransactionQueries{DB: database.DB}
+		if err := q.CreateBookedTransaction(tx, booking); err != nil {
+			if err.Error() == "ahli already has a session at that time" {
+				return c.Status(fiber.StatusConflict).JSON(fiber.Map{"error": err.Error()})
+			}
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "failed to create transaction"})
+		}
+	default:
+		q := queries.TransactionQueries{DB: database.DB}
+		if err := q.CreateTransaction(tx); err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "failed to create transaction"})
+		}
+	}
+
+	charge, err := paymentProvider().CreateCharge(c.Context(), chargeRequest(tx, items...))
+	if err != nil {
+		// failing the transaction frees the slot and the voucher again
+		applyTransactionStatus(*tx, models.TransactionFailed)
+		return c.Status(http.StatusBadGateway).JSON(fiber.Map{"error": "failed to create payment"})
+	}
+	tx.PaymentURL = charge.PaymentURL
+	tx.SnapToken = charge.Token
 	q := queries.TransactionQueries{DB: database.DB}
-	if err := q.CreateTransaction(tx); err != nil {
+	if err := q.SetTransactionPayment(tx.ID, tx.PaymentURL, tx.SnapToken); err != nil {
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "failed to create transaction"})
 	}
 
-	resp := models.CreateTransactionResponse{ID: tx.ID, PaymentURL: tx.PaymentURL}
+	resp := models.CreateTransactionResponse{ID: tx.ID, PaymentURL: tx.PaymentURL, SnapToken: tx.SnapToken, Amount: tx.Amount, Discount: tx.DiscountAmount}
+	if booking != nil {
+		resp.BookingID = &booking.ID
+	}
 	return c.Status(fiber.StatusCreated).JSON(resp)
 }
 
+// chargeRequest describes a payment of the given items, with the payer's contact details when available
+func chargeRequest(tx *models.Transaction, items ...payment.Item) payment.ChargeRequest {
+	req := payment.ChargeRequest{OrderID: tx.ID.String(), Amount: tx.Amount, Items: items}
+	uq := queries.UserQueries{DB: database.DB}
+	if u, err := uq.GetUserContact(tx.UserID); err == nil {
+		req.Customer = &payment.Customer{FirstName: u.Username, Email: u.Email, Phone: u.PhoneNumber}
+	}
+	return req
+}
+
+// consultationItem describes a one-off consultation at its price before discounts, naming the ahli and the booked session
+func consultationItem(tx *models.Transaction, booking *models.Booking) payment.Item {
+	item := payment.Item{ID: "consultation", Name: "Konsultasi", Price: tx.Amount + tx.DiscountAmount, Quantity: 1, Category: "consultation", MerchantName: "Sobi"}
+	aq := queries.AhliQueries{DB: database.DB}
+	if ahli, err := aq.GetAhliByID(tx.AhliID); err == nil {
+		item.Name = "Konsultasi " + ahli.Username
+		if ahli.Category != "" {
+			item.Category = ahli.Category
+		}
+	}
+	if booking != nil {
+		item.ID = "booking-" + booking.ID.String()[:8]
+		item.Name += " " + booking.StartAt.Format("02/01 15:04")
+	}
+	return item
+}
+
+func parseBookingTime(s string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	return time.ParseInLocation("2006-01-02 15:04", s, time.Local)
+}
+
+// GetTransactions lists the caller's transactions, newest first, with the ahli they paid
+func GetTransactions(c *fiber.Ctx) error {
+	userID, err := utils.ExtractUserIDFromHeader(c.Get("Authorization"))
+	if err != nil {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": err.Error()})
+	}
+	limit := c.QueryInt("limit", 20)
+	if limit <= 0 || limit > 100 {
+		limit = 20
+	}
+
+	q := queries.TransactionQueries{DB: database.DB}
+	list, next, err := q.GetTransactionsByUser(userID, c.Query("status"), c.Query("cursor"), limit)
+	if err != nil {
+		if err.Error() == "invalid cursor" {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+		}
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "failed to get transactions"})
+	}
+	if next != "" {
+		c.Set("X-Next-Cursor", next)
+	}
+	return c.Status(fiber.StatusOK).JSON(list)
+}
+
 func GetTransactionByID(c *fiber.Ctx) error {
+	userID, err := utils.ExtractUserIDFromHeader(c.Get("Authorization"))
+	if err != nil {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": err.Error()})
+	}
 	idStr := c.Params("id")
 	if idStr == "" {
 		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "missing id"})
@@ -61,58 +226,199 @@ func GetTransactionByID(c *fiber.Ctx) error {
 	}
 	q := queries.TransactionQueries{DB: database.DB}
 	tx, err := q.GetTransactionByID(id)
-	if err != nil {
+	// other users' transactions are reported as missing so ids cannot be probed
+	if err != nil || (tx.UserID != userID && tx.AhliID != userID && !isAdmin(c)) {
 		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "transaction not found"})
 	}
 	return c.Status(fiber.StatusOK).JSON(tx)
 }
 
-func MidtransNotification(c *fiber.Ctx) error {
-	var payload map[string]interface{}
-	if err := json.NewDecoder(bytes.NewReader(c.Body())).Decode(&payload); err != nil {
-		if err := c.BodyParser(&payload); err != nil {
-			return c.SendStatus(http.StatusBadRequest)
-		}
+// isAdmin reports whether the JWT set by JWTProtected carries the admin role
+func isAdmin(c *fiber.Ctx) bool {
+	claims, ok := c.Locals("user").(jwt.MapClaims)
+	if !ok {
+		return false
 	}
+	role, _ := claims["user_role"].(string)
+	return role == utils.RoleAdmin
+}
+
+// notificationResult is the outcome of applying one payment notification
+type notificationResult struct {
+	status         int
+	outcome        string
+	signatureValid bool
+	err            string
+}
 
-	orderID, _ := payload["order_id"].(string)
-	if orderID == "" {
-		if td, ok := payload["transaction_details"].(map[string]interface{}); ok {
-			orderID, _ = td["order_id"].(string)
+// onTransactionStatusChanged runs the side effects of a transaction reaching a new status
+func onTransactionStatusChanged(id uuid.UUID, status string) {
+	tq := queries.TransactionQueries{DB: database.DB}
+	tx, err := tq.GetTransactionByID(id)
+	if err == nil && tx.VoucherID != nil && status != models.TransactionPending {
+		vq := queries.VoucherQueries{DB: database.DB}
+		if err := vq.SettleVoucherRedemption(id, status == models.TransactionCompleted); err != nil {
+			log.Printf("event=voucher_error transaction=%s err=%v", id, err)
 		}
 	}
-	if orderID == "" {
-		return c.SendStatus(http.StatusBadRequest)
+	if err == nil && tx.Kind == models.TransactionKindPackage {
+		onPackagePaymentChanged(tx, status)
+		return
+	}
+	if err == nil && tx.Kind == models.TransactionKindTip {
+		if status == models.TransactionCompleted {
+			postTransactionToLedger(id)
+			onTipPaid(tx)
+		}
+		return
 	}
 
-	txStatus, _ := payload["transaction_status"].(string)
-	if txStatus == "" {
-		if s, ok := payload["status_code"].(string); ok {
-			txStatus = s
+	bq := queries.BookingQueries{DB: database.DB}
+	switch status {
+	case models.TransactionCompleted:
+		_ = bq.UpdateBookingStatusByTransaction(id, "confirmed")
+		postTransactionToLedger(id)
+		if b, err := bq.GetBookingByTransaction(id); err == nil && b.Status == "cancelled" {
+			refundCancelledBooking(tx)
 		}
+	case models.TransactionFailed, models.TransactionExpired:
+		_ = bq.UpdateBookingStatusByTransaction(id, "cancelled")
 	}
+}
 
-	localStatus := "pending"
-	switch txStatus {
-	case "capture", "settlement", "200":
-		localStatus = "completed"
-	case "pending":
-		localStatus = "pending"
-	case "deny", "expire", "cancel", "201", "202", "407":
-		localStatus = "failed"
-	default:
-		// keep pending
+// processPaymentNotification verifies a raw notification body with the payment provider and applies it
+func processPaymentNotification(body []byte) notificationResult {
+	note, err := paymentProvider().VerifyWebhook(body)
+	if err == payment.ErrInvalidSignature {
+		return notificationResult{status: http.StatusUnauthorized, outcome: "rejected_signature", err: err.Error()}
+	}
+	if err != nil {
+		return notificationResult{status: http.StatusBadRequest, outcome: "invalid", err: err.Error()}
 	}
 
-	id, err := uuid.Parse(orderID)
+	id, err := uuid.Parse(note.OrderID)
 	if err != nil {
-		return c.SendStatus(http.StatusBadRequest)
+		return notificationResult{status: http.StatusBadRequest, outcome: "invalid", signatureValid: true, err: "invalid order_id"}
+	}
+	q := queries.TransactionQueries{DB: database.DB}
+	tx, err := q.GetTransactionByID(id)
+	if err != nil {
+		return notificationResult{status: http.StatusNotFound, outcome: "unknown_order", signatureValid: true, err: err.Error()}
+	}
+
+	if err := checkGrossAmount(note.GrossAmount, tx.Amount); err != nil {
+		return notificationResult{status: http.StatusBadRequest, outcome: "rejected_amount", signatureValid: true, err: err.Error()}
+	}
+
+	res := applyTransactionStatus(tx, note.Status)
+	res.signatureValid = true
+	return res
+}
+
+// checkGrossAmount verifies that the amount the provider reports was paid equals the transaction amount
+func checkGrossAmount(gross string, amount int64) error {
+	paid, err := strconv.ParseFloat(gross, 64)
+	if err != nil || int64(math.Round(paid)) != amount {
+		return fmt.Errorf("gross_amount %s does not match transaction amount %d", gross, amount)
+	}
+	return nil
+}
+
+// applyTransactionStatus moves a transaction to a new status through the state machine and runs the side effects
+func applyTransactionStatus(tx models.Transaction, to string) notificationResult {
+	if to == "" {
+		return notificationResult{status: http.StatusOK, outcome: "ignored"}
+	}
+	if to == tx.Status {
+		return notificationResult{status: http.StatusOK, outcome: "duplicate"}
+	}
+	if !models.CanTransitionTransaction(tx.Status, to) {
+		return notificationResult{status: http.StatusConflict, outcome: "rejected_transition", err: fmt.Sprintf("illegal transition %s -> %s", tx.Status, to)}
 	}
 
 	q := queries.TransactionQueries{DB: database.DB}
-	if err := q.UpdateTransactionStatus(id, localStatus); err != nil {
-		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{"error": "unable to update transaction"})
+	updated, err := q.UpdateTransactionStatusFrom(tx.ID, tx.Status, to)
+	if err != nil {
+		return notificationResult{status: http.StatusInternalServerError, outcome: "error", err: err.Error()}
+	}
+	if !updated {
+		return notificationResult{status: http.StatusConflict, outcome: "rejected_transition", err: "transaction status changed concurrently"}
 	}
 
+	onTransactionStatusChanged(tx.ID, to)
+	log.Printf("event=transaction_status transaction=%s from=%s to=%s", tx.ID, tx.Status, to)
+	return notificationResult{status: http.StatusOK, outcome: "applied"}
+}
+
+// handleStoredNotification processes a logged notification and records the outcome on it
+func handleStoredNotification(n models.PaymentNotification) notificationResult {
+	res := processPaymentNotification(n.Payload)
+
+	var errMsg *string
+	if res.err != "" {
+		errMsg = &res.err
+		log.Printf("event=payment_notification id=%s order=%s outcome=%s err=%s", n.ID, n.OrderID, res.outcome, res.err)
+	}
+	q := queries.TransactionQueries{DB: database.DB}
+	_ = q.UpdatePaymentNotificationOutcome(n.ID, res.signatureValid, res.outcome, errMsg)
+	return res
+}
+
+// receivePaymentNotification stores a raw notification body and processes it
+func receivePaymentNotification(raw []byte) (notificationResult, error) {
+	var payload struct {
+		OrderID           string `json:"order_id"`
+		TransactionStatus string `json:"transaction_status"`
+	}
+	if err := json.Unmarshal(raw, &payload); err != nil {
+		return notificationResult{status: http.StatusBadRequest, outcome: "invalid", err: "payload is not a JSON object"}, nil
+	}
+
+	n := models.PaymentNotification{ID: uuid.New(), OrderID: payload.OrderID, TransactionStatus: payload.TransactionStatus, Payload: raw, Outcome: "received", ReceivedAt: time.Now()}
+	q := queries.TransactionQueries{DB: database.DB}
+	if err := q.CreatePaymentNotification(&n); err != nil {
+		return notificationResult{}, err
+	}
+	return handleStoredNotification(n), nil
+}
+
+func MidtransNotification(c *fiber.Ctx) error {
+	raw := append([]byte(nil), c.Body()...)
+	res, err := receivePaymentNotification(raw)
+	if err != nil {
+		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{"error": "unable to store notification"})
+	}
+	if res.status != http.StatusOK {
+		return c.Status(res.status).JSON(fiber.Map{"error": res.err})
+	}
 	return c.SendStatus(http.StatusOK)
 }
+
+func GetPaymentNotifications(c *fiber.Ctx) error {
+	limit := c.QueryInt("limit", 100)
+	if limit <= 0 || limit > 500 {
+		limit = 100
+	}
+	q := queries.TransactionQueries{DB: database.DB}
+	list, err := q.GetPaymentNotifications(c.Query("order_id"), limit)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "failed to get notifications"})
+	}
+	return c.Status(fiber.StatusOK).JSON(list)
+}
+
+// ReplayPaymentNotification re-runs a stored notification through verification and the state machine
+func ReplayPaymentNotification(c *fiber.Ctx) error {
+	id, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "invalid id"})
+	}
+	q := queries.TransactionQueries{DB: database.DB}
+	n, err := q.GetPaymentNotification(id)
+	if err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "notification not found"})
+	}
+
+	res := handleStoredNotification(n)
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{"outcome": res.outcome, "status": res.status, "error": res.err})
+}
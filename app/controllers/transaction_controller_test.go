@@ -0,0 +1,176 @@
+package controllers
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/gilanghuda/sobi-backend/app/models"
+	"github.com/gilanghuda/sobi-backend/pkg/database"
+	"github.com/gilanghuda/sobi-backend/pkg/payment"
+	"github.com/gofiber/fiber/v2"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+)
+
+// stubDB is a database/sql driver that accepts every write and answers every read with no rows,
+// recording the statements it was given
+type stubDB struct {
+	mu    sync.Mutex
+	execs []string
+}
+
+func (d *stubDB) Open(string) (driver.Conn, error) { return stubConn{d}, nil }
+
+func (d *stubDB) executed(prefix string) []string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	var out []string
+	for _, q := range d.execs {
+		if strings.HasPrefix(strings.TrimSpace(q), prefix) {
+			out = append(out, q)
+		}
+	}
+	return out
+}
+
+type stubConn struct{ db *stubDB }
+
+func (c stubConn) Prepare(query string) (driver.Stmt, error) { return stubStmt{c.db, query}, nil }
+func (c stubConn) Close() error                              { return nil }
+func (c stubConn) Begin() (driver.Tx, error)                 { return stubTx{}, nil }
+
+type stubTx struct{}
+
+func (stubTx) Commit() error   { return nil }
+func (stubTx) Rollback() error { return nil }
+
+type stubStmt struct {
+	db    *stubDB
+	query string
+}
+
+func (s stubStmt) Close() error  { return nil }
+func (s stubStmt) NumInput() int { return -1 }
+func (s stubStmt) Exec([]driver.Value) (driver.Result, error) {
+	s.db.mu.Lock()
+	s.db.execs = append(s.db.execs, s.query)
+	s.db.mu.Unlock()
+	return driver.RowsAffected(1), nil
+}
+func (s stubStmt) Query([]driver.Value) (driver.Rows, error) { return stubRows{}, nil }
+
+type stubRows struct{}
+
+func (stubRows) Columns() []string         { return nil }
+func (stubRows) Close() error              { return nil }
+func (stubRows) Next([]driver.Value) error { return io.EOF }
+
+// failingProvider is a payment.Fake whose charges are always refused
+type failingProvider struct{ *payment.Fake }
+
+func (failingProvider) CreateCharge(context.Context, payment.ChargeRequest) (*payment.Charge, error) {
+	return nil, errors.New("gateway unavailable")
+}
+
+func setupTransactionTest(t *testing.T, p payment.Provider) (*stubDB, *fiber.App, string) {
+	t.Helper()
+	stub := &stubDB{}
+	database.DB = sql.OpenDB(stubConnector{stub})
+	SetPaymentProvider(p)
+
+	t.Setenv("JWT_SECRET", "test-secret")
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{"user_id": uuid.NewString()}).SignedString([]byte("test-secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	app := fiber.New()
+	app.Post("/transactions", CreateTransaction)
+	return stub, app, token
+}
+
+type stubConnector struct{ db *stubDB }
+
+func (c stubConnector) Connect(context.Context) (driver.Conn, error) { return stubConn{c.db}, nil }
+func (c stubConnector) Driver() driver.Driver                        { return c.db }
+
+func postTransaction(t *testing.T, app *fiber.App, token string, body interface{}) (int, models.CreateTransactionResponse) {
+	t.Helper()
+	data, _ := json.Marshal(body)
+	req := httptest.NewRequest("POST", "/transactions", bytes.NewReader(data))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+token)
+	res, err := app.Test(req, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out models.CreateTransactionResponse
+	_ = json.NewDecoder(res.Body).Decode(&out)
+	return res.StatusCode, out
+}
+
+func TestCreateTransactionOpensChargeWithFakeProvider(t *testing.T) {
+	fake := payment.NewFake()
+	stub, app, token := setupTransactionTest(t, fake)
+
+	status, out := postTransaction(t, app, token, models.CreateTransactionRequest{AhliID: uuid.NewString(), Amount: 50000})
+	if status != fiber.StatusCreated {
+		t.Fatalf("status = %d, want %d", status, fiber.StatusCreated)
+	}
+	if out.PaymentURL != "/payments/fake/"+out.ID.String() || out.SnapToken == "" {
+		t.Fatalf("unexpected payment details %+v", out)
+	}
+	if out.Amount != 50000 {
+		t.Fatalf("amount = %d, want 50000", out.Amount)
+	}
+
+	charge, err := fake.QueryStatus(context.Background(), out.ID.String())
+	if err != nil {
+		t.Fatalf("charge not opened at the provider: %v", err)
+	}
+	if charge.GrossAmount != "50000.00" || charge.Status != payment.StatusPending {
+		t.Fatalf("unexpected charge %+v", charge)
+	}
+	if n := len(stub.executed("INSERT INTO transactions")); n != 1 {
+		t.Fatalf("transaction inserted %d times, want 1", n)
+	}
+	if n := len(stub.executed("UPDATE transactions SET payment_url")); n != 1 {
+		t.Fatalf("payment details stored %d times, want 1", n)
+	}
+}
+
+func TestCreateTransactionFailsTransactionWhenChargeIsRefused(t *testing.T) {
+	stub, app, token := setupTransactionTest(t, failingProvider{payment.NewFake()})
+
+	status, _ := postTransaction(t, app, token, models.CreateTransactionRequest{AhliID: uuid.NewString(), Amount: 50000})
+	if status != fiber.StatusBadGateway {
+		t.Fatalf("status = %d, want %d", status, fiber.StatusBadGateway)
+	}
+	if n := len(stub.executed("UPDATE transactions SET status")); n != 1 {
+		t.Fatalf("transaction status updated %d times, want 1", n)
+	}
+	if n := len(stub.executed("UPDATE transactions SET payment_url")); n != 0 {
+		t.Fatalf("payment details stored for a refused charge")
+	}
+}
+
+func TestCreateTransactionRejectsLongBookings(t *testing.T) {
+	stub, app, token := setupTransactionTest(t, payment.NewFake())
+
+	status, _ := postTransaction(t, app, token, models.CreateTransactionRequest{AhliID: uuid.NewString(), StartAt: "2999-01-01T10:00:00Z", DurationMinutes: maxBookingMinutes + 1})
+	if status != fiber.StatusBadRequest {
+		t.Fatalf("status = %d, want %d", status, fiber.StatusBadRequest)
+	}
+	if n := len(stub.executed("INSERT")); n != 0 {
+		t.Fatalf("rejected booking wrote %d rows", n)
+	}
+}
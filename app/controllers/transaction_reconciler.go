@@ -0,0 +1,155 @@
+package controllers
+
+import (
+	"context"
+	"log"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gilanghuda/sobi-backend/app/models"
+	"github.com/gilanghuda/sobi-backend/app/queries"
+	"github.com/gilanghuda/sobi-backend/pkg/database"
+	"github.com/gofiber/fiber/v2"
+)
+
+const reconcileBatchSize = 200
+
+var (
+	reconcileMu      sync.Mutex
+	lastReconcile    *models.ReconcileReport
+	reconcileRunning bool
+)
+
+// envMinutes reads a positive number of minutes from the environment
+func envMinutes(key string, def int) time.Duration {
+	v, err := strconv.Atoi(os.Getenv(key))
+	if err != nil || v <= 0 {
+		v = def
+	}
+	return time.Duration(v) * time.Minute
+}
+
+// StartTransactionReconciler polls the payment provider for transactions that stayed pending
+// longer than RECONCILE_PENDING_AFTER_MINUTES, every RECONCILE_INTERVAL_MINUTES, and finishes
+// refunds the provider accepted but that were not booked yet.
+func StartTransactionReconciler() {
+	interval := envMinutes("RECONCILE_INTERVAL_MINUTES", 5)
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for range ticker.C {
+			reconcileTransactions()
+			retryProviderRefunds()
+		}
+	}()
+}
+
+// reconcileTransactions runs one pass; while a pass is running, callers get the previous report
+func reconcileTransactions() *models.ReconcileReport {
+	reconcileMu.Lock()
+	if reconcileRunning {
+		last := lastReconcile
+		reconcileMu.Unlock()
+		return last
+	}
+	reconcileRunning = true
+	reconcileMu.Unlock()
+
+	report := runReconcile()
+
+	reconcileMu.Lock()
+	lastReconcile = report
+	reconcileRunning = false
+	reconcileMu.Unlock()
+	return report
+}
+
+func runReconcile() *models.ReconcileReport {
+	pendingAfter := envMinutes("RECONCILE_PENDING_AFTER_MINUTES", 15)
+	expireAfter := envMinutes("RECONCILE_EXPIRE_AFTER_MINUTES", 24*60)
+
+	report := &models.ReconcileReport{StartedAt: time.Now(), Mismatches: []models.ReconcileMismatch{}}
+	q := queries.TransactionQueries{DB: database.DB}
+	txs, err := q.GetStalePendingTransactions(report.StartedAt.Add(-pendingAfter), reconcileBatchSize)
+	if err != nil {
+		log.Printf("event=reconcile_error err=%v", err)
+		report.Errors++
+		report.FinishedAt = time.Now()
+		return report
+	}
+
+	for _, tx := range txs {
+		report.Checked++
+		stale := report.StartedAt.Sub(tx.CreatedAt) > expireAfter
+
+		ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+		st, err := paymentProvider().QueryStatus(ctx, tx.ID.String())
+		cancel()
+
+		providerStatus := ""
+		to := ""
+		if err != nil {
+			providerStatus = "unknown"
+			if !stale {
+				report.Errors++
+				log.Printf("event=reconcile_error transaction=%s err=%v", tx.ID, err)
+				continue
+			}
+		} else {
+			providerStatus = st.TransactionStatus
+			to = st.Status
+			// a payment of the wrong amount is reported for review, never applied
+			if err := checkGrossAmount(st.GrossAmount, tx.Amount); err != nil && to != "" && to != tx.Status {
+				report.Mismatches = append(report.Mismatches, models.ReconcileMismatch{TransactionID: tx.ID, LocalStatus: tx.Status, ProviderStatus: providerStatus, Action: "rejected_amount", Error: err.Error()})
+				report.Errors++
+				log.Printf("event=reconcile_amount_mismatch transaction=%s provider=%s err=%v", tx.ID, providerStatus, err)
+				continue
+			}
+		}
+		// payments the provider still reports as open are expired locally once they are too old
+		if stale && (to == "" || to == models.TransactionPending) {
+			to = models.TransactionExpired
+		}
+		if to == "" || to == tx.Status {
+			continue
+		}
+
+		res := applyTransactionStatus(tx, to)
+		m := models.ReconcileMismatch{TransactionID: tx.ID, LocalStatus: tx.Status, ProviderStatus: providerStatus, Action: res.outcome, Error: res.err}
+		report.Mismatches = append(report.Mismatches, m)
+		log.Printf("event=reconcile_mismatch transaction=%s local=%s provider=%s to=%s outcome=%s", tx.ID, tx.Status, providerStatus, to, res.outcome)
+		if res.outcome != "applied" {
+			report.Errors++
+			continue
+		}
+		if to == models.TransactionExpired {
+			report.Expired++
+		} else {
+			report.Applied++
+		}
+	}
+
+	report.FinishedAt = time.Now()
+	log.Printf("event=reconcile_done checked=%d applied=%d expired=%d errors=%d", report.Checked, report.Applied, report.Expired, report.Errors)
+	return report
+}
+
+func GetReconcileReport(c *fiber.Ctx) error {
+	reconcileMu.Lock()
+	report := lastReconcile
+	reconcileMu.Unlock()
+	if report == nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "reconciliation has not run yet"})
+	}
+	return c.Status(fiber.StatusOK).JSON(report)
+}
+
+func RunReconcile(c *fiber.Ctx) error {
+	report := reconcileTransactions()
+	if report == nil {
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{"error": "reconciliation already running"})
+	}
+	return c.Status(fiber.StatusOK).JSON(report)
+}
@@ -1,7 +1,9 @@
 package controllers
 
 import (
+	"errors"
 	"os"
+	"strconv"
 	"strings"
 	"time"
 
@@ -187,7 +189,16 @@ func PromoteToAhli(c *fiber.Ctx) error {
 	}
 
 	if payload.Category == "" {
-		payload.Category = "ahli agama"
+		payload.Category = "agama"
+	}
+	for _, cat := range append([]string{payload.Category}, payload.Specializations...) {
+		ok, err := categoryAllowed(cat, models.CategoryScopeAhli)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "unable to validate category"})
+		}
+		if !ok {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "unknown ahli category: " + cat})
+		}
 	}
 
 	if payload.OpenTime != "" {
@@ -206,10 +217,83 @@ func PromoteToAhli(c *fiber.Ctx) error {
 	return c.Status(fiber.StatusOK).JSON(fiber.Map{"message": "user promoted to ahli"})
 }
 
+var weekdays = map[string]int{
+	"sunday": 0, "monday": 1, "tuesday": 2, "wednesday": 3, "thursday": 4, "friday": 5, "saturday": 6,
+	"minggu": 0, "senin": 1, "selasa": 2, "rabu": 3, "kamis": 4, "jumat": 5, "sabtu": 6,
+}
+
+// parseWeekday accepts a weekday name (english or indonesian), a 0-6 index or a YYYY-MM-DD date
+func parseWeekday(s string) (int, bool) {
+	s = strings.ToLower(strings.TrimSpace(s))
+	if d, ok := weekdays[s]; ok {
+		return d, true
+	}
+	if d, err := strconv.Atoi(s); err == nil && d >= 0 && d <= 6 {
+		return d, true
+	}
+	if t, err := time.Parse("2006-01-02", s); err == nil {
+		return int(t.Weekday()), true
+	}
+	return 0, false
+}
+
+func parseFloatQuery(c *fiber.Ctx, key string) (*float64, error) {
+	raw := c.Query(key)
+	if raw == "" {
+		return nil, nil
+	}
+	v, err := strconv.ParseFloat(raw, 64)
+	if err != nil {
+		return nil, errors.New("invalid " + key)
+	}
+	return &v, nil
+}
+
 func GetAhliWithDetails(c *fiber.Ctx) error {
-	ahliQ := queries.UserQueries{DB: database.DB}
-	users, err := ahliQ.GetAhliUsers()
+	params := models.AhliSearchParams{
+		Category: c.Query("category"),
+		Gender:   c.Query("gender"),
+		Language: c.Query("language"),
+		Sort:     c.Query("sort", queries.AhliSortRating),
+		Cursor:   c.Query("cursor"),
+		Limit:    c.QueryInt("limit", 20),
+	}
+
+	var err error
+	if params.MinPrice, err = parseFloatQuery(c, "min_price"); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+	}
+	if params.MaxPrice, err = parseFloatQuery(c, "max_price"); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+	}
+	if params.MinRating, err = parseFloatQuery(c, "min_rating"); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+	}
+	if params.Gender != "" && params.Gender != "male" && params.Gender != "female" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "gender must be male or female"})
+	}
+	if day := c.Query("available_on"); day != "" {
+		d, ok := parseWeekday(day)
+		if !ok {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "invalid available_on, use a weekday name or YYYY-MM-DD"})
+		}
+		params.AvailableOn = &d
+	}
+	switch params.Sort {
+	case queries.AhliSortRating, queries.AhliSortPrice, queries.AhliSortSoonest:
+	default:
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "sort must be rating, price or soonest"})
+	}
+	if params.Limit <= 0 || params.Limit > 100 {
+		params.Limit = 20
+	}
+
+	ahliQ := queries.AhliQueries{DB: database.DB}
+	users, next, err := ahliQ.SearchAhli(params)
 	if err != nil {
+		if err.Error() == "invalid cursor" {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "invalid cursor"})
+		}
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "unable to get ahli users"})
 	}
 
@@ -218,5 +302,8 @@ func GetAhliWithDetails(c *fiber.Ctx) error {
 		users[i].OTP = ""
 	}
 
+	if next != "" {
+		c.Set("X-Next-Cursor", next)
+	}
 	return c.Status(fiber.StatusOK).JSON(users)
 }
@@ -0,0 +1,223 @@
+package controllers
+
+import (
+	"math"
+	"strings"
+	"time"
+
+	"github.com/gilanghuda/sobi-backend/app/models"
+	"github.com/gilanghuda/sobi-backend/app/queries"
+	"github.com/gilanghuda/sobi-backend/pkg/database"
+	"github.com/gilanghuda/sobi-backend/pkg/utils"
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+)
+
+// ahliCategories returns the primary category and specializations of an ahli, for voucher scoping
+func ahliCategories(ahliID uuid.UUID) []string {
+	aq := queries.AhliQueries{DB: database.DB}
+	ahli, err := aq.GetAhliByID(ahliID)
+	if err != nil {
+		return nil
+	}
+	cats := append([]string{}, ahli.Specializations...)
+	if ahli.Category != "" {
+		cats = append(cats, ahli.Category)
+	}
+	return cats
+}
+
+// voucherErrorResponse maps voucher validation errors to 400 and anything else to 500
+func voucherErrorResponse(c *fiber.Ctx, err error) error {
+	msg := err.Error()
+	if strings.HasPrefix(msg, "unable to") {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "failed to apply voucher"})
+	}
+	if msg == "voucher not found" {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": msg})
+	}
+	return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": msg})
+}
+
+// ValidateVoucher previews the discount a voucher gives on a consultation with an ahli
+func ValidateVoucher(c *fiber.Ctx) error {
+	userID, err := utils.ExtractUserIDFromHeader(c.Get("Authorization"))
+	if err != nil {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": err.Error()})
+	}
+	p := &models.ValidateVoucherRequest{}
+	if err := c.BodyParser(p); err != nil || p.Code == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "code is required"})
+	}
+	ahliID, err := uuid.Parse(p.AhliID)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "invalid ahli_id"})
+	}
+
+	amount := p.Amount
+	if amount <= 0 {
+		aq := queries.AhliQueries{DB: database.DB}
+		price, err := aq.GetAhliPrice(ahliID)
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "ahli not found"})
+		}
+		amount = int64(math.Round(price))
+	}
+
+	vq := queries.VoucherQueries{DB: database.DB}
+	v, discount, err := vq.QuoteVoucher(p.Code, userID, ahliID, ahliCategories(ahliID), amount)
+	if err != nil {
+		return voucherErrorResponse(c, err)
+	}
+	return c.Status(fiber.StatusOK).JSON(models.VoucherCheck{Code: v.Code, Amount: amount, Discount: discount, Total: amount - discount})
+}
+
+// voucherFromRequest validates an admin voucher request and copies it onto v
+func voucherFromRequest(p *models.VoucherRequest, v *models.Voucher) string {
+	v.Code = queries.NormalizeVoucherCode(p.Code)
+	if v.Code == "" {
+		return "code is required"
+	}
+	if p.DiscountType != models.DiscountPercent && p.DiscountType != models.DiscountFixed {
+		return "discount_type must be percent or fixed"
+	}
+	if p.DiscountValue <= 0 || (p.DiscountType == models.DiscountPercent && p.DiscountValue > 100) {
+		return "discount_value must be positive and at most 100 for percent"
+	}
+	if p.MaxUses != nil && *p.MaxUses <= 0 {
+		return "max_uses must be positive"
+	}
+	if p.MaxUsesPerUser < 0 || p.MinAmount < 0 {
+		return "limits must not be negative"
+	}
+	v.Description, v.DiscountType, v.DiscountValue, v.MaxDiscount, v.MinAmount, v.MaxUses = p.Description, p.DiscountType, p.DiscountValue, p.MaxDiscount, p.MinAmount, p.MaxUses
+	v.MaxUsesPerUser = p.MaxUsesPerUser
+	if v.MaxUsesPerUser == 0 {
+		v.MaxUsesPerUser = 1
+	}
+
+	v.StartsAt = time.Now()
+	if p.StartsAt != "" {
+		t, err := time.Parse(time.RFC3339, p.StartsAt)
+		if err != nil {
+			return "invalid starts_at, use RFC3339"
+		}
+		v.StartsAt = t
+	}
+	v.EndsAt = nil
+	if p.EndsAt != "" {
+		t, err := time.Parse(time.RFC3339, p.EndsAt)
+		if err != nil {
+			return "invalid ends_at, use RFC3339"
+		}
+		if !t.After(v.StartsAt) {
+			return "ends_at must be after starts_at"
+		}
+		v.EndsAt = &t
+	}
+
+	v.AhliID = nil
+	if p.AhliID != "" {
+		id, err := uuid.Parse(p.AhliID)
+		if err != nil {
+			return "invalid ahli_id"
+		}
+		v.AhliID = &id
+	}
+	v.Category = nil
+	if p.Category != nil && *p.Category != "" {
+		ok, err := categoryAllowed(*p.Category, models.CategoryScopeAhli)
+		if err != nil || !ok {
+			return "unknown ahli category: " + *p.Category
+		}
+		v.Category = p.Category
+	}
+	if p.Active != nil {
+		v.Active = *p.Active
+	}
+	return ""
+}
+
+func AdminGetVouchers(c *fiber.Ctx) error {
+	vq := queries.VoucherQueries{DB: database.DB}
+	list, err := vq.GetVouchers(c.QueryBool("include_inactive", true))
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "failed to get vouchers"})
+	}
+	return c.Status(fiber.StatusOK).JSON(list)
+}
+
+func CreateVoucher(c *fiber.Ctx) error {
+	p := &models.VoucherRequest{}
+	if err := c.BodyParser(p); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "invalid body"})
+	}
+	v := &models.Voucher{ID: uuid.New(), Active: true, CreatedAt: time.Now(), UpdatedAt: time.Now()}
+	if msg := voucherFromRequest(p, v); msg != "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": msg})
+	}
+
+	vq := queries.VoucherQueries{DB: database.DB}
+	if err := vq.CreateVoucher(v); err != nil {
+		if err.Error() == "voucher code already exists" {
+			return c.Status(fiber.StatusConflict).JSON(fiber.Map{"error": err.Error()})
+		}
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "failed to create voucher"})
+	}
+	return c.Status(fiber.StatusCreated).JSON(v)
+}
+
+func UpdateVoucher(c *fiber.Ctx) error {
+	id, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "invalid id"})
+	}
+	p := &models.VoucherRequest{}
+	if err := c.BodyParser(p); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "invalid body"})
+	}
+
+	vq := queries.VoucherQueries{DB: database.DB}
+	v, err := vq.GetVoucherByID(id)
+	if err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "voucher not found"})
+	}
+	if msg := voucherFromRequest(p, &v); msg != "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": msg})
+	}
+	if err := vq.UpdateVoucher(&v); err != nil {
+		if err.Error() == "voucher code already exists" {
+			return c.Status(fiber.StatusConflict).JSON(fiber.Map{"error": err.Error()})
+		}
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "failed to update voucher"})
+	}
+	return c.Status(fiber.StatusOK).JSON(v)
+}
+
+func DeleteVoucher(c *fiber.Ctx) error {
+	id, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "invalid id"})
+	}
+	vq := queries.VoucherQueries{DB: database.DB}
+	if err := vq.DeactivateVoucher(id); err != nil {
+		if err.Error() == "voucher not found" {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": err.Error()})
+		}
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "failed to delete voucher"})
+	}
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{"message": "voucher deactivated"})
+}
+
+func GetVoucherRedemptions(c *fiber.Ctx) error {
+	id, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "invalid id"})
+	}
+	vq := queries.VoucherQueries{DB: database.DB}
+	list, err := vq.GetRedemptions(id, 500)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "failed to get redemptions"})
+	}
+	return c.Status(fiber.StatusOK).JSON(list)
+}
@@ -0,0 +1,48 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// AhliSearchParams holds the filters, sort and pagination for the ahli directory
+type AhliSearchParams struct {
+	Category    string
+	MinPrice    *float64
+	MaxPrice    *float64
+	MinRating   *float64
+	Gender      string
+	Language    string
+	AvailableOn *int // day of week, 0 = Sunday
+	Sort        string
+	Cursor      string
+	Limit       int
+}
+
+// AhliAvailability is a weekly recurring slot in which an ahli accepts sessions
+type AhliAvailability struct {
+	DayOfWeek int    `json:"day_of_week"`
+	StartTime string `json:"start_time"`
+	EndTime   string `json:"end_time"`
+}
+
+// UpdateAhliProfileRequest is sent by an ahli editing their own profile; nil fields are left unchanged
+type UpdateAhliProfileRequest struct {
+	Price           *float64            `json:"price,omitempty"`
+	Category        *string             `json:"category,omitempty"`
+	OpenTime        *string             `json:"open_time,omitempty"`
+	Bio             *string             `json:"bio,omitempty"`
+	Languages       *[]string           `json:"languages,omitempty"`
+	Specializations *[]string           `json:"specializations,omitempty"`
+	Availability    *[]AhliAvailability `json:"availability,omitempty"`
+}
+
+type AhliPriceChange struct {
+	ID        uuid.UUID `json:"id" db:"id"`
+	AhliID    uuid.UUID `json:"ahli_id" db:"ahli_id"`
+	OldPrice  *float64  `json:"old_price" db:"old_price"` // nil when the ahli had no price before
+	NewPrice  float64   `json:"new_price" db:"new_price"`
+	ChangedBy uuid.UUID `json:"changed_by" db:"changed_by"`
+	ChangedAt time.Time `json:"changed_at" db:"changed_at"`
+}
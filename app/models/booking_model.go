@@ -0,0 +1,40 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type Booking struct {
+	ID             uuid.UUID  `json:"id" db:"id"`
+	UserID         uuid.UUID  `json:"user_id" db:"user_id"`
+	AhliID         uuid.UUID  `json:"ahli_id" db:"ahli_id"`
+	TransactionID  *uuid.UUID `json:"transaction_id,omitempty" db:"transaction_id"`
+	SubscriptionID *uuid.UUID `json:"subscription_id,omitempty" db:"subscription_id"`
+	StartAt        time.Time  `json:"start_at" db:"start_at"`
+	EndAt          time.Time  `json:"end_at" db:"end_at"`
+	Price          int64      `json:"price" db:"price"`
+	Status         string     `json:"status" db:"status"`
+	CreatedAt      time.Time  `json:"created_at" db:"created_at"`
+	UpdatedAt      time.Time  `json:"updated_at" db:"updated_at"`
+
+	Username string `json:"username,omitempty"`
+	Avatar   string `json:"avatar,omitempty"`
+}
+
+// EarningsPeriod aggregates completed transactions of an ahli for one period bucket
+type EarningsPeriod struct {
+	Period time.Time `json:"period"`
+	Count  int       `json:"count"`
+	Total  int64     `json:"total"`
+}
+
+// AhliClient is a user the ahli has a chat room with
+type AhliClient struct {
+	UserID        uuid.UUID  `json:"user_id"`
+	Username      string     `json:"username"`
+	Avatar        string     `json:"avatar,omitempty"`
+	RoomID        uuid.UUID  `json:"room_id"`
+	LastMessageAt *time.Time `json:"last_message_at,omitempty"`
+}
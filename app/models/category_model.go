@@ -0,0 +1,43 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Category scopes say where a category value may be used
+const (
+	CategoryScopeAhli = "ahli"
+	CategoryScopeRoom = "room"
+	CategoryScopeGoal = "goal"
+)
+
+type Category struct {
+	ID          uuid.UUID `json:"id" db:"id"`
+	Slug        string    `json:"slug" db:"slug"`
+	Name        string    `json:"name" db:"name"`
+	Description *string   `json:"description,omitempty" db:"description"`
+	Scopes      []string  `json:"scopes" db:"scopes"`
+	Active      bool      `json:"active" db:"active"`
+	CreatedAt   time.Time `json:"created_at" db:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at" db:"updated_at"`
+}
+
+type CategoryRequest struct {
+	Slug        string   `json:"slug" validate:"required,lte=100"`
+	Name        string   `json:"name" validate:"required,lte=100"`
+	Description *string  `json:"description,omitempty"`
+	Scopes      []string `json:"scopes" validate:"required,min=1,dive,oneof=ahli room goal"`
+	Active      *bool    `json:"active,omitempty"`
+}
+
+// UpdateCategoryRequest edits a category. The slug is stored as plain text by rooms, ahli, vouchers
+// and goals, so it cannot change; it may be sent only if it matches the current one.
+type UpdateCategoryRequest struct {
+	Slug        string   `json:"slug,omitempty" validate:"lte=100"`
+	Name        string   `json:"name" validate:"required,lte=100"`
+	Description *string  `json:"description,omitempty"`
+	Scopes      []string `json:"scopes" validate:"required,min=1,dive,oneof=ahli room goal"`
+	Active      *bool    `json:"active,omitempty"`
+}
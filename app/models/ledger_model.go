@@ -0,0 +1,57 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Ledger accounts. A completed payment debits user_payment and credits
+// platform_commission and ahli_earnings; a payout debits ahli_earnings and credits ahli_payout.
+const (
+	AccountUserPayment        = "user_payment"
+	AccountPlatformCommission = "platform_commission"
+	AccountAhliEarnings       = "ahli_earnings"
+	AccountAhliPayout         = "ahli_payout"
+)
+
+type LedgerEntry struct {
+	ID            uuid.UUID  `json:"id" db:"id"`
+	JournalID     uuid.UUID  `json:"journal_id" db:"journal_id"`
+	EntryType     string     `json:"entry_type" db:"entry_type"`
+	TransactionID *uuid.UUID `json:"transaction_id,omitempty" db:"transaction_id"`
+	PayoutID      *uuid.UUID `json:"payout_id,omitempty" db:"payout_id"`
+	AhliID        *uuid.UUID `json:"ahli_id,omitempty" db:"ahli_id"`
+	Account       string     `json:"account" db:"account"`
+	Debit         int64      `json:"debit" db:"debit"`
+	Credit        int64      `json:"credit" db:"credit"`
+	Status        string     `json:"status" db:"status"`
+	CreatedAt     time.Time  `json:"created_at" db:"created_at"`
+}
+
+type Payout struct {
+	ID        uuid.UUID `json:"id" db:"id"`
+	BatchID   uuid.UUID `json:"batch_id" db:"batch_id"`
+	AhliID    uuid.UUID `json:"ahli_id" db:"ahli_id"`
+	Amount    int64     `json:"amount" db:"amount"`
+	Entries   int       `json:"entries" db:"entries"`
+	Status    string    `json:"status" db:"status"`
+	CreatedBy uuid.UUID `json:"created_by" db:"created_by"`
+	CreatedAt time.Time `json:"created_at" db:"created_at"`
+}
+
+type CreatePayoutBatchRequest struct {
+	AhliID string `json:"ahli_id,omitempty"`
+	Until  string `json:"until,omitempty"`
+}
+
+// SettlementRow is one transaction line of an ahli monthly settlement report
+type SettlementRow struct {
+	TransactionID uuid.UUID  `json:"transaction_id"`
+	CompletedAt   time.Time  `json:"completed_at"`
+	UserPayment   int64      `json:"user_payment"`
+	Commission    int64      `json:"commission"`
+	AhliEarnings  int64      `json:"ahli_earnings"`
+	Status        string     `json:"status"`
+	PayoutID      *uuid.UUID `json:"payout_id,omitempty"`
+}
@@ -7,34 +7,178 @@ import (
 )
 
 type Room struct {
+	ID         uuid.UUID  `json:"id" db:"id"`
+	OwnerID    uuid.UUID  `json:"owner_id" db:"owner_id"`
+	TargetID   *uuid.UUID `json:"target_id,omitempty" db:"target_id"`
+	ListenerID *uuid.UUID `json:"listener_id,omitempty" db:"listener_id"`
+	Category   string     `json:"category" db:"category"`
+	Visible    bool       `json:"visible" db:"visible"`
+	IsGroup    bool       `json:"is_group" db:"is_group"`
+	Status     string     `json:"status" db:"status"`
+	BookingID  *uuid.UUID `json:"booking_id,omitempty" db:"booking_id"`
+	EndedAt    *time.Time `json:"ended_at,omitempty" db:"ended_at"`
+	EndedBy    *uuid.UUID `json:"ended_by,omitempty" db:"ended_by"`
+	EndReason  string     `json:"end_reason,omitempty" db:"end_reason"`
+	CreatedAt  time.Time  `json:"created_at" db:"created_at"`
+	UpdatedAt  time.Time  `json:"updated_at" db:"updated_at"`
+	// LastActivityAt is when a message was last sent in the room; idle rooms are closed by it
+	LastActivityAt time.Time `json:"last_activity_at" db:"last_activity_at"`
+}
+
+type Message struct {
 	ID        uuid.UUID  `json:"id" db:"id"`
-	OwnerID   uuid.UUID  `json:"owner_id" db:"owner_id"`
-	TargetID  *uuid.UUID `json:"target_id,omitempty" db:"target_id"`
-	Category  string     `json:"category" db:"category"`
+	RoomID    uuid.UUID  `json:"room_id" db:"room_id"`
+	UserID    uuid.UUID  `json:"user_id" db:"user_id"`
+	Type      string     `json:"type" db:"type"`
+	Text      string     `json:"text" db:"text"`
 	Visible   bool       `json:"visible" db:"visible"`
 	CreatedAt time.Time  `json:"created_at" db:"created_at"`
-	UpdatedAt time.Time  `json:"updated_at" db:"updated_at"`
+	EditedAt  *time.Time `json:"edited_at,omitempty" db:"edited_at"`
+	DeletedAt *time.Time `json:"deleted_at,omitempty" db:"deleted_at"`
+	ReplyToID *uuid.UUID `json:"reply_to_message_id,omitempty" db:"reply_to_message_id"`
+
+	ReplyTo     *MessagePreview `json:"reply_to,omitempty"`
+	Attachments []Attachment    `json:"attachments,omitempty"`
+	Reactions   []Reaction      `json:"reactions,omitempty"`
 }
 
-type Message struct {
-	ID        uuid.UUID `json:"id" db:"id"`
-	RoomID    uuid.UUID `json:"room_id" db:"room_id"`
-	UserID    uuid.UUID `json:"user_id" db:"user_id"`
-	Text      string    `json:"text" db:"text"`
-	Visible   bool      `json:"visible" db:"visible"`
-	CreatedAt time.Time `json:"created_at" db:"created_at"`
+// MessagePreview is the short form of a replied-to message shown above a reply
+type MessagePreview struct {
+	ID      uuid.UUID `json:"id"`
+	UserID  uuid.UUID `json:"user_id"`
+	Type    string    `json:"type"`
+	Text    string    `json:"text"`
+	Deleted bool      `json:"deleted,omitempty"`
+}
+
+// Reaction groups the users that reacted to a message with the same emoji
+type Reaction struct {
+	Emoji   string      `json:"emoji"`
+	Count   int         `json:"count"`
+	UserIDs []uuid.UUID `json:"user_ids"`
+}
+
+// ReactionRequest adds an emoji reaction to a message
+type ReactionRequest struct {
+	Emoji string `json:"emoji"`
+}
+
+// Message types
+const (
+	MessageTypeText  = "text"
+	MessageTypeImage = "image"
+	MessageTypeAudio = "audio"
+	MessageTypeFile  = "file"
+)
+
+// Attachment is an uploaded file sent with a message; the blob itself lives in storage
+type Attachment struct {
+	ID           uuid.UUID `json:"id" db:"id"`
+	MessageID    uuid.UUID `json:"message_id" db:"message_id"`
+	StorageKey   string    `json:"-" db:"storage_key"`
+	ThumbnailKey string    `json:"-" db:"thumbnail_key"`
+	FileName     string    `json:"file_name" db:"file_name"`
+	MimeType     string    `json:"mime_type" db:"mime_type"`
+	Size         int64     `json:"size" db:"size_bytes"`
+	Width        *int      `json:"width,omitempty" db:"width"`
+	Height       *int      `json:"height,omitempty" db:"height"`
+	URL          string    `json:"url"`
+	ThumbnailURL string    `json:"thumbnail_url,omitempty"`
+	CreatedAt    time.Time `json:"created_at" db:"created_at"`
 }
 
 type CreateRoomRequest struct {
 	Category string `json:"category,omitempty"`
 	Visible  bool   `json:"visible,omitempty"`
 	TargetID string `json:"target_id,omitempty"`
+	IsGroup  bool   `json:"is_group,omitempty"`
+	// BookingID ties a direct room to a confirmed booking; the room closes when the booking ends
+	BookingID string `json:"booking_id,omitempty"`
+}
+
+// Room statuses: an active room takes new messages, an ended one is read-only history and
+// an archived one is additionally hidden from room lists
+const (
+	RoomStatusActive   = "active"
+	RoomStatusEnded    = "ended"
+	RoomStatusArchived = "archived"
+)
+
+// Reasons a room was ended
+const (
+	RoomEndReasonMember           = "ended_by_member"
+	RoomEndReasonIdle             = "idle"
+	RoomEndReasonBookingElapsed   = "booking_elapsed"
+	RoomEndReasonBookingCancelled = "booking_cancelled"
+)
+
+// Room member roles
+const (
+	RoomRoleOwner     = "owner"
+	RoomRoleModerator = "moderator"
+	RoomRoleMember    = "member"
+)
+
+// RoomMember is a participant of a room
+type RoomMember struct {
+	RoomID    uuid.UUID  `json:"room_id" db:"room_id"`
+	UserID    uuid.UUID  `json:"user_id" db:"user_id"`
+	Username  string     `json:"username,omitempty"`
+	Role      string     `json:"role" db:"role"`
+	InvitedBy *uuid.UUID `json:"invited_by,omitempty" db:"invited_by"`
+	JoinedAt  time.Time  `json:"joined_at" db:"joined_at"`
+
+	LastReadMessageID *uuid.UUID `json:"last_read_message_id,omitempty" db:"last_read_message_id"`
+	LastReadAt        *time.Time `json:"last_read_at,omitempty" db:"last_read_at"`
+}
+
+// MarkReadRequest marks a room as read up to a message; without message_id up to the newest one
+type MarkReadRequest struct {
+	MessageID string `json:"message_id,omitempty"`
+}
+
+// ReadReceipt is the read position of one member in a room
+type ReadReceipt struct {
+	RoomID    uuid.UUID `json:"room_id"`
+	UserID    uuid.UUID `json:"user_id"`
+	MessageID uuid.UUID `json:"message_id"`
+	ReadAt    time.Time `json:"read_at"`
+}
+
+// InviteRoomMemberRequest adds a user to a group room; role defaults to member
+type InviteRoomMemberRequest struct {
+	UserID string `json:"user_id"`
+	Role   string `json:"role,omitempty"`
 }
 
 type CreateMessageRequest struct {
-	RoomID  string `json:"room_id,omitempty"`
-	Text    string `json:"text,omitempty"`
-	Visible *bool  `json:"visible,omitempty"`
+	RoomID           string `json:"room_id,omitempty"`
+	Text             string `json:"text,omitempty"`
+	Visible          *bool  `json:"visible,omitempty"`
+	ReplyToMessageID string `json:"reply_to_message_id,omitempty"`
+}
+
+// EditMessageRequest replaces the text of a message
+type EditMessageRequest struct {
+	Text string `json:"text"`
+}
+
+// Message audit actions
+const (
+	MessageAuditEdit   = "edit"
+	MessageAuditDelete = "delete"
+)
+
+// MessageAudit preserves the text a message had before it was edited or deleted
+type MessageAudit struct {
+	ID        uuid.UUID  `json:"id" db:"id"`
+	MessageID uuid.UUID  `json:"message_id" db:"message_id"`
+	RoomID    uuid.UUID  `json:"room_id" db:"room_id"`
+	ActorID   *uuid.UUID `json:"actor_id,omitempty" db:"actor_id"`
+	Action    string     `json:"action" db:"action"`
+	OldText   string     `json:"old_text" db:"old_text"`
+	NewText   *string    `json:"new_text,omitempty" db:"new_text"`
+	CreatedAt time.Time  `json:"created_at" db:"created_at"`
 }
 
 type MatchRequest struct {
@@ -47,6 +191,22 @@ type RecentChat struct {
 	RoomID      uuid.UUID `json:"room_id"`
 	LastMessage string    `json:"last_message"`
 	LastAt      time.Time `json:"last_at"`
+	Unread      int       `json:"unread"`
+}
+
+// MessageSearchResult is a message matching a search. The snippet is HTML-escaped message text with
+// the matched words wrapped in <mark> tags, safe to render as HTML.
+type MessageSearchResult struct {
+	Message
+	Snippet string  `json:"snippet"`
+	Rank    float64 `json:"rank"`
+}
+
+// Presence tells whether a user is connected and when they were last seen
+type Presence struct {
+	UserID   uuid.UUID  `json:"user_id"`
+	Online   bool       `json:"online"`
+	LastSeen *time.Time `json:"last_seen,omitempty"`
 }
 
 type Notification struct {
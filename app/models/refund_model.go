@@ -0,0 +1,52 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Refund request statuses
+const (
+	RefundPending   = "pending"
+	RefundApproved  = "approved"
+	RefundRejected  = "rejected"
+	RefundCompleted = "completed"
+	RefundFailed    = "failed"
+	// RefundProviderRefunded means the gateway returned the money but booking it locally failed;
+	// such requests are retried until they complete
+	RefundProviderRefunded = "provider_refunded"
+)
+
+type RefundRequest struct {
+	ID            uuid.UUID  `json:"id" db:"id"`
+	TransactionID uuid.UUID  `json:"transaction_id" db:"transaction_id"`
+	UserID        uuid.UUID  `json:"user_id" db:"user_id"`
+	AhliID        uuid.UUID  `json:"ahli_id" db:"ahli_id"`
+	RequestedBy   *uuid.UUID `json:"requested_by,omitempty" db:"requested_by"`
+	Amount        int64      `json:"amount" db:"amount"`
+	Reason        string     `json:"reason" db:"reason"`
+	Status        string     `json:"status" db:"status"`
+	DecidedBy     *uuid.UUID `json:"decided_by,omitempty" db:"decided_by"`
+	DecidedAt     *time.Time `json:"decided_at,omitempty" db:"decided_at"`
+	DecisionNote  *string    `json:"decision_note,omitempty" db:"decision_note"`
+	Error         *string    `json:"error,omitempty" db:"error"`
+	CreatedAt     time.Time  `json:"created_at" db:"created_at"`
+	UpdatedAt     time.Time  `json:"updated_at" db:"updated_at"`
+}
+
+// CreateRefundRequest is sent by a user; a zero amount asks for everything not yet refunded
+type CreateRefundRequest struct {
+	Amount int64  `json:"amount,omitempty"`
+	Reason string `json:"reason"`
+}
+
+// RefundDecisionRequest approves or rejects a refund; Amount may lower the refunded amount on approval
+type RefundDecisionRequest struct {
+	Amount int64  `json:"amount,omitempty"`
+	Note   string `json:"note,omitempty"`
+}
+
+type CancelBookingRequest struct {
+	Reason string `json:"reason"`
+}
@@ -0,0 +1,53 @@
+package models
+
+import "time"
+
+// RevenueRow aggregates the transactions of one report group: a period, an ahli or a category
+type RevenueRow struct {
+	Key          string  `json:"key"`
+	Label        string  `json:"label,omitempty"`
+	Transactions int     `json:"transactions"`
+	Paid         int     `json:"paid"`
+	Failed       int     `json:"failed"`
+	Expired      int     `json:"expired"`
+	Pending      int     `json:"pending"`
+	SuccessRate  float64 `json:"success_rate"`
+	Gross        int64   `json:"gross"`
+	Discounts    int64   `json:"discounts"`
+	Refunded     int64   `json:"refunded"`
+	Net          int64   `json:"net"`
+}
+
+// RevenueReport is the admin financial report over [From, To)
+type RevenueReport struct {
+	GroupBy string       `json:"group_by"`
+	From    time.Time    `json:"from"`
+	To      time.Time    `json:"to"`
+	Kind    string       `json:"kind,omitempty"`
+	Rows    []RevenueRow `json:"rows"`
+	Totals  RevenueRow   `json:"totals"`
+}
+
+// Add accumulates another row into r and refreshes the success rate
+func (r *RevenueRow) Add(o RevenueRow) {
+	r.Transactions += o.Transactions
+	r.Paid += o.Paid
+	r.Failed += o.Failed
+	r.Expired += o.Expired
+	r.Pending += o.Pending
+	r.Gross += o.Gross
+	r.Discounts += o.Discounts
+	r.Refunded += o.Refunded
+	r.Net += o.Net
+	r.ComputeSuccessRate()
+}
+
+// ComputeSuccessRate sets the share of settled transactions that were paid; pending ones are not counted
+func (r *RevenueRow) ComputeSuccessRate() {
+	settled := r.Paid + r.Failed + r.Expired
+	if settled == 0 {
+		r.SuccessRate = 0
+		return
+	}
+	r.SuccessRate = float64(r.Paid) / float64(settled)
+}
@@ -0,0 +1,91 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Transaction kinds
+const (
+	TransactionKindConsultation = "consultation"
+	TransactionKindPackage      = "package"
+	TransactionKindTip          = "tip"
+)
+
+// Subscription statuses
+const (
+	SubscriptionPending   = "pending"
+	SubscriptionActive    = "active"
+	SubscriptionExpired   = "expired"
+	SubscriptionCancelled = "cancelled"
+)
+
+// SessionPackage is a bundle of sessions with one ahli, valid for PeriodDays after payment.
+// Recurring packages can be renewed automatically at the end of each period.
+type SessionPackage struct {
+	ID          uuid.UUID `json:"id" db:"id"`
+	AhliID      uuid.UUID `json:"ahli_id" db:"ahli_id"`
+	Name        string    `json:"name" db:"name"`
+	Description string    `json:"description" db:"description"`
+	Sessions    int       `json:"sessions" db:"sessions"`
+	Price       int64     `json:"price" db:"price"`
+	PeriodDays  int       `json:"period_days" db:"period_days"`
+	Recurring   bool      `json:"recurring" db:"recurring"`
+	Active      bool      `json:"active" db:"active"`
+	CreatedAt   time.Time `json:"created_at" db:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at" db:"updated_at"`
+}
+
+type SessionPackageRequest struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	Sessions    int    `json:"sessions"`
+	Price       int64  `json:"price"`
+	PeriodDays  int    `json:"period_days"`
+	Recurring   bool   `json:"recurring"`
+	Active      *bool  `json:"active,omitempty"`
+}
+
+type Subscription struct {
+	ID                   uuid.UUID  `json:"id" db:"id"`
+	UserID               uuid.UUID  `json:"user_id" db:"user_id"`
+	AhliID               uuid.UUID  `json:"ahli_id" db:"ahli_id"`
+	PackageID            uuid.UUID  `json:"package_id" db:"package_id"`
+	Status               string     `json:"status" db:"status"`
+	AutoRenew            bool       `json:"auto_renew" db:"auto_renew"`
+	CreditsTotal         int        `json:"credits_total" db:"credits_total"`
+	CreditsRemaining     int        `json:"credits_remaining" db:"credits_remaining"`
+	CurrentPeriodStart   *time.Time `json:"current_period_start,omitempty" db:"current_period_start"`
+	CurrentPeriodEnd     *time.Time `json:"current_period_end,omitempty" db:"current_period_end"`
+	RenewalTransactionID *uuid.UUID `json:"renewal_transaction_id,omitempty" db:"renewal_transaction_id"`
+	CreatedAt            time.Time  `json:"created_at" db:"created_at"`
+	UpdatedAt            time.Time  `json:"updated_at" db:"updated_at"`
+
+	// a renewal paid in advance, which starts when the current period ends
+	NextPeriodCredits *int       `json:"next_period_credits,omitempty" db:"next_period_credits"`
+	NextPeriodEnd     *time.Time `json:"next_period_end,omitempty" db:"next_period_end"`
+
+	PackageName  string `json:"package_name,omitempty"`
+	PackagePrice int64  `json:"package_price,omitempty"`
+	PaymentURL   string `json:"payment_url,omitempty"`
+}
+
+type CreateSubscriptionRequest struct {
+	PackageID string `json:"package_id"`
+	AutoRenew bool   `json:"auto_renew"`
+}
+
+type CreateSubscriptionResponse struct {
+	Subscription  Subscription `json:"subscription"`
+	TransactionID uuid.UUID    `json:"transaction_id"`
+	PaymentURL    string       `json:"payment_url"`
+	SnapToken     string       `json:"snap_token,omitempty"`
+}
+
+// CreditBookingRequest books a session paid with a subscription credit
+type CreditBookingRequest struct {
+	SubscriptionID  string `json:"subscription_id"`
+	StartAt         string `json:"start_at"`
+	DurationMinutes int    `json:"duration_minutes,omitempty"`
+}
@@ -0,0 +1,31 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// CreateTipRequest is sent by a pencerita tipping the pendengar of a matched room
+type CreateTipRequest struct {
+	Amount int64 `json:"amount"`
+}
+
+// ReceivedTip is a tip as seen by the listener; the sender stays anonymous
+type ReceivedTip struct {
+	TransactionID uuid.UUID  `json:"transaction_id"`
+	RoomID        *uuid.UUID `json:"room_id,omitempty"`
+	Amount        int64      `json:"amount"`
+	Status        string     `json:"status"`
+	CreatedAt     time.Time  `json:"created_at"`
+}
+
+// ListenerEarnings summarizes the tips a pendengar has received and how much is still to be paid out
+type ListenerEarnings struct {
+	AcceptsTips bool          `json:"accepts_tips"`
+	Tips        int           `json:"tips"`
+	Total       int64         `json:"total"`
+	Pending     int64         `json:"pending"`
+	PaidOut     int64         `json:"paid_out"`
+	Recent      []ReceivedTip `json:"recent"`
+}
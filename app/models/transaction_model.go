@@ -1,28 +1,130 @@
 package models
 
 import (
+	"encoding/json"
 	"time"
 
 	"github.com/google/uuid"
 )
 
 type Transaction struct {
-	ID         uuid.UUID `json:"id" db:"id"`
-	UserID     uuid.UUID `json:"user_id" db:"user_id"`
-	AhliID     uuid.UUID `json:"ahli_id" db:"ahli_id"`
-	Amount     int64     `json:"amount" db:"amount"`
-	Status     string    `json:"status" db:"status"`
-	PaymentURL string    `json:"payment_url,omitempty" db:"payment_url"`
-	CreatedAt  time.Time `json:"created_at" db:"created_at"`
-	UpdatedAt  time.Time `json:"updated_at" db:"updated_at"`
+	ID             uuid.UUID  `json:"id" db:"id"`
+	UserID         uuid.UUID  `json:"user_id" db:"user_id"`
+	AhliID         uuid.UUID  `json:"ahli_id" db:"ahli_id"`
+	Kind           string     `json:"kind" db:"kind"`
+	SubscriptionID *uuid.UUID `json:"subscription_id,omitempty" db:"subscription_id"`
+	RoomID         *uuid.UUID `json:"room_id,omitempty" db:"room_id"`
+	Amount         int64      `json:"amount" db:"amount"`
+	VoucherID      *uuid.UUID `json:"voucher_id,omitempty" db:"voucher_id"`
+	DiscountAmount int64      `json:"discount_amount" db:"discount_amount"`
+	RefundedAmount int64      `json:"refunded_amount" db:"refunded_amount"`
+	Status         string     `json:"status" db:"status"`
+	PaymentURL     string     `json:"payment_url,omitempty" db:"payment_url"`
+	SnapToken      string     `json:"snap_token,omitempty" db:"snap_token"`
+	CreatedAt      time.Time  `json:"created_at" db:"created_at"`
+	UpdatedAt      time.Time  `json:"updated_at" db:"updated_at"`
+}
+
+// Transaction statuses
+const (
+	TransactionPending   = "pending"
+	TransactionCompleted = "completed"
+	TransactionFailed    = "failed"
+	TransactionExpired   = "expired"
+
+	TransactionPartiallyRefunded = "partially_refunded"
+	TransactionRefunded          = "refunded"
+)
+
+// transactionTransitions lists the statuses a transaction may move to from each status
+var transactionTransitions = map[string][]string{
+	TransactionPending:           {TransactionCompleted, TransactionFailed, TransactionExpired},
+	TransactionCompleted:         {TransactionPartiallyRefunded, TransactionRefunded},
+	TransactionPartiallyRefunded: {TransactionPartiallyRefunded, TransactionRefunded},
+}
+
+// Refundable reports whether a transaction in this status can still be refunded
+func Refundable(status string) bool {
+	return status == TransactionCompleted || status == TransactionPartiallyRefunded
+}
+
+// CanTransitionTransaction reports whether a transaction may move from one status to another
+func CanTransitionTransaction(from, to string) bool {
+	for _, s := range transactionTransitions[from] {
+		if s == to {
+			return true
+		}
+	}
+	return false
+}
+
+// PaymentNotification is a raw payment webhook as received, kept for auditing and replay
+type PaymentNotification struct {
+	ID                uuid.UUID       `json:"id" db:"id"`
+	OrderID           string          `json:"order_id" db:"order_id"`
+	TransactionStatus string          `json:"transaction_status" db:"transaction_status"`
+	SignatureValid    bool            `json:"signature_valid" db:"signature_valid"`
+	Payload           json.RawMessage `json:"payload" db:"payload"`
+	Outcome           string          `json:"outcome" db:"outcome"`
+	Error             *string         `json:"error,omitempty" db:"error"`
+	ReceivedAt        time.Time       `json:"received_at" db:"received_at"`
+	ProcessedAt       *time.Time      `json:"processed_at,omitempty" db:"processed_at"`
 }
 
 type CreateTransactionRequest struct {
-	AhliID string `json:"ahli_id,omitempty"`
-	Amount int64  `json:"amount,omitempty"`
+	AhliID          string `json:"ahli_id,omitempty"`
+	Amount          int64  `json:"amount,omitempty"`
+	StartAt         string `json:"start_at,omitempty"`
+	DurationMinutes int    `json:"duration_minutes,omitempty"`
+	VoucherCode     string `json:"voucher_code,omitempty"`
 }
 
 type CreateTransactionResponse struct {
-	ID         uuid.UUID `json:"id"`
-	PaymentURL string    `json:"payment_url"`
+	ID         uuid.UUID  `json:"id"`
+	PaymentURL string     `json:"payment_url"`
+	SnapToken  string     `json:"snap_token,omitempty"`
+	BookingID  *uuid.UUID `json:"booking_id,omitempty"`
+	Amount     int64      `json:"amount"`
+	Discount   int64      `json:"discount,omitempty"`
+}
+
+// ReconcileReport summarizes one reconciliation run over stale pending transactions
+type ReconcileReport struct {
+	StartedAt  time.Time           `json:"started_at"`
+	FinishedAt time.Time           `json:"finished_at"`
+	Checked    int                 `json:"checked"`
+	Applied    int                 `json:"applied"`
+	Expired    int                 `json:"expired"`
+	Errors     int                 `json:"errors"`
+	Mismatches []ReconcileMismatch `json:"mismatches"`
+}
+
+// ReconcileMismatch is a transaction whose local status disagreed with the payment provider
+type ReconcileMismatch struct {
+	TransactionID  uuid.UUID `json:"transaction_id"`
+	LocalStatus    string    `json:"local_status"`
+	ProviderStatus string    `json:"provider_status"`
+	Action         string    `json:"action"`
+	Error          string    `json:"error,omitempty"`
+}
+
+// TransactionHistoryItem is a transaction listed to its user together with the ahli it paid
+type TransactionHistoryItem struct {
+	Transaction
+	AhliName     string `json:"ahli_name"`
+	AhliAvatar   string `json:"ahli_avatar,omitempty"`
+	AhliCategory string `json:"ahli_category,omitempty"`
+}
+
+// Receipt is the data printed on a transaction receipt
+type Receipt struct {
+	Number         string
+	Transaction    Transaction
+	UserName       string
+	UserEmail      string
+	AhliName       string
+	AhliCategory   string
+	SessionStartAt *time.Time
+	SessionEndAt   *time.Time
+	IssuedAt       time.Time
 }
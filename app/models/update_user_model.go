@@ -5,4 +5,5 @@ type UpdateUserRequest struct {
 	PhoneNumber *string `json:"phone_number"`
 	Gender      *string `json:"gender"`
 	Avatar      *int    `json:"avatar"`
+	AcceptsTips *bool   `json:"accepts_tips"`
 }
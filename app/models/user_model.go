@@ -17,20 +17,29 @@ type User struct {
 	Verified     bool      `json:"verified"`
 	OTP          string    `json:"-"`
 	UserRole     string    `json:"user_role"`
+	AcceptsTips  bool      `json:"accepts_tips"`
 
 	Price    float64 `json:"price,omitempty"`
 	Category string  `json:"category,omitempty"`
 	OpenTime string  `json:"open_time,omitempty"`
 	Rating   float64 `json:"rating,omitempty"`
+	Bio      string  `json:"bio,omitempty"`
+
+	Languages       []string   `json:"languages,omitempty"`
+	Specializations []string   `json:"specializations,omitempty"`
+	NextAvailableAt *time.Time `json:"next_available_at,omitempty"`
+
+	Availability []AhliAvailability `json:"availability,omitempty"`
 
 	CreatedAt time.Time `json:"created_at"`
 	UpdatedAt time.Time `json:"updated_at"`
 }
 
 type PromoteAhliRequest struct {
-	UserID   uuid.UUID `json:"user_id,omitempty"`
-	Price    float64   `json:"price"`
-	Category string    `json:"category"`
-	OpenTime string    `json:"open_time"`
-	Rating   float64   `json:"rating,omitempty"`
+	UserID          uuid.UUID `json:"user_id,omitempty"`
+	Price           float64   `json:"price"`
+	Category        string    `json:"category"`
+	Specializations []string  `json:"specializations,omitempty"`
+	OpenTime        string    `json:"open_time"`
+	Rating          float64   `json:"rating,omitempty"`
 }
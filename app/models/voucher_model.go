@@ -0,0 +1,90 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Voucher discount types
+const (
+	DiscountPercent = "percent"
+	DiscountFixed   = "fixed"
+)
+
+// Voucher is a promo code. A nil AhliID or Category means the voucher is not limited to one.
+type Voucher struct {
+	ID             uuid.UUID  `json:"id" db:"id"`
+	Code           string     `json:"code" db:"code"`
+	Description    string     `json:"description" db:"description"`
+	DiscountType   string     `json:"discount_type" db:"discount_type"`
+	DiscountValue  int64      `json:"discount_value" db:"discount_value"`
+	MaxDiscount    *int64     `json:"max_discount,omitempty" db:"max_discount"`
+	MinAmount      int64      `json:"min_amount" db:"min_amount"`
+	MaxUses        *int       `json:"max_uses,omitempty" db:"max_uses"`
+	MaxUsesPerUser int        `json:"max_uses_per_user" db:"max_uses_per_user"`
+	UsedCount      int        `json:"used_count" db:"used_count"`
+	StartsAt       time.Time  `json:"starts_at" db:"starts_at"`
+	EndsAt         *time.Time `json:"ends_at,omitempty" db:"ends_at"`
+	AhliID         *uuid.UUID `json:"ahli_id,omitempty" db:"ahli_id"`
+	Category       *string    `json:"category,omitempty" db:"category"`
+	Active         bool       `json:"active" db:"active"`
+	CreatedAt      time.Time  `json:"created_at" db:"created_at"`
+	UpdatedAt      time.Time  `json:"updated_at" db:"updated_at"`
+}
+
+// Discount returns the discount the voucher gives on amount, never more than amount
+func (v Voucher) Discount(amount int64) int64 {
+	d := v.DiscountValue
+	if v.DiscountType == DiscountPercent {
+		d = amount * v.DiscountValue / 100
+		if v.MaxDiscount != nil && d > *v.MaxDiscount {
+			d = *v.MaxDiscount
+		}
+	}
+	if d > amount {
+		d = amount
+	}
+	return d
+}
+
+type VoucherRequest struct {
+	Code           string  `json:"code"`
+	Description    string  `json:"description"`
+	DiscountType   string  `json:"discount_type"`
+	DiscountValue  int64   `json:"discount_value"`
+	MaxDiscount    *int64  `json:"max_discount,omitempty"`
+	MinAmount      int64   `json:"min_amount"`
+	MaxUses        *int    `json:"max_uses,omitempty"`
+	MaxUsesPerUser int     `json:"max_uses_per_user"`
+	StartsAt       string  `json:"starts_at,omitempty"`
+	EndsAt         string  `json:"ends_at,omitempty"`
+	AhliID         string  `json:"ahli_id,omitempty"`
+	Category       *string `json:"category,omitempty"`
+	Active         *bool   `json:"active,omitempty"`
+}
+
+type VoucherRedemption struct {
+	ID            uuid.UUID `json:"id" db:"id"`
+	VoucherID     uuid.UUID `json:"voucher_id" db:"voucher_id"`
+	UserID        uuid.UUID `json:"user_id" db:"user_id"`
+	TransactionID uuid.UUID `json:"transaction_id" db:"transaction_id"`
+	Discount      int64     `json:"discount" db:"discount"`
+	Status        string    `json:"status" db:"status"`
+	CreatedAt     time.Time `json:"created_at" db:"created_at"`
+	UpdatedAt     time.Time `json:"updated_at" db:"updated_at"`
+}
+
+// VoucherCheck is what a voucher would do to a purchase, as shown before paying
+type VoucherCheck struct {
+	Code     string `json:"code"`
+	Amount   int64  `json:"amount"`
+	Discount int64  `json:"discount"`
+	Total    int64  `json:"total"`
+}
+
+type ValidateVoucherRequest struct {
+	Code   string `json:"code"`
+	AhliID string `json:"ahli_id"`
+	Amount int64  `json:"amount,omitempty"`
+}
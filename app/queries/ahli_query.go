@@ -0,0 +1,462 @@
+package queries
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gilanghuda/sobi-backend/app/models"
+	"github.com/gilanghuda/sobi-backend/pkg/utils"
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+)
+
+type AhliQueries struct {
+	DB *sql.DB
+}
+
+const (
+	AhliSortRating  = "rating"
+	AhliSortPrice   = "price"
+	AhliSortSoonest = "soonest"
+)
+
+// ahliDirectoryQuery selects every ahli together with the start of their next
+// availability slot. Ahli without weekly slots fall back to a daily open_time.
+const ahliDirectoryQuery = `
+	SELECT u.uid, u.username, u.user_role, u.email, u.phone_number, u.gender, u.avatar, u.verified, u.created_at, u.updated_at,
+	a.price, a.category, a.open_time, a.rating, a.bio, a.languages,
+	ARRAY(SELECT c.slug FROM ahli_specializations sp JOIN categories c ON c.id = sp.category_id WHERE sp.ahli_id = a.uid ORDER BY c.slug) AS specializations,
+	COALESCE(av.next_at, CASE
+		WHEN a.open_time IS NULL THEN NULL
+		WHEN date_trunc('day', now()) + a.open_time > now() THEN date_trunc('day', now()) + a.open_time
+		ELSE date_trunc('day', now()) + interval '1 day' + a.open_time
+	END) AS next_available_at
+	FROM users u
+	JOIN ahli a ON u.uid = a.uid
+	LEFT JOIN LATERAL (
+		SELECT min(CASE WHEN s.slot_end > now() THEN GREATEST(s.slot_start, now()) ELSE s.slot_start + interval '7 days' END) AS next_at
+		FROM (
+			SELECT date_trunc('day', now()) + ((x.day_of_week - EXTRACT(DOW FROM now())::int + 7) % 7) * interval '1 day' + x.start_time AS slot_start,
+			date_trunc('day', now()) + ((x.day_of_week - EXTRACT(DOW FROM now())::int + 7) % 7) * interval '1 day' + x.end_time AS slot_end
+			FROM ahli_availability x WHERE x.ahli_id = a.uid
+		) s
+	) av ON true`
+
+func scanAhliRow(row interface{ Scan(...interface{}) error }, user *models.User) error {
+	var price, rating sql.NullFloat64
+	var gender, phone, avatar, bio sql.NullString
+	var openTime, nextAt sql.NullTime
+	var langs, specs []string
+	if err := row.Scan(
+		&user.ID,
+		&user.Username,
+		&user.UserRole,
+		&user.Email,
+		&phone,
+		&gender,
+		&avatar,
+		&user.Verified,
+		&user.CreatedAt,
+		&user.UpdatedAt,
+		&price,
+		&user.Category,
+		&openTime,
+		&rating,
+		&bio,
+		pq.Array(&langs),
+		pq.Array(&specs),
+		&nextAt,
+	); err != nil {
+		return err
+	}
+	user.PhoneNumber = phone.String
+	user.Gender = gender.String
+	user.Avatar = avatar.String
+	user.Price = price.Float64
+	user.Rating = rating.Float64
+	user.Bio = bio.String
+	user.Languages = langs
+	user.Specializations = specs
+	if openTime.Valid {
+		user.OpenTime = openTime.Time.Format("15:04:05")
+	}
+	if nextAt.Valid {
+		t := nextAt.Time
+		user.NextAvailableAt = &t
+	}
+	return nil
+}
+
+// SearchAhli returns one page of the ahli directory matching the given filters,
+// plus the cursor for the next page (empty when there is none).
+func (q *AhliQueries) SearchAhli(p models.AhliSearchParams) ([]models.User, string, error) {
+	users := []models.User{}
+	where := []string{"u.user_role = 'ahli'"}
+	args := []interface{}{}
+	argID := 1
+
+	if p.Category != "" {
+		where = append(where, fmt.Sprintf(`(a.category = $%[1]d OR EXISTS (SELECT 1 FROM ahli_specializations sp JOIN categories c ON c.id = sp.category_id
+		WHERE sp.ahli_id = a.uid AND c.slug = $%[1]d))`, argID))
+		args = append(args, p.Category)
+		argID++
+	}
+	if p.MinPrice != nil {
+		where = append(where, fmt.Sprintf("a.price >= $%d", argID))
+		args = append(args, *p.MinPrice)
+		argID++
+	}
+	if p.MaxPrice != nil {
+		where = append(where, fmt.Sprintf("a.price <= $%d", argID))
+		args = append(args, *p.MaxPrice)
+		argID++
+	}
+	if p.MinRating != nil {
+		where = append(where, fmt.Sprintf("a.rating >= $%d", argID))
+		args = append(args, *p.MinRating)
+		argID++
+	}
+	if p.Gender != "" {
+		where = append(where, fmt.Sprintf("u.gender = $%d", argID))
+		args = append(args, p.Gender)
+		argID++
+	}
+	if p.Language != "" {
+		where = append(where, fmt.Sprintf("a.languages @> ARRAY[$%d]::text[]", argID))
+		args = append(args, p.Language)
+		argID++
+	}
+	if p.AvailableOn != nil {
+		where = append(where, fmt.Sprintf(`(EXISTS (SELECT 1 FROM ahli_availability x WHERE x.ahli_id = a.uid AND x.day_of_week = $%d)
+		OR (a.open_time IS NOT NULL AND NOT EXISTS (SELECT 1 FROM ahli_availability y WHERE y.ahli_id = a.uid)))`, argID))
+		args = append(args, *p.AvailableOn)
+		argID++
+	}
+
+	var sortExpr, order, cast string
+	switch p.Sort {
+	case AhliSortPrice:
+		sortExpr, order, cast = "COALESCE(d.price, 0)", "ASC", "numeric"
+	case AhliSortSoonest:
+		sortExpr, order, cast = "COALESCE(d.next_available_at, 'infinity'::timestamptz)", "ASC", "timestamptz"
+	default:
+		sortExpr, order, cast = "COALESCE(d.rating, 0)", "DESC", "numeric"
+	}
+
+	outer := []string{}
+	if p.Cursor != "" {
+		cur, err := utils.DecodeCursor(p.Cursor)
+		if err != nil {
+			return users, "", err
+		}
+		value, id, err := ahliCursorArgs(p.Sort, cur)
+		if err != nil {
+			return users, "", err
+		}
+		cmp := ">"
+		if order == "DESC" {
+			cmp = "<"
+		}
+		outer = append(outer, fmt.Sprintf("(%s %s $%d::%s OR (%s = $%d::%s AND d.uid > $%d::uuid))",
+			sortExpr, cmp, argID, cast, sortExpr, argID, cast, argID+1))
+		args = append(args, value, id)
+		argID += 2
+	}
+
+	query := fmt.Sprintf(`SELECT * FROM (%s WHERE %s) d`, ahliDirectoryQuery, strings.Join(where, " AND "))
+	if len(outer) > 0 {
+		query += " WHERE " + strings.Join(outer, " AND ")
+	}
+	query += fmt.Sprintf(" ORDER BY %s %s, d.uid ASC LIMIT $%d", sortExpr, order, argID)
+	args = append(args, p.Limit+1)
+
+	rows, err := q.DB.Query(query, args...)
+	if err != nil {
+		println(err.Error())
+		return users, "", errors.New("unable to search ahli, DB error")
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var user models.User
+		if err := scanAhliRow(rows, &user); err != nil {
+			return users, "", errors.New("error scanning ahli user row")
+		}
+		users = append(users, user)
+	}
+	if err := rows.Err(); err != nil {
+		return users, "", errors.New("error iterating ahli user rows")
+	}
+
+	next := ""
+	if len(users) > p.Limit {
+		users = users[:p.Limit]
+		last := users[len(users)-1]
+		var value string
+		switch p.Sort {
+		case AhliSortPrice:
+			value = strconv.FormatFloat(last.Price, 'f', -1, 64)
+		case AhliSortSoonest:
+			value = "infinity"
+			if last.NextAvailableAt != nil {
+				value = last.NextAvailableAt.Format(time.RFC3339Nano)
+			}
+		default:
+			value = strconv.FormatFloat(last.Rating, 'f', -1, 64)
+		}
+		next = utils.EncodeCursor(value, last.ID.String())
+	}
+
+	return users, next, nil
+}
+
+// ahliCursorArgs parses a directory cursor into the sort value and id it points after, so a
+// tampered cursor is rejected instead of failing the query's casts
+func ahliCursorArgs(sort string, cur utils.Cursor) (interface{}, uuid.UUID, error) {
+	id, err := uuid.Parse(cur.ID)
+	if err != nil {
+		return nil, id, errors.New("invalid cursor")
+	}
+	if sort == AhliSortSoonest {
+		if cur.Value == "infinity" {
+			return cur.Value, id, nil
+		}
+		t, err := time.Parse(time.RFC3339Nano, cur.Value)
+		if err != nil {
+			return nil, id, errors.New("invalid cursor")
+		}
+		return t, id, nil
+	}
+	v, err := strconv.ParseFloat(cur.Value, 64)
+	if err != nil || math.IsNaN(v) || math.IsInf(v, 0) {
+		return nil, id, errors.New("invalid cursor")
+	}
+	return v, id, nil
+}
+
+// GetEarningsByPeriod sums paid transactions of an ahli, net of refunds, per day, week or month within [from, to)
+func (q *AhliQueries) GetEarningsByPeriod(ahliID uuid.UUID, period string, from, to time.Time) ([]models.EarningsPeriod, error) {
+	res := []models.EarningsPeriod{}
+	query := `SELECT date_trunc($2, created_at) AS period, count(*), COALESCE(sum(amount - refunded_amount), 0)
+	FROM transactions
+	WHERE ahli_id = $1 AND status IN ('completed', 'partially_refunded') AND created_at >= $3 AND created_at < $4
+	GROUP BY period ORDER BY period DESC`
+	rows, err := q.DB.Query(query, ahliID, period, from, to)
+	if err != nil {
+		return res, errors.New("unable to query earnings")
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var e models.EarningsPeriod
+		if err := rows.Scan(&e.Period, &e.Count, &e.Total); err != nil {
+			return res, err
+		}
+		res = append(res, e)
+	}
+	return res, rows.Err()
+}
+
+// GetClients lists the users that have a room with the ahli, most recently active first
+func (q *AhliQueries) GetClients(ahliID uuid.UUID, limit int) ([]models.AhliClient, error) {
+	res := []models.AhliClient{}
+	query := `SELECT DISTINCT ON (u.uid) u.uid, u.username, COALESCE(u.avatar::text, ''), r.id, m.created_at
+	FROM rooms r
+	JOIN users u ON u.uid = CASE WHEN r.owner_id = $1 THEN r.target_id ELSE r.owner_id END
+	LEFT JOIN LATERAL (
+	  SELECT created_at FROM messages WHERE room_id = r.id ORDER BY created_at DESC LIMIT 1
+	) m ON true
+	WHERE (r.owner_id = $1 OR r.target_id = $1) AND u.uid <> $1
+	ORDER BY u.uid, m.created_at DESC NULLS LAST, r.created_at DESC`
+	rows, err := q.DB.Query(`SELECT * FROM (`+query+`) c ORDER BY c.created_at DESC NULLS LAST LIMIT $2`, ahliID, limit)
+	if err != nil {
+		return res, errors.New("unable to query clients")
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var cl models.AhliClient
+		var last sql.NullTime
+		if err := rows.Scan(&cl.UserID, &cl.Username, &cl.Avatar, &cl.RoomID, &last); err != nil {
+			return res, err
+		}
+		if last.Valid {
+			cl.LastMessageAt = &last.Time
+		}
+		res = append(res, cl)
+	}
+	return res, rows.Err()
+}
+
+// GetAhliByID returns the directory entry of one ahli including their weekly availability
+func (q *AhliQueries) GetAhliByID(uid uuid.UUID) (models.User, error) {
+	user := models.User{}
+	query := ahliDirectoryQuery + ` WHERE u.uid = $1`
+	if err := scanAhliRow(q.DB.QueryRow(query, uid), &user); err != nil {
+		if err == sql.ErrNoRows {
+			return user, errors.New("ahli not found")
+		}
+		return user, errors.New("unable to get ahli, DB error")
+	}
+
+	rows, err := q.DB.Query(`SELECT day_of_week, start_time, end_time FROM ahli_availability WHERE ahli_id = $1 ORDER BY day_of_week, start_time`, uid)
+	if err != nil {
+		return user, errors.New("unable to get ahli availability")
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var a models.AhliAvailability
+		var start, end time.Time
+		if err := rows.Scan(&a.DayOfWeek, &start, &end); err != nil {
+			return user, err
+		}
+		a.StartTime = start.Format("15:04")
+		a.EndTime = end.Format("15:04")
+		user.Availability = append(user.Availability, a)
+	}
+	return user, rows.Err()
+}
+
+// GetAhliPrice returns the current session price of an ahli
+func (q *AhliQueries) GetAhliPrice(uid uuid.UUID) (float64, error) {
+	var price sql.NullFloat64
+	if err := q.DB.QueryRow(`SELECT price FROM ahli WHERE uid = $1`, uid).Scan(&price); err != nil {
+		if err == sql.ErrNoRows {
+			return 0, errors.New("ahli not found")
+		}
+		return 0, errors.New("unable to get ahli price")
+	}
+	return price.Float64, nil
+}
+
+// UpdateAhliProfile applies the non-nil fields of req in one DB transaction. A price change
+// is appended to ahli_price_history; existing bookings keep the price they were made at.
+func (q *AhliQueries) UpdateAhliProfile(uid uuid.UUID, req *models.UpdateAhliProfileRequest) error {
+	tx, err := q.DB.Begin()
+	if err != nil {
+		return errors.New("unable to start transaction")
+	}
+	defer tx.Rollback()
+
+	var oldPrice sql.NullFloat64
+	var category string
+	if err := tx.QueryRow(`SELECT price, category FROM ahli WHERE uid = $1 FOR UPDATE`, uid).Scan(&oldPrice, &category); err != nil {
+		if err == sql.ErrNoRows {
+			return errors.New("ahli not found")
+		}
+		return errors.New("unable to get ahli, DB error")
+	}
+
+	setClauses := []string{}
+	args := []interface{}{}
+	argID := 1
+
+	if req.Price != nil {
+		setClauses = append(setClauses, fmt.Sprintf("price = $%d", argID))
+		args = append(args, *req.Price)
+		argID++
+	}
+	if req.Category != nil {
+		setClauses = append(setClauses, fmt.Sprintf("category = $%d", argID))
+		args = append(args, *req.Category)
+		argID++
+	}
+	if req.OpenTime != nil {
+		var openTime interface{}
+		if *req.OpenTime != "" {
+			openTime = *req.OpenTime
+		}
+		setClauses = append(setClauses, fmt.Sprintf("open_time = $%d", argID))
+		args = append(args, openTime)
+		argID++
+	}
+	if req.Bio != nil {
+		setClauses = append(setClauses, fmt.Sprintf("bio = $%d", argID))
+		args = append(args, *req.Bio)
+		argID++
+	}
+	if req.Languages != nil {
+		setClauses = append(setClauses, fmt.Sprintf("languages = $%d", argID))
+		args = append(args, pq.Array(*req.Languages))
+		argID++
+	}
+
+	if len(setClauses) > 0 {
+		setClauses = append(setClauses, "updated_at = now()")
+		query := fmt.Sprintf(`UPDATE ahli SET %s WHERE uid = $%d`, strings.Join(setClauses, ", "), argID)
+		args = append(args, uid)
+		if _, err := tx.Exec(query, args...); err != nil {
+			println(err.Error())
+			return errors.New("unable to update ahli, DB error")
+		}
+	}
+
+	if req.Price != nil && (!oldPrice.Valid || oldPrice.Float64 != *req.Price) {
+		if _, err := tx.Exec(`INSERT INTO ahli_price_history (ahli_id, old_price, new_price, changed_by) VALUES ($1, $2, $3, $1)`,
+			uid, oldPrice, *req.Price); err != nil {
+			return errors.New("unable to record price change, DB error")
+		}
+	}
+
+	if req.Specializations != nil || req.Category != nil {
+		primary := category
+		if req.Category != nil {
+			primary = *req.Category
+		}
+		specs := []string{primary}
+		if req.Specializations != nil {
+			specs = append(specs, *req.Specializations...)
+			if _, err := tx.Exec(`DELETE FROM ahli_specializations WHERE ahli_id = $1`, uid); err != nil {
+				return errors.New("unable to update ahli specializations, DB error")
+			}
+		} else if primary != category {
+			// the previous primary category is no longer one the ahli is listed under
+			if _, err := tx.Exec(`DELETE FROM ahli_specializations sp USING categories c
+				WHERE sp.ahli_id = $1 AND c.id = sp.category_id AND c.slug = $2`, uid, category); err != nil {
+				return errors.New("unable to update ahli specializations, DB error")
+			}
+		}
+		if _, err := tx.Exec(`INSERT INTO ahli_specializations (ahli_id, category_id)
+			SELECT $1, id FROM categories WHERE slug = ANY($2) ON CONFLICT DO NOTHING`, uid, pq.Array(specs)); err != nil {
+			return errors.New("unable to update ahli specializations, DB error")
+		}
+	}
+
+	if req.Availability != nil {
+		if _, err := tx.Exec(`DELETE FROM ahli_availability WHERE ahli_id = $1`, uid); err != nil {
+			return errors.New("unable to update ahli availability, DB error")
+		}
+		for _, a := range *req.Availability {
+			if _, err := tx.Exec(`INSERT INTO ahli_availability (ahli_id, day_of_week, start_time, end_time) VALUES ($1, $2, $3, $4)`,
+				uid, a.DayOfWeek, a.StartTime, a.EndTime); err != nil {
+				return errors.New("unable to update ahli availability, DB error")
+			}
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return errors.New("unable to commit transaction")
+	}
+	return nil
+}
+
+func (q *AhliQueries) GetPriceHistory(uid uuid.UUID, limit int) ([]models.AhliPriceChange, error) {
+	res := []models.AhliPriceChange{}
+	query := `SELECT id, ahli_id, old_price, new_price, changed_by, changed_at FROM ahli_price_history WHERE ahli_id = $1 ORDER BY changed_at DESC LIMIT $2`
+	rows, err := q.DB.Query(query, uid, limit)
+	if err != nil {
+		return res, errors.New("unable to query price history")
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var h models.AhliPriceChange
+		if err := rows.Scan(&h.ID, &h.AhliID, &h.OldPrice, &h.NewPrice, &h.ChangedBy, &h.ChangedAt); err != nil {
+			return res, err
+		}
+		res = append(res, h)
+	}
+	return res, rows.Err()
+}
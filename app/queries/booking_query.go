@@ -0,0 +1,141 @@
+package queries
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/gilanghuda/sobi-backend/app/models"
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+)
+
+type BookingQueries struct {
+	DB *sql.DB
+}
+
+const bookingColumns = `b.id, b.user_id, b.ahli_id, b.transaction_id, b.subscription_id, b.start_at, b.end_at, b.price, b.status, b.created_at, b.updated_at`
+
+func scanBooking(row interface{ Scan(...interface{}) error }, b *models.Booking, extra ...interface{}) error {
+	var txID, subID uuid.NullUUID
+	dest := []interface{}{&b.ID, &b.UserID, &b.AhliID, &txID, &subID, &b.StartAt, &b.EndAt, &b.Price, &b.Status, &b.CreatedAt, &b.UpdatedAt}
+	if err := row.Scan(append(dest, extra...)...); err != nil {
+		return err
+	}
+	if txID.Valid {
+		b.TransactionID = &txID.UUID
+	}
+	if subID.Valid {
+		b.SubscriptionID = &subID.UUID
+	}
+	return nil
+}
+
+const insertBooking = `INSERT INTO bookings (id, user_id, ahli_id, transaction_id, subscription_id, start_at, end_at, price, status, created_at, updated_at) VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9,$10,$11)`
+
+func (q *BookingQueries) CreateBooking(b *models.Booking) error {
+	_, err := q.DB.Exec(insertBooking, b.ID, b.UserID, b.AhliID, b.TransactionID, b.SubscriptionID, b.StartAt, b.EndAt, b.Price, b.Status, b.CreatedAt, b.UpdatedAt)
+	return bookingInsertError(err)
+}
+
+// createBooking inserts a booking as part of a larger database transaction
+func createBooking(tx *sql.Tx, b *models.Booking) error {
+	_, err := tx.Exec(insertBooking, b.ID, b.UserID, b.AhliID, b.TransactionID, b.SubscriptionID, b.StartAt, b.EndAt, b.Price, b.Status, b.CreatedAt, b.UpdatedAt)
+	return bookingInsertError(err)
+}
+
+// bookingInsertError maps a violation of bookings_no_overlap to the error shown for a taken slot
+func bookingInsertError(err error) error {
+	if err == nil {
+		return nil
+	}
+	if pqErr, ok := err.(*pq.Error); ok && pqErr.Code == "23P01" {
+		return errors.New("ahli already has a session at that time")
+	}
+	return errors.New("unable to create booking")
+}
+
+// HasOverlappingBooking reports whether the ahli already has a live booking intersecting [start, end)
+func (q *BookingQueries) HasOverlappingBooking(ahliID uuid.UUID, start, end time.Time) (bool, error) {
+	var exists bool
+	query := `SELECT EXISTS (SELECT 1 FROM bookings WHERE ahli_id = $1 AND status IN ('pending', 'confirmed') AND start_at < $3 AND end_at > $2)`
+	if err := q.DB.QueryRow(query, ahliID, start, end).Scan(&exists); err != nil {
+		return false, errors.New("unable to check bookings")
+	}
+	return exists, nil
+}
+
+func (q *BookingQueries) GetBookingByTransaction(txID uuid.UUID) (models.Booking, error) {
+	b := models.Booking{}
+	query := `SELECT ` + bookingColumns + ` FROM bookings b WHERE b.transaction_id = $1`
+	if err := scanBooking(q.DB.QueryRow(query, txID), &b); err != nil {
+		if err == sql.ErrNoRows {
+			return b, errors.New("booking not found")
+		}
+		return b, errors.New("unable to get booking")
+	}
+	return b, nil
+}
+
+func (q *BookingQueries) GetBookingByID(id uuid.UUID) (models.Booking, error) {
+	b := models.Booking{}
+	query := `SELECT ` + bookingColumns + ` FROM bookings b WHERE b.id = $1`
+	if err := scanBooking(q.DB.QueryRow(query, id), &b); err != nil {
+		if err == sql.ErrNoRows {
+			return b, errors.New("booking not found")
+		}
+		return b, errors.New("unable to get booking")
+	}
+	return b, nil
+}
+
+// CancelBooking cancels a pending or confirmed booking; it reports false if the booking was in another state
+func (q *BookingQueries) CancelBooking(id uuid.UUID) (bool, error) {
+	query := `UPDATE bookings SET status = 'cancelled', updated_at = now() WHERE id = $1 AND status IN ('pending', 'confirmed')`
+	res, err := q.DB.Exec(query, id)
+	if err != nil {
+		return false, errors.New("unable to cancel booking")
+	}
+	rows, err := res.RowsAffected()
+	if err != nil {
+		return false, errors.New("unable to cancel booking")
+	}
+	return rows > 0, nil
+}
+
+// UpdateBookingStatusByTransaction moves the booking paid by a transaction to status.
+// Cancelled bookings are final and are left untouched.
+func (q *BookingQueries) UpdateBookingStatusByTransaction(txID uuid.UUID, status string) error {
+	query := `UPDATE bookings SET status = $2, updated_at = now() WHERE transaction_id = $1 AND status <> 'cancelled'`
+	if _, err := q.DB.Exec(query, txID, status); err != nil {
+		return errors.New("unable to update booking")
+	}
+	return nil
+}
+
+// GetBookingsByAhli lists the bookings of an ahli with the booking user's name and avatar.
+// upcoming selects sessions that have not ended yet, otherwise sessions in the past.
+func (q *BookingQueries) GetBookingsByAhli(ahliID uuid.UUID, upcoming bool, limit int) ([]models.Booking, error) {
+	res := []models.Booking{}
+	query := `SELECT ` + bookingColumns + `, u.username, COALESCE(u.avatar::text, '')
+	FROM bookings b JOIN users u ON u.uid = b.user_id
+	WHERE b.ahli_id = $1 AND b.end_at >= now() ORDER BY b.start_at ASC LIMIT $2`
+	if !upcoming {
+		query = `SELECT ` + bookingColumns + `, u.username, COALESCE(u.avatar::text, '')
+		FROM bookings b JOIN users u ON u.uid = b.user_id
+		WHERE b.ahli_id = $1 AND b.end_at < now() ORDER BY b.start_at DESC LIMIT $2`
+	}
+	rows, err := q.DB.Query(query, ahliID, limit)
+	if err != nil {
+		return res, errors.New("unable to query bookings")
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var b models.Booking
+		if err := scanBooking(rows, &b, &b.Username, &b.Avatar); err != nil {
+			return res, err
+		}
+		res = append(res, b)
+	}
+	return res, rows.Err()
+}
@@ -0,0 +1,99 @@
+package queries
+
+import (
+	"database/sql"
+	"errors"
+
+	"github.com/gilanghuda/sobi-backend/app/models"
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+)
+
+type CategoryQueries struct {
+	DB *sql.DB
+}
+
+const categoryColumns = `id, slug, name, description, scopes, active, created_at, updated_at`
+
+func scanCategory(row interface{ Scan(...interface{}) error }, c *models.Category) error {
+	return row.Scan(&c.ID, &c.Slug, &c.Name, &c.Description, pq.Array(&c.Scopes), &c.Active, &c.CreatedAt, &c.UpdatedAt)
+}
+
+// GetCategories lists categories ordered by name; scope and inactive filtering are optional
+func (q *CategoryQueries) GetCategories(scope string, includeInactive bool) ([]models.Category, error) {
+	res := []models.Category{}
+	query := `SELECT ` + categoryColumns + ` FROM categories
+	WHERE ($1 = '' OR $1 = ANY(scopes)) AND ($2 OR active) ORDER BY name`
+	rows, err := q.DB.Query(query, scope, includeInactive)
+	if err != nil {
+		return res, errors.New("unable to query categories")
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var c models.Category
+		if err := scanCategory(rows, &c); err != nil {
+			return res, err
+		}
+		res = append(res, c)
+	}
+	return res, rows.Err()
+}
+
+func (q *CategoryQueries) GetCategoryByID(id uuid.UUID) (models.Category, error) {
+	c := models.Category{}
+	query := `SELECT ` + categoryColumns + ` FROM categories WHERE id = $1`
+	if err := scanCategory(q.DB.QueryRow(query, id), &c); err != nil {
+		if err == sql.ErrNoRows {
+			return c, errors.New("category not found")
+		}
+		return c, errors.New("unable to get category")
+	}
+	return c, nil
+}
+
+func (q *CategoryQueries) CreateCategory(c *models.Category) error {
+	query := `INSERT INTO categories (id, slug, name, description, scopes, active, created_at, updated_at) VALUES ($1,$2,$3,$4,$5,$6,$7,$8)`
+	_, err := q.DB.Exec(query, c.ID, c.Slug, c.Name, c.Description, pq.Array(c.Scopes), c.Active, c.CreatedAt, c.UpdatedAt)
+	if err != nil {
+		if pqErr, ok := err.(*pq.Error); ok && pqErr.Code == "23505" {
+			return errors.New("category slug already exists")
+		}
+		return errors.New("unable to create category")
+	}
+	return nil
+}
+
+// UpdateCategory saves the name, description, scopes and active flag; the slug never changes
+func (q *CategoryQueries) UpdateCategory(c *models.Category) error {
+	query := `UPDATE categories SET name = $2, description = $3, scopes = $4, active = $5, updated_at = now() WHERE id = $1`
+	res, err := q.DB.Exec(query, c.ID, c.Name, c.Description, pq.Array(c.Scopes), c.Active)
+	if err != nil {
+		return errors.New("unable to update category")
+	}
+	if rows, _ := res.RowsAffected(); rows == 0 {
+		return errors.New("category not found")
+	}
+	return nil
+}
+
+// DeactivateCategory hides a category from new use; rows already referencing it keep their value
+func (q *CategoryQueries) DeactivateCategory(id uuid.UUID) error {
+	res, err := q.DB.Exec(`UPDATE categories SET active = FALSE, updated_at = now() WHERE id = $1`, id)
+	if err != nil {
+		return errors.New("unable to delete category")
+	}
+	if rows, _ := res.RowsAffected(); rows == 0 {
+		return errors.New("category not found")
+	}
+	return nil
+}
+
+// IsValidCategory reports whether slug names an active category usable in scope
+func (q *CategoryQueries) IsValidCategory(slug, scope string) (bool, error) {
+	var ok bool
+	query := `SELECT EXISTS (SELECT 1 FROM categories WHERE slug = $1 AND active AND $2 = ANY(scopes))`
+	if err := q.DB.QueryRow(query, slug, scope).Scan(&ok); err != nil {
+		return false, errors.New("unable to validate category")
+	}
+	return ok, nil
+}
@@ -3,105 +3,861 @@ package queries
 import (
 	"database/sql"
 	"errors"
+	"fmt"
+	"html"
 	"sort"
+	"strings"
 	"time"
 
 	"github.com/gilanghuda/sobi-backend/app/models"
+	"github.com/gilanghuda/sobi-backend/pkg/utils"
 	"github.com/google/uuid"
+	"github.com/lib/pq"
 )
 
 type ChatQueries struct {
 	DB *sql.DB
 }
 
+const roomColumns = `r.id, r.owner_id, r.target_id, r.listener_id, r.category, r.visible, r.is_group, r.status, r.booking_id, r.ended_at, r.ended_by, r.end_reason, r.created_at, r.updated_at, r.last_activity_at`
+
+func scanRoom(row interface{ Scan(...interface{}) error }, r *models.Room) error {
+	var target, listener, booking, endedBy uuid.NullUUID
+	var endedAt sql.NullTime
+	var endReason sql.NullString
+	if err := row.Scan(&r.ID, &r.OwnerID, &target, &listener, &r.Category, &r.Visible, &r.IsGroup, &r.Status, &booking, &endedAt, &endedBy, &endReason, &r.CreatedAt, &r.UpdatedAt, &r.LastActivityAt); err != nil {
+		return err
+	}
+	if target.Valid {
+		r.TargetID = &target.UUID
+	}
+	if listener.Valid {
+		r.ListenerID = &listener.UUID
+	}
+	if booking.Valid {
+		r.BookingID = &booking.UUID
+	}
+	if endedAt.Valid {
+		r.EndedAt = &endedAt.Time
+	}
+	if endedBy.Valid {
+		r.EndedBy = &endedBy.UUID
+	}
+	r.EndReason = endReason.String
+	return nil
+}
+
+// CreateRoom stores a room together with its initial members: the owner and, for a direct room, the target
 func (q *ChatQueries) CreateRoom(r *models.Room) error {
-	query := `INSERT INTO rooms (id, owner_id, target_id, category, visible, created_at, updated_at) VALUES ($1,$2,$3,$4,$5,$6,$7)`
-	_, err := q.DB.Exec(query, r.ID, r.OwnerID, r.TargetID, r.Category, r.Visible, r.CreatedAt, r.UpdatedAt)
+	tx, err := q.DB.Begin()
 	if err != nil {
+		return errors.New("unable to start transaction")
+	}
+	defer tx.Rollback()
+
+	if r.Status == "" {
+		r.Status = models.RoomStatusActive
+	}
+	r.LastActivityAt = r.CreatedAt
+	query := `INSERT INTO rooms (id, owner_id, target_id, listener_id, category, visible, is_group, status, booking_id, created_at, updated_at, last_activity_at) VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9,$10,$11,$10)`
+	if _, err := tx.Exec(query, r.ID, r.OwnerID, r.TargetID, r.ListenerID, r.Category, r.Visible, r.IsGroup, r.Status, r.BookingID, r.CreatedAt, r.UpdatedAt); err != nil {
 		return errors.New("unable to create room")
 	}
+	member := `INSERT INTO room_members (room_id, user_id, role, invited_by, joined_at) VALUES ($1,$2,$3,$4,$5) ON CONFLICT DO NOTHING`
+	if _, err := tx.Exec(member, r.ID, r.OwnerID, models.RoomRoleOwner, nil, r.CreatedAt); err != nil {
+		return errors.New("unable to create room")
+	}
+	if r.TargetID != nil {
+		if _, err := tx.Exec(member, r.ID, *r.TargetID, models.RoomRoleMember, r.OwnerID, r.CreatedAt); err != nil {
+			return errors.New("unable to create room")
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return errors.New("unable to commit transaction")
+	}
 	return nil
 }
 
-func (q *ChatQueries) GetRoomsByUser(userID uuid.UUID) ([]models.Room, error) {
-	var res []models.Room
-	query := `SELECT id, owner_id, target_id, category, visible, created_at, updated_at FROM rooms WHERE owner_id = $1 OR target_id = $1`
-	rows, err := q.DB.Query(query, userID)
+// GetRoomsByUser lists the rooms the user is a member of with the given status;
+// an empty status lists every room that is not archived
+func (q *ChatQueries) GetRoomsByUser(userID uuid.UUID, status string) ([]models.Room, error) {
+	res := []models.Room{}
+	args := []interface{}{userID}
+	where := " AND r.status <> 'archived'"
+	if status != "" {
+		args = append(args, status)
+		where = " AND r.status = $2"
+	}
+	query := `SELECT ` + roomColumns + ` FROM rooms r JOIN room_members rm ON rm.room_id = r.id WHERE rm.user_id = $1` + where + ` ORDER BY r.created_at DESC`
+	rows, err := q.DB.Query(query, args...)
 	if err != nil {
 		return res, errors.New("unable to query rooms")
 	}
 	defer rows.Close()
 	for rows.Next() {
 		var r models.Room
-		var target sql.NullString
-		if err := rows.Scan(&r.ID, &r.OwnerID, &target, &r.Category, &r.Visible, &r.CreatedAt, &r.UpdatedAt); err != nil {
+		if err := scanRoom(rows, &r); err != nil {
 			return res, err
 		}
-		if target.Valid {
-			uid, err := uuid.Parse(target.String)
-			if err == nil {
-				r.TargetID = &uid
-			}
+		res = append(res, r)
+	}
+	return res, rows.Err()
+}
+
+// EndRoom closes an active room. endedBy is nil when the room was closed automatically.
+// It reports false when the room was not active.
+func (q *ChatQueries) EndRoom(roomID uuid.UUID, endedBy *uuid.UUID, reason string) (models.Room, bool, error) {
+	r := models.Room{}
+	query := `UPDATE rooms r SET status = 'ended', ended_at = now(), ended_by = $2, end_reason = $3, updated_at = now()
+	WHERE r.id = $1 AND r.status = 'active' RETURNING ` + roomColumns
+	if err := scanRoom(q.DB.QueryRow(query, roomID, endedBy, reason), &r); err != nil {
+		if err == sql.ErrNoRows {
+			return r, false, nil
+		}
+		return r, false, errors.New("unable to end room")
+	}
+	return r, true, nil
+}
+
+// ArchiveRoom archives an ended room; it reports false when the room was not ended
+func (q *ChatQueries) ArchiveRoom(roomID uuid.UUID) (bool, error) {
+	res, err := q.DB.Exec(`UPDATE rooms SET status = 'archived', updated_at = now() WHERE id = $1 AND status = 'ended'`, roomID)
+	if err != nil {
+		return false, errors.New("unable to archive room")
+	}
+	n, _ := res.RowsAffected()
+	return n > 0, nil
+}
+
+// EndBookedRooms closes active rooms whose booking is over or was cancelled and returns them
+func (q *ChatQueries) EndBookedRooms(now time.Time) ([]models.Room, error) {
+	query := `UPDATE rooms r SET status = 'ended', ended_at = $1, updated_at = $1,
+		end_reason = CASE WHEN b.status = 'cancelled' THEN '` + models.RoomEndReasonBookingCancelled + `' ELSE '` + models.RoomEndReasonBookingElapsed + `' END
+	FROM bookings b
+	WHERE b.id = r.booking_id AND r.status = 'active' AND (b.end_at <= $1 OR b.status = 'cancelled')
+	RETURNING ` + roomColumns
+	return q.queryRooms(query, now)
+}
+
+// EndIdleRooms closes active direct rooms without a booking that had no activity since idleSince
+// and returns them. Group rooms are long-lived and never time out.
+func (q *ChatQueries) EndIdleRooms(idleSince time.Time) ([]models.Room, error) {
+	query := `UPDATE rooms r SET status = 'ended', ended_at = now(), updated_at = now(), end_reason = '` + models.RoomEndReasonIdle + `'
+	WHERE r.status = 'active' AND NOT r.is_group AND r.booking_id IS NULL AND r.last_activity_at < $1
+	RETURNING ` + roomColumns
+	return q.queryRooms(query, idleSince)
+}
+
+// TouchRoom records activity in an active room that did not go through CreateMessage, such as a
+// message routed over websocket only. The write is skipped while the recorded activity is recent.
+func (q *ChatQueries) TouchRoom(id uuid.UUID) error {
+	query := `UPDATE rooms SET last_activity_at = now() WHERE id = $1 AND status = 'active' AND last_activity_at < now() - interval '1 minute'`
+	if _, err := q.DB.Exec(query, id); err != nil {
+		return errors.New("unable to update room activity")
+	}
+	return nil
+}
+
+func (q *ChatQueries) queryRooms(query string, args ...interface{}) ([]models.Room, error) {
+	res := []models.Room{}
+	rows, err := q.DB.Query(query, args...)
+	if err != nil {
+		return res, errors.New("unable to update rooms")
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var r models.Room
+		if err := scanRoom(rows, &r); err != nil {
+			return res, err
 		}
 		res = append(res, r)
 	}
-	return res, nil
+	return res, rows.Err()
 }
 
 func (q *ChatQueries) GetRoomByID(id uuid.UUID) (models.Room, error) {
 	r := models.Room{}
-	query := `SELECT id, owner_id, target_id, category, visible, created_at, updated_at FROM rooms WHERE id = $1`
-	var target sql.NullString
-	if err := q.DB.QueryRow(query, id).Scan(&r.ID, &r.OwnerID, &target, &r.Category, &r.Visible, &r.CreatedAt, &r.UpdatedAt); err != nil {
+	query := `SELECT ` + roomColumns + ` FROM rooms r WHERE r.id = $1`
+	if err := scanRoom(q.DB.QueryRow(query, id), &r); err != nil {
 		if err == sql.ErrNoRows {
 			return r, errors.New("room not found")
 		}
 		return r, errors.New("unable to get room")
 	}
-	if target.Valid {
-		uid, err := uuid.Parse(target.String)
-		if err == nil {
-			r.TargetID = &uid
+	return r, nil
+}
+
+// IsRoomMember reports whether the user is a member of the room
+func (q *ChatQueries) IsRoomMember(roomID, userID uuid.UUID) (bool, error) {
+	role, err := q.GetRoomMemberRole(roomID, userID)
+	return role != "", err
+}
+
+// GetRoomMemberRole returns the user's role in the room, or an empty string for non-members
+func (q *ChatQueries) GetRoomMemberRole(roomID, userID uuid.UUID) (string, error) {
+	var role string
+	query := `SELECT COALESCE((SELECT role FROM room_members WHERE room_id = r.id AND user_id = $2), '') FROM rooms r WHERE r.id = $1`
+	if err := q.DB.QueryRow(query, roomID, userID).Scan(&role); err != nil {
+		if err == sql.ErrNoRows {
+			return "", errors.New("room not found")
 		}
+		return "", errors.New("unable to check room membership")
 	}
-	return r, nil
+	return role, nil
+}
+
+// GetRoomMembers lists the members of a room, owner first
+func (q *ChatQueries) GetRoomMembers(roomID uuid.UUID) ([]models.RoomMember, error) {
+	res := []models.RoomMember{}
+	query := `SELECT rm.room_id, rm.user_id, COALESCE(u.username, ''), rm.role, rm.invited_by, rm.joined_at, rm.last_read_message_id, rm.last_read_at
+	FROM room_members rm LEFT JOIN users u ON u.uid = rm.user_id
+	WHERE rm.room_id = $1
+	ORDER BY CASE rm.role WHEN 'owner' THEN 0 WHEN 'moderator' THEN 1 ELSE 2 END, rm.joined_at`
+	rows, err := q.DB.Query(query, roomID)
+	if err != nil {
+		return res, errors.New("unable to query room members")
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var m models.RoomMember
+		var invitedBy, lastRead uuid.NullUUID
+		var lastReadAt sql.NullTime
+		if err := rows.Scan(&m.RoomID, &m.UserID, &m.Username, &m.Role, &invitedBy, &m.JoinedAt, &lastRead, &lastReadAt); err != nil {
+			return res, err
+		}
+		if invitedBy.Valid {
+			m.InvitedBy = &invitedBy.UUID
+		}
+		if lastRead.Valid {
+			m.LastReadMessageID = &lastRead.UUID
+		}
+		if lastReadAt.Valid {
+			m.LastReadAt = &lastReadAt.Time
+		}
+		res = append(res, m)
+	}
+	return res, rows.Err()
+}
+
+// GetRoomMemberIDs returns the user ids of a room's members
+func (q *ChatQueries) GetRoomMemberIDs(roomID uuid.UUID) ([]uuid.UUID, error) {
+	res := []uuid.UUID{}
+	rows, err := q.DB.Query(`SELECT user_id FROM room_members WHERE room_id = $1`, roomID)
+	if err != nil {
+		return res, errors.New("unable to query room members")
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var id uuid.UUID
+		if err := rows.Scan(&id); err != nil {
+			return res, err
+		}
+		res = append(res, id)
+	}
+	return res, rows.Err()
+}
+
+// GetContactIDs returns the users that share at least one room with the user
+func (q *ChatQueries) GetContactIDs(userID uuid.UUID) ([]uuid.UUID, error) {
+	res := []uuid.UUID{}
+	query := `SELECT DISTINCT other.user_id FROM room_members rm
+	JOIN room_members other ON other.room_id = rm.room_id AND other.user_id <> rm.user_id
+	WHERE rm.user_id = $1`
+	rows, err := q.DB.Query(query, userID)
+	if err != nil {
+		return res, errors.New("unable to query contacts")
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var id uuid.UUID
+		if err := rows.Scan(&id); err != nil {
+			return res, err
+		}
+		res = append(res, id)
+	}
+	return res, rows.Err()
+}
+
+// AddRoomMember adds a user to a room
+func (q *ChatQueries) AddRoomMember(m *models.RoomMember) error {
+	query := `INSERT INTO room_members (room_id, user_id, role, invited_by, joined_at) VALUES ($1,$2,$3,$4,$5)`
+	if _, err := q.DB.Exec(query, m.RoomID, m.UserID, m.Role, m.InvitedBy, m.JoinedAt); err != nil {
+		if pqErr, ok := err.(*pq.Error); ok && pqErr.Code == "23505" {
+			return errors.New("user is already a member of this room")
+		}
+		if pqErr, ok := err.(*pq.Error); ok && pqErr.Code == "23503" {
+			return errors.New("user not found")
+		}
+		return errors.New("unable to add room member")
+	}
+	return nil
+}
+
+// RemoveRoomMember removes a user from a room
+func (q *ChatQueries) RemoveRoomMember(roomID, userID uuid.UUID) error {
+	res, err := q.DB.Exec(`DELETE FROM room_members WHERE room_id = $1 AND user_id = $2`, roomID, userID)
+	if err != nil {
+		return errors.New("unable to remove room member")
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return errors.New("user is not a member of this room")
+	}
+	return nil
 }
 
+// insertMessage stores a message and records it as activity in its room
+const insertMessage = `WITH touched AS (UPDATE rooms SET last_activity_at = now() WHERE id = $2)
+	INSERT INTO messages (id, room_id, user_id, type, text, visible, created_at, reply_to_message_id) VALUES ($1,$2,$3,$4,$5,$6,$7,$8)`
+
 func (q *ChatQueries) CreateMessage(m *models.Message) error {
-	query := `INSERT INTO messages (id, room_id, user_id, text, visible, created_at) VALUES ($1,$2,$3,$4,$5,$6)`
-	_, err := q.DB.Exec(query, m.ID, m.RoomID, m.UserID, m.Text, m.Visible, m.CreatedAt)
+	if m.Type == "" {
+		m.Type = models.MessageTypeText
+	}
+	_, err := q.DB.Exec(insertMessage, m.ID, m.RoomID, m.UserID, m.Type, m.Text, m.Visible, m.CreatedAt, m.ReplyToID)
 	if err != nil {
 		return errors.New("unable to create message")
 	}
 	return nil
 }
 
-func (q *ChatQueries) GetMessagesByRoom(roomID uuid.UUID, limit int) ([]models.Message, error) {
-	var res []models.Message
-	query := `SELECT id, room_id, user_id, text, visible, created_at FROM messages WHERE room_id = $1 ORDER BY created_at ASC LIMIT $2`
-	rows, err := q.DB.Query(query, roomID, limit)
+// CreateMessageWithAttachments stores a message together with the metadata of its uploaded files
+func (q *ChatQueries) CreateMessageWithAttachments(m *models.Message) error {
+	tx, err := q.DB.Begin()
+	if err != nil {
+		return errors.New("unable to start transaction")
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(insertMessage, m.ID, m.RoomID, m.UserID, m.Type, m.Text, m.Visible, m.CreatedAt, m.ReplyToID); err != nil {
+		return errors.New("unable to create message")
+	}
+	query := `INSERT INTO message_attachments (id, message_id, storage_key, thumbnail_key, file_name, mime_type, size_bytes, width, height, created_at)
+	VALUES ($1,$2,$3,NULLIF($4, ''),$5,$6,$7,$8,$9,$10)`
+	for _, a := range m.Attachments {
+		if _, err := tx.Exec(query, a.ID, m.ID, a.StorageKey, a.ThumbnailKey, a.FileName, a.MimeType, a.Size, a.Width, a.Height, a.CreatedAt); err != nil {
+			return errors.New("unable to create attachment")
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return errors.New("unable to commit transaction")
+	}
+	return nil
+}
+
+const attachmentColumns = `a.id, a.message_id, a.storage_key, COALESCE(a.thumbnail_key, ''), a.file_name, a.mime_type, a.size_bytes, a.width, a.height, a.created_at`
+
+func scanAttachment(row interface{ Scan(...interface{}) error }, a *models.Attachment, extra ...interface{}) error {
+	var width, height sql.NullInt64
+	dest := []interface{}{&a.ID, &a.MessageID, &a.StorageKey, &a.ThumbnailKey, &a.FileName, &a.MimeType, &a.Size, &width, &height, &a.CreatedAt}
+	if err := row.Scan(append(dest, extra...)...); err != nil {
+		return err
+	}
+	if width.Valid {
+		w := int(width.Int64)
+		a.Width = &w
+	}
+	if height.Valid {
+		h := int(height.Int64)
+		a.Height = &h
+	}
+	return nil
+}
+
+// loadAttachments fills in the attachments of the given messages; deleted messages keep none
+func (q *ChatQueries) loadAttachments(msgs []models.Message) error {
+	ids := []string{}
+	index := map[uuid.UUID]int{}
+	for i, m := range msgs {
+		if m.Type != models.MessageTypeText && m.DeletedAt == nil {
+			ids = append(ids, m.ID.String())
+			index[m.ID] = i
+		}
+	}
+	if len(ids) == 0 {
+		return nil
+	}
+	rows, err := q.DB.Query(`SELECT `+attachmentColumns+` FROM message_attachments a WHERE a.message_id = ANY($1::uuid[]) ORDER BY a.created_at`, pq.Array(ids))
 	if err != nil {
-		return res, errors.New("unable to query messages")
+		return errors.New("unable to query attachments")
 	}
 	defer rows.Close()
 	for rows.Next() {
-		var m models.Message
-		if err := rows.Scan(&m.ID, &m.RoomID, &m.UserID, &m.Text, &m.Visible, &m.CreatedAt); err != nil {
+		var a models.Attachment
+		if err := scanAttachment(rows, &a); err != nil {
+			return err
+		}
+		i := index[a.MessageID]
+		msgs[i].Attachments = append(msgs[i].Attachments, a)
+	}
+	return rows.Err()
+}
+
+// searchMarkStart and searchMarkStop delimit matched words in ts_headline output. They are private
+// use characters, stripped from the message text first, so they survive HTML escaping unambiguously.
+const (
+	searchMarkStart = "\uE000"
+	searchMarkStop  = "\uE001"
+)
+
+// searchHeadlineOptions keeps snippets short: up to two fragments around the matched words
+const searchHeadlineOptions = `StartSel=` + searchMarkStart + `, StopSel=` + searchMarkStop + `, MaxWords=20, MinWords=8, MaxFragments=2, FragmentDelimiter=" ... "`
+
+var searchMarkReplacer = strings.NewReplacer(searchMarkStart, "<mark>", searchMarkStop, "</mark>")
+
+// highlightSnippet HTML-escapes a ts_headline snippet and turns its match delimiters into <mark> tags
+func highlightSnippet(s string) string {
+	return searchMarkReplacer.Replace(html.EscapeString(s))
+}
+
+// SearchMessages runs a web-style full-text search (quoted phrases, OR, -word) over the messages
+// of every room the user belongs to, best matches first. roomID, from and to narrow the search.
+func (q *ChatQueries) SearchMessages(userID uuid.UUID, text string, roomID *uuid.UUID, from, to *time.Time, limit, offset int) ([]models.MessageSearchResult, error) {
+	res := []models.MessageSearchResult{}
+	args := []interface{}{userID, text, limit, offset}
+	where := ""
+	if roomID != nil {
+		args = append(args, *roomID)
+		where += fmt.Sprintf(" AND m.room_id = $%d", len(args))
+	}
+	if from != nil {
+		args = append(args, *from)
+		where += fmt.Sprintf(" AND m.created_at >= $%d", len(args))
+	}
+	if to != nil {
+		args = append(args, *to)
+		where += fmt.Sprintf(" AND m.created_at < $%d", len(args))
+	}
+
+	query := `SELECT ` + messageColumns + `, ts_headline('chat_search', translate(m.text, '` + searchMarkStart + searchMarkStop + `', ''), tsq, '` + searchHeadlineOptions + `'), ts_rank(m.search_vector, tsq) AS rank
+	FROM messages m
+	JOIN room_members rm ON rm.room_id = m.room_id AND rm.user_id = $1
+	CROSS JOIN websearch_to_tsquery('chat_search', $2) tsq
+	WHERE m.search_vector @@ tsq AND m.deleted_at IS NULL` + where + `
+	ORDER BY rank DESC, m.created_at DESC, m.id DESC
+	LIMIT $3 OFFSET $4`
+	rows, err := q.DB.Query(query, args...)
+	if err != nil {
+		return res, errors.New("unable to search messages")
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var r models.MessageSearchResult
+		if err := scanMessage(rows, &r.Message, &r.Snippet, &r.Rank); err != nil {
+			return res, err
+		}
+		r.Snippet = highlightSnippet(r.Snippet)
+		res = append(res, r)
+	}
+	return res, rows.Err()
+}
+
+// GetMessagePreview returns the preview of a message shown above replies to it
+func (q *ChatQueries) GetMessagePreview(id uuid.UUID) (models.MessagePreview, uuid.UUID, error) {
+	p := models.MessagePreview{ID: id}
+	var roomID uuid.UUID
+	query := `SELECT user_id, type, text, deleted_at IS NOT NULL, room_id FROM messages WHERE id = $1`
+	if err := q.DB.QueryRow(query, id).Scan(&p.UserID, &p.Type, &p.Text, &p.Deleted, &roomID); err != nil {
+		if err == sql.ErrNoRows {
+			return p, roomID, errors.New("message not found")
+		}
+		return p, roomID, errors.New("unable to get message")
+	}
+	return p, roomID, nil
+}
+
+// loadReplyPreviews fills in the replied-to message of replies
+func (q *ChatQueries) loadReplyPreviews(msgs []models.Message) error {
+	ids := []string{}
+	for _, m := range msgs {
+		if m.ReplyToID != nil {
+			ids = append(ids, m.ReplyToID.String())
+		}
+	}
+	if len(ids) == 0 {
+		return nil
+	}
+	rows, err := q.DB.Query(`SELECT id, user_id, type, text, deleted_at IS NOT NULL FROM messages WHERE id = ANY($1::uuid[])`, pq.Array(ids))
+	if err != nil {
+		return errors.New("unable to query replied messages")
+	}
+	defer rows.Close()
+	previews := map[uuid.UUID]models.MessagePreview{}
+	for rows.Next() {
+		var p models.MessagePreview
+		if err := rows.Scan(&p.ID, &p.UserID, &p.Type, &p.Text, &p.Deleted); err != nil {
+			return err
+		}
+		previews[p.ID] = p
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	for i := range msgs {
+		if msgs[i].ReplyToID == nil {
+			continue
+		}
+		if p, ok := previews[*msgs[i].ReplyToID]; ok {
+			msgs[i].ReplyTo = &p
+		}
+	}
+	return nil
+}
+
+// loadReactions fills in the reactions of the given messages, grouped by emoji in order of first use
+func (q *ChatQueries) loadReactions(msgs []models.Message) error {
+	ids := make([]string, 0, len(msgs))
+	index := map[uuid.UUID]int{}
+	for i, m := range msgs {
+		ids = append(ids, m.ID.String())
+		index[m.ID] = i
+	}
+	if len(ids) == 0 {
+		return nil
+	}
+	rows, err := q.DB.Query(`SELECT message_id, emoji, user_id FROM message_reactions WHERE message_id = ANY($1::uuid[]) ORDER BY created_at`, pq.Array(ids))
+	if err != nil {
+		return errors.New("unable to query reactions")
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var messageID, userID uuid.UUID
+		var emoji string
+		if err := rows.Scan(&messageID, &emoji, &userID); err != nil {
+			return err
+		}
+		msgs[index[messageID]].Reactions = addReaction(msgs[index[messageID]].Reactions, emoji, userID)
+	}
+	return rows.Err()
+}
+
+func addReaction(reactions []models.Reaction, emoji string, userID uuid.UUID) []models.Reaction {
+	for i := range reactions {
+		if reactions[i].Emoji == emoji {
+			reactions[i].Count++
+			reactions[i].UserIDs = append(reactions[i].UserIDs, userID)
+			return reactions
+		}
+	}
+	return append(reactions, models.Reaction{Emoji: emoji, Count: 1, UserIDs: []uuid.UUID{userID}})
+}
+
+// GetReactions returns the reactions of one message
+func (q *ChatQueries) GetReactions(messageID uuid.UUID) ([]models.Reaction, error) {
+	msgs := []models.Message{{ID: messageID}}
+	if err := q.loadReactions(msgs); err != nil {
+		return nil, err
+	}
+	if msgs[0].Reactions == nil {
+		return []models.Reaction{}, nil
+	}
+	return msgs[0].Reactions, nil
+}
+
+// AddReaction records a user's emoji reaction; it reports false when the user already reacted with it
+func (q *ChatQueries) AddReaction(messageID, userID uuid.UUID, emoji string) (bool, error) {
+	res, err := q.DB.Exec(`INSERT INTO message_reactions (message_id, user_id, emoji) VALUES ($1,$2,$3) ON CONFLICT DO NOTHING`, messageID, userID, emoji)
+	if err != nil {
+		return false, errors.New("unable to add reaction")
+	}
+	n, _ := res.RowsAffected()
+	return n > 0, nil
+}
+
+// RemoveReaction deletes a user's emoji reaction; it reports false when there was none
+func (q *ChatQueries) RemoveReaction(messageID, userID uuid.UUID, emoji string) (bool, error) {
+	res, err := q.DB.Exec(`DELETE FROM message_reactions WHERE message_id = $1 AND user_id = $2 AND emoji = $3`, messageID, userID, emoji)
+	if err != nil {
+		return false, errors.New("unable to remove reaction")
+	}
+	n, _ := res.RowsAffected()
+	return n > 0, nil
+}
+
+// GetAttachment returns an attachment with the room and deletion state of its message
+func (q *ChatQueries) GetAttachment(id uuid.UUID) (models.Attachment, uuid.UUID, bool, error) {
+	a := models.Attachment{}
+	var roomID uuid.UUID
+	var deleted bool
+	query := `SELECT ` + attachmentColumns + `, m.room_id, m.deleted_at IS NOT NULL FROM message_attachments a JOIN messages m ON m.id = a.message_id WHERE a.id = $1`
+	if err := scanAttachment(q.DB.QueryRow(query, id), &a, &roomID, &deleted); err != nil {
+		if err == sql.ErrNoRows {
+			return a, roomID, false, errors.New("attachment not found")
+		}
+		return a, roomID, false, errors.New("unable to get attachment")
+	}
+	return a, roomID, deleted, nil
+}
+
+// messageColumns selects a message; the text of deleted messages is only kept in message_audits
+const messageColumns = `m.id, m.room_id, m.user_id, m.type, m.text, m.visible, m.created_at, m.edited_at, m.deleted_at, m.reply_to_message_id`
+
+func scanMessage(row interface{ Scan(...interface{}) error }, m *models.Message, extra ...interface{}) error {
+	var editedAt, deletedAt sql.NullTime
+	var replyTo uuid.NullUUID
+	dest := []interface{}{&m.ID, &m.RoomID, &m.UserID, &m.Type, &m.Text, &m.Visible, &m.CreatedAt, &editedAt, &deletedAt, &replyTo}
+	if err := row.Scan(append(dest, extra...)...); err != nil {
+		return err
+	}
+	if replyTo.Valid {
+		m.ReplyToID = &replyTo.UUID
+	}
+	if editedAt.Valid {
+		m.EditedAt = &editedAt.Time
+	}
+	if deletedAt.Valid {
+		m.DeletedAt = &deletedAt.Time
+	}
+	return nil
+}
+
+// GetMessageByID returns a single message
+func (q *ChatQueries) GetMessageByID(id uuid.UUID) (models.Message, error) {
+	m := models.Message{}
+	if err := scanMessage(q.DB.QueryRow(`SELECT `+messageColumns+` FROM messages m WHERE m.id = $1`, id), &m); err != nil {
+		if err == sql.ErrNoRows {
+			return m, errors.New("message not found")
+		}
+		return m, errors.New("unable to get message")
+	}
+	return m, nil
+}
+
+// EditMessage replaces the text of a message that is not deleted, auditing the previous text
+func (q *ChatQueries) EditMessage(m *models.Message, actorID uuid.UUID, text string) error {
+	tx, err := q.DB.Begin()
+	if err != nil {
+		return errors.New("unable to start transaction")
+	}
+	defer tx.Rollback()
+
+	var old string
+	if err := tx.QueryRow(`SELECT text FROM messages WHERE id = $1 AND deleted_at IS NULL FOR UPDATE`, m.ID).Scan(&old); err != nil {
+		if err == sql.ErrNoRows {
+			return errors.New("message not found")
+		}
+		return errors.New("unable to edit message")
+	}
+	audit := `INSERT INTO message_audits (message_id, room_id, actor_id, action, old_text, new_text) VALUES ($1,$2,$3,$4,$5,$6)`
+	if _, err := tx.Exec(audit, m.ID, m.RoomID, actorID, models.MessageAuditEdit, old, text); err != nil {
+		return errors.New("unable to audit message")
+	}
+	var editedAt time.Time
+	if err := tx.QueryRow(`UPDATE messages SET text = $2, edited_at = now() WHERE id = $1 RETURNING edited_at`, m.ID, text).Scan(&editedAt); err != nil {
+		return errors.New("unable to edit message")
+	}
+
+	if err := tx.Commit(); err != nil {
+		return errors.New("unable to commit transaction")
+	}
+	m.Text = text
+	m.EditedAt = &editedAt
+	return nil
+}
+
+// DeleteMessage soft-deletes a message: its text moves to message_audits and the row stays as a tombstone
+func (q *ChatQueries) DeleteMessage(m *models.Message, actorID uuid.UUID) error {
+	tx, err := q.DB.Begin()
+	if err != nil {
+		return errors.New("unable to start transaction")
+	}
+	defer tx.Rollback()
+
+	var old string
+	if err := tx.QueryRow(`SELECT text FROM messages WHERE id = $1 AND deleted_at IS NULL FOR UPDATE`, m.ID).Scan(&old); err != nil {
+		if err == sql.ErrNoRows {
+			return errors.New("message not found")
+		}
+		return errors.New("unable to delete message")
+	}
+	audit := `INSERT INTO message_audits (message_id, room_id, actor_id, action, old_text) VALUES ($1,$2,$3,$4,$5)`
+	if _, err := tx.Exec(audit, m.ID, m.RoomID, actorID, models.MessageAuditDelete, old); err != nil {
+		return errors.New("unable to audit message")
+	}
+	var deletedAt time.Time
+	if err := tx.QueryRow(`UPDATE messages SET text = '', deleted_at = now() WHERE id = $1 RETURNING deleted_at`, m.ID).Scan(&deletedAt); err != nil {
+		return errors.New("unable to delete message")
+	}
+
+	if err := tx.Commit(); err != nil {
+		return errors.New("unable to commit transaction")
+	}
+	m.Text = ""
+	m.DeletedAt = &deletedAt
+	return nil
+}
+
+// GetMessageAudits returns the edit and delete history of a message, oldest first
+func (q *ChatQueries) GetMessageAudits(messageID uuid.UUID) ([]models.MessageAudit, error) {
+	res := []models.MessageAudit{}
+	query := `SELECT id, message_id, room_id, actor_id, action, old_text, new_text, created_at FROM message_audits WHERE message_id = $1 ORDER BY created_at`
+	rows, err := q.DB.Query(query, messageID)
+	if err != nil {
+		return res, errors.New("unable to query message audits")
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var a models.MessageAudit
+		var actor uuid.NullUUID
+		var newText sql.NullString
+		if err := rows.Scan(&a.ID, &a.MessageID, &a.RoomID, &actor, &a.Action, &a.OldText, &newText, &a.CreatedAt); err != nil {
 			return res, err
 		}
+		if actor.Valid {
+			a.ActorID = &actor.UUID
+		}
+		if newText.Valid {
+			a.NewText = &newText.String
+		}
+		res = append(res, a)
+	}
+	return res, rows.Err()
+}
+
+// messageCursor encodes the keyset position of a message
+func messageCursor(m models.Message) string {
+	return utils.EncodeCursor(m.CreatedAt.Format(utils.TimeCursorLayout), m.ID.String())
+}
+
+// GetMessagesByRoom returns one page of a room's messages in chronological order.
+// With after set the page starts right after that cursor; otherwise it ends right before
+// the before cursor, or at the newest message. The returned cursors point at older and
+// newer messages and are empty when there are none.
+func (q *ChatQueries) GetMessagesByRoom(roomID uuid.UUID, before, after string, limit int) ([]models.Message, string, string, error) {
+	res := []models.Message{}
+	args := []interface{}{roomID, limit + 1}
+	where, order := "", "DESC"
+	cursor := before
+	if after != "" {
+		cursor, order = after, "ASC"
+	}
+	if cursor != "" {
+		cur, err := utils.DecodeCursor(cursor)
+		if err != nil {
+			return res, "", "", err
+		}
+		if err := cur.CheckTimeID(); err != nil {
+			return res, "", "", err
+		}
+		op := "<"
+		if order == "ASC" {
+			op = ">"
+		}
+		where = " AND (m.created_at, m.id) " + op + " ($3::timestamp, $4::uuid)"
+		args = append(args, cur.Value, cur.ID)
+	}
+
+	query := `SELECT ` + messageColumns + ` FROM messages m WHERE m.room_id = $1` + where + `
+	ORDER BY m.created_at ` + order + `, m.id ` + order + ` LIMIT $2`
+	rows, err := q.DB.Query(query, args...)
+	if err != nil {
+		return res, "", "", errors.New("unable to query messages")
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var m models.Message
+		if err := scanMessage(rows, &m); err != nil {
+			return res, "", "", err
+		}
 		res = append(res, m)
 	}
-	return res, nil
+	if err := rows.Err(); err != nil {
+		return res, "", "", err
+	}
+	rows.Close()
+
+	more := len(res) > limit
+	if more {
+		res = res[:limit]
+	}
+	if order == "DESC" {
+		for i, j := 0, len(res)-1; i < j; i, j = i+1, j-1 {
+			res[i], res[j] = res[j], res[i]
+		}
+	}
+	if len(res) == 0 {
+		return res, "", "", nil
+	}
+	if err := q.loadAttachments(res); err != nil {
+		return res, "", "", err
+	}
+	if err := q.loadReplyPreviews(res); err != nil {
+		return res, "", "", err
+	}
+	if err := q.loadReactions(res); err != nil {
+		return res, "", "", err
+	}
+
+	prev, next := "", ""
+	if (order == "DESC" && more) || order == "ASC" {
+		prev = messageCursor(res[0])
+	}
+	if (order == "ASC" && more) || (order == "DESC" && before != "") {
+		next = messageCursor(res[len(res)-1])
+	}
+	return res, prev, next, nil
+}
+
+// GetMessageCursor returns the keyset cursor of a message in the room, used to sync from a last seen message
+func (q *ChatQueries) GetMessageCursor(roomID, messageID uuid.UUID) (string, error) {
+	m := models.Message{ID: messageID}
+	if err := q.DB.QueryRow(`SELECT created_at FROM messages WHERE id = $1 AND room_id = $2`, messageID, roomID).Scan(&m.CreatedAt); err != nil {
+		if err == sql.ErrNoRows {
+			return "", errors.New("message not found")
+		}
+		return "", errors.New("unable to get message")
+	}
+	return messageCursor(m), nil
+}
+
+// unreadCount counts the messages of room r sent by others after the read position of user $1
+const unreadCount = `(SELECT count(*) FROM messages x
+	  LEFT JOIN room_members rm ON rm.room_id = r.id AND rm.user_id = $1
+	  LEFT JOIN messages lr ON lr.id = rm.last_read_message_id
+	  WHERE x.room_id = r.id AND x.user_id <> $1 AND x.deleted_at IS NULL AND (lr.id IS NULL OR (x.created_at, x.id) > (lr.created_at, lr.id)))`
+
+// MarkRoomRead moves the member's read position forward to the given message, or to the newest
+// message of the room when messageID is nil. It reports false when the position did not move.
+func (q *ChatQueries) MarkRoomRead(roomID, userID uuid.UUID, messageID *uuid.UUID) (models.ReadReceipt, bool, error) {
+	rr := models.ReadReceipt{RoomID: roomID, UserID: userID}
+	var err error
+	if messageID != nil {
+		err = q.DB.QueryRow(`SELECT id FROM messages WHERE id = $1 AND room_id = $2`, *messageID, roomID).Scan(&rr.MessageID)
+	} else {
+		err = q.DB.QueryRow(`SELECT id FROM messages WHERE room_id = $1 ORDER BY created_at DESC, id DESC LIMIT 1`, roomID).Scan(&rr.MessageID)
+	}
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return rr, false, errors.New("message not found")
+		}
+		return rr, false, errors.New("unable to get message")
+	}
+
+	query := `UPDATE room_members rm SET last_read_message_id = m.id, last_read_at = now()
+	FROM messages m
+	WHERE rm.room_id = $1 AND rm.user_id = $2 AND m.id = $3
+	AND (rm.last_read_message_id IS NULL OR (m.created_at, m.id) > (SELECT created_at, id FROM messages WHERE id = rm.last_read_message_id))
+	RETURNING rm.last_read_at`
+	if err := q.DB.QueryRow(query, roomID, userID, rr.MessageID).Scan(&rr.ReadAt); err != nil {
+		if err == sql.ErrNoRows {
+			return rr, false, nil
+		}
+		return rr, false, errors.New("unable to mark room read")
+	}
+	return rr, true, nil
 }
 
 func (q *ChatQueries) GetRecentChats(userID uuid.UUID, limit int) ([]models.RecentChat, error) {
 	rows, err := q.DB.Query(`
-	SELECT r.id, r.owner_id, r.target_id, m.text, m.created_at
+	SELECT r.id, o.user_id, m.text, m.created_at, `+unreadCount+`
 	FROM rooms r
+	JOIN room_members me ON me.room_id = r.id AND me.user_id = $1
+	JOIN room_members o ON o.room_id = r.id AND o.user_id <> $1
 	JOIN LATERAL (
 	  SELECT text, created_at FROM messages WHERE room_id = r.id ORDER BY created_at DESC LIMIT 1
 	) m ON true
-	WHERE r.owner_id = $1 OR r.target_id = $1
+	WHERE NOT r.is_group
 	ORDER BY m.created_at DESC
 	LIMIT $2
 	`, userID, limit*5)
@@ -113,36 +869,17 @@ func (q *ChatQueries) GetRecentChats(userID uuid.UUID, limit int) ([]models.Rece
 	recentMap := make(map[uuid.UUID]models.RecentChat)
 	for rows.Next() {
 		var roomID uuid.UUID
-		var owner uuid.UUID
-		var target sql.NullString
+		var other uuid.UUID
 		var text sql.NullString
 		var createdAt time.Time
-		if err := rows.Scan(&roomID, &owner, &target, &text, &createdAt); err != nil {
+		var unread int
+		if err := rows.Scan(&roomID, &other, &text, &createdAt, &unread); err != nil {
 			return nil, err
 		}
-		var other uuid.UUID
-		if target.Valid {
-			uid, _ := uuid.Parse(target.String)
-			if owner == userID {
-				other = uid
-			} else {
-				other = owner
-			}
-		} else {
-			if owner == userID {
-				other = uuid.Nil
-			} else {
-				other = owner
-			}
-		}
-
-		if other == uuid.Nil || other == userID {
-			continue
-		}
 
 		rc, ok := recentMap[other]
 		if !ok || createdAt.After(rc.LastAt) {
-			recentMap[other] = models.RecentChat{OtherUserID: other, RoomID: roomID, LastMessage: text.String, LastAt: createdAt}
+			recentMap[other] = models.RecentChat{OtherUserID: other, RoomID: roomID, LastMessage: text.String, LastAt: createdAt, Unread: unread}
 		}
 	}
 
@@ -162,12 +899,14 @@ func (q *ChatQueries) GetRecentChats(userID uuid.UUID, limit int) ([]models.Rece
 
 func (q *ChatQueries) GetRecentChatsAsTarget(userID uuid.UUID, limit int) ([]models.RecentChat, error) {
 	rows, err := q.DB.Query(`
-	SELECT r.id, r.owner_id, m.text, m.created_at
+	SELECT r.id, o.user_id, m.text, m.created_at, `+unreadCount+`
 	FROM rooms r
+	JOIN room_members me ON me.room_id = r.id AND me.user_id = $1 AND me.role <> 'owner'
+	JOIN room_members o ON o.room_id = r.id AND o.role = 'owner'
 	JOIN LATERAL (
 	  SELECT text, created_at FROM messages WHERE room_id = r.id ORDER BY created_at DESC LIMIT 1
 	) m ON true
-	WHERE r.target_id = $1
+	WHERE NOT r.is_group
 	ORDER BY m.created_at DESC
 	LIMIT $2
 	`, userID, limit)
@@ -182,29 +921,25 @@ func (q *ChatQueries) GetRecentChatsAsTarget(userID uuid.UUID, limit int) ([]mod
 		var owner uuid.UUID
 		var text sql.NullString
 		var createdAt time.Time
-		if err := rows.Scan(&roomID, &owner, &text, &createdAt); err != nil {
+		var unread int
+		if err := rows.Scan(&roomID, &owner, &text, &createdAt, &unread); err != nil {
 			return nil, err
 		}
-		out = append(out, models.RecentChat{OtherUserID: owner, RoomID: roomID, LastMessage: text.String, LastAt: createdAt})
+		out = append(out, models.RecentChat{OtherUserID: owner, RoomID: roomID, LastMessage: text.String, LastAt: createdAt, Unread: unread})
 	}
 	return out, nil
 }
 
-func (q *ChatQueries) GetActiveRoom(userID uuid.UUID, startTime, endTime time.Time) (models.Room, error) {
+// GetActiveRoom returns the user's most recent active direct room
+func (q *ChatQueries) GetActiveRoom(userID uuid.UUID) (models.Room, error) {
 	r := models.Room{}
-	query := `SELECT id, owner_id, target_id, category, visible, created_at, updated_at FROM rooms WHERE (owner_id = $1 OR target_id = $1) AND created_at >= $2 AND created_at <= $3 ORDER BY created_at DESC LIMIT 1`
-	var target sql.NullString
-	if err := q.DB.QueryRow(query, userID, startTime, endTime).Scan(&r.ID, &r.OwnerID, &target, &r.Category, &r.Visible, &r.CreatedAt, &r.UpdatedAt); err != nil {
+	query := `SELECT ` + roomColumns + ` FROM rooms r JOIN room_members rm ON rm.room_id = r.id
+	WHERE rm.user_id = $1 AND r.status = 'active' AND NOT r.is_group ORDER BY r.created_at DESC LIMIT 1`
+	if err := scanRoom(q.DB.QueryRow(query, userID), &r); err != nil {
 		if err == sql.ErrNoRows {
 			return r, errors.New("no active room")
 		}
 		return r, errors.New("unable to get active room")
 	}
-	if target.Valid {
-		uid, err := uuid.Parse(target.String)
-		if err == nil {
-			r.TargetID = &uid
-		}
-	}
 	return r, nil
 }
@@ -0,0 +1,275 @@
+package queries
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/gilanghuda/sobi-backend/app/models"
+	"github.com/google/uuid"
+)
+
+type LedgerQueries struct {
+	DB *sql.DB
+}
+
+const insertLedgerEntry = `INSERT INTO ledger_entries (journal_id, entry_type, transaction_id, payout_id, ahli_id, account, debit, credit, status) VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9)`
+
+// PostTransactionPayment records the balanced journal for a completed transaction:
+// the price before discount is split into platform commission and ahli earnings. Vouchers are
+// issued by the platform, so a discount is debited from platform commission, never from the ahli.
+// Posting the same transaction twice is a no-op.
+func (q *LedgerQueries) PostTransactionPayment(t models.Transaction, commissionPercent int64) error {
+	tx, err := q.DB.Begin()
+	if err != nil {
+		return errors.New("unable to start transaction")
+	}
+	defer tx.Rollback()
+
+	var exists bool
+	if err := tx.QueryRow(`SELECT EXISTS (SELECT 1 FROM ledger_entries WHERE transaction_id = $1 AND entry_type = 'payment')`, t.ID).Scan(&exists); err != nil {
+		return errors.New("unable to check ledger")
+	}
+	if exists {
+		return nil
+	}
+
+	gross := t.Amount + t.DiscountAmount
+	commission := gross * commissionPercent / 100
+	earnings := gross - commission
+	journal := uuid.New()
+
+	legs := []struct {
+		account       string
+		debit, credit int64
+		status        string
+	}{
+		{models.AccountUserPayment, t.Amount, 0, "posted"},
+		{models.AccountPlatformCommission, 0, commission, "posted"},
+		{models.AccountAhliEarnings, 0, earnings, "pending"},
+	}
+	if t.DiscountAmount > 0 {
+		legs = append(legs, struct {
+			account       string
+			debit, credit int64
+			status        string
+		}{models.AccountPlatformCommission, t.DiscountAmount, 0, "posted"})
+	}
+	for _, l := range legs {
+		if _, err := tx.Exec(insertLedgerEntry, journal, "payment", t.ID, nil, t.AhliID, l.account, l.debit, l.credit, l.status); err != nil {
+			println(err.Error())
+			return errors.New("unable to post ledger entry")
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return errors.New("unable to commit transaction")
+	}
+	return nil
+}
+
+// PostTransactionRefund reverses the payment journal in proportion to a refund: user_payment is
+// credited back and the commission and ahli earnings are debited. The ahli share stays pending,
+// so an already paid out refund is netted against the next payout. Posting a refund twice is a no-op.
+func (q *LedgerQueries) PostTransactionRefund(t models.Transaction, refundID uuid.UUID, amount int64) error {
+	tx, err := q.DB.Begin()
+	if err != nil {
+		return errors.New("unable to start transaction")
+	}
+	defer tx.Rollback()
+
+	var exists bool
+	if err := tx.QueryRow(`SELECT EXISTS (SELECT 1 FROM ledger_entries WHERE refund_id = $1 AND entry_type = 'refund')`, refundID).Scan(&exists); err != nil {
+		return errors.New("unable to check ledger")
+	}
+	if exists {
+		return nil
+	}
+
+	var paid, earnings int64
+	query := `SELECT COALESCE(sum(debit) FILTER (WHERE account = 'user_payment'), 0), COALESCE(sum(credit) FILTER (WHERE account = 'ahli_earnings'), 0)
+	FROM ledger_entries WHERE transaction_id = $1 AND entry_type = 'payment'`
+	if err := tx.QueryRow(query, t.ID).Scan(&paid, &earnings); err != nil {
+		return errors.New("unable to read payment journal")
+	}
+	if paid == 0 {
+		return errors.New("transaction has no payment in the ledger")
+	}
+
+	// the platform keeps what the ahli does not get back; with a large voucher that share is negative
+	// and the platform recovers part of the discount it funded
+	earningsShare := earnings * amount / paid
+	commissionShare := amount - earningsShare
+	commissionDebit, commissionCredit := commissionShare, int64(0)
+	if commissionShare < 0 {
+		commissionDebit, commissionCredit = 0, -commissionShare
+	}
+	journal := uuid.New()
+
+	legs := []struct {
+		account       string
+		debit, credit int64
+		status        string
+	}{
+		{models.AccountUserPayment, 0, amount, "posted"},
+		{models.AccountPlatformCommission, commissionDebit, commissionCredit, "posted"},
+		{models.AccountAhliEarnings, earningsShare, 0, "pending"},
+	}
+	insert := `INSERT INTO ledger_entries (journal_id, entry_type, transaction_id, refund_id, ahli_id, account, debit, credit, status) VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9)`
+	for _, l := range legs {
+		if _, err := tx.Exec(insert, journal, "refund", t.ID, refundID, t.AhliID, l.account, l.debit, l.credit, l.status); err != nil {
+			return errors.New("unable to post ledger entry")
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return errors.New("unable to commit transaction")
+	}
+	return nil
+}
+
+// CreatePayoutBatch settles every pending ahli_earnings balance created before until,
+// optionally for a single ahli. Each ahli with a positive balance gets one payout and
+// the settled entries move to status 'paid'.
+func (q *LedgerQueries) CreatePayoutBatch(createdBy uuid.UUID, ahliID *uuid.UUID, until time.Time) ([]models.Payout, error) {
+	payouts := []models.Payout{}
+	tx, err := q.DB.Begin()
+	if err != nil {
+		return payouts, errors.New("unable to start transaction")
+	}
+	defer tx.Rollback()
+
+	query := `SELECT id, ahli_id, credit - debit FROM ledger_entries
+	WHERE account = 'ahli_earnings' AND status = 'pending' AND ahli_id IS NOT NULL AND created_at < $1 AND ($2::uuid IS NULL OR ahli_id = $2)
+	ORDER BY ahli_id FOR UPDATE`
+	rows, err := tx.Query(query, until, ahliID)
+	if err != nil {
+		return payouts, errors.New("unable to query pending earnings")
+	}
+
+	type balance struct {
+		amount  int64
+		entries []uuid.UUID
+	}
+	balances := map[uuid.UUID]*balance{}
+	order := []uuid.UUID{}
+	for rows.Next() {
+		var id, ahli uuid.UUID
+		var amount int64
+		if err := rows.Scan(&id, &ahli, &amount); err != nil {
+			rows.Close()
+			return payouts, err
+		}
+		b, ok := balances[ahli]
+		if !ok {
+			b = &balance{}
+			balances[ahli] = b
+			order = append(order, ahli)
+		}
+		b.amount += amount
+		b.entries = append(b.entries, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return payouts, err
+	}
+
+	batchID := uuid.New()
+	for _, ahli := range order {
+		b := balances[ahli]
+		if b.amount <= 0 {
+			continue
+		}
+		p := models.Payout{ID: uuid.New(), BatchID: batchID, AhliID: ahli, Amount: b.amount, Entries: len(b.entries), Status: "paid", CreatedBy: createdBy, CreatedAt: time.Now()}
+		if _, err := tx.Exec(`INSERT INTO payouts (id, batch_id, ahli_id, amount, entries, status, created_by, created_at) VALUES ($1,$2,$3,$4,$5,$6,$7,$8)`,
+			p.ID, p.BatchID, p.AhliID, p.Amount, p.Entries, p.Status, p.CreatedBy, p.CreatedAt); err != nil {
+			return payouts, errors.New("unable to create payout")
+		}
+
+		journal := uuid.New()
+		if _, err := tx.Exec(insertLedgerEntry, journal, "payout", nil, p.ID, ahli, models.AccountAhliEarnings, p.Amount, 0, "paid"); err != nil {
+			return payouts, errors.New("unable to post ledger entry")
+		}
+		if _, err := tx.Exec(insertLedgerEntry, journal, "payout", nil, p.ID, ahli, models.AccountAhliPayout, 0, p.Amount, "posted"); err != nil {
+			return payouts, errors.New("unable to post ledger entry")
+		}
+
+		for _, id := range b.entries {
+			if _, err := tx.Exec(`UPDATE ledger_entries SET status = 'paid', payout_id = $2 WHERE id = $1`, id, p.ID); err != nil {
+				return payouts, errors.New("unable to settle ledger entry")
+			}
+		}
+		payouts = append(payouts, p)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return []models.Payout{}, errors.New("unable to commit transaction")
+	}
+	return payouts, nil
+}
+
+func (q *LedgerQueries) GetPayouts(ahliID *uuid.UUID, limit int) ([]models.Payout, error) {
+	res := []models.Payout{}
+	query := `SELECT id, batch_id, ahli_id, amount, entries, status, created_by, created_at FROM payouts
+	WHERE ($1::uuid IS NULL OR ahli_id = $1) ORDER BY created_at DESC LIMIT $2`
+	rows, err := q.DB.Query(query, ahliID, limit)
+	if err != nil {
+		return res, errors.New("unable to query payouts")
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var p models.Payout
+		var createdBy uuid.NullUUID
+		if err := rows.Scan(&p.ID, &p.BatchID, &p.AhliID, &p.Amount, &p.Entries, &p.Status, &createdBy, &p.CreatedAt); err != nil {
+			return res, err
+		}
+		p.CreatedBy = createdBy.UUID
+		res = append(res, p)
+	}
+	return res, rows.Err()
+}
+
+// GetSettlement returns one row per transaction posted for the ahli within [from, to)
+func (q *LedgerQueries) GetSettlement(ahliID uuid.UUID, from, to time.Time) ([]models.SettlementRow, error) {
+	res := []models.SettlementRow{}
+	query := `SELECT e.transaction_id, min(e.created_at),
+	COALESCE(sum(e.debit) FILTER (WHERE e.account = 'user_payment'), 0),
+	COALESCE(sum(e.credit) FILTER (WHERE e.account = 'platform_commission'), 0),
+	COALESCE(sum(e.credit) FILTER (WHERE e.account = 'ahli_earnings'), 0),
+	COALESCE(max(e.status) FILTER (WHERE e.account = 'ahli_earnings'), ''),
+	max(e.payout_id::text) FILTER (WHERE e.account = 'ahli_earnings')
+	FROM ledger_entries e
+	WHERE e.ahli_id = $1 AND e.entry_type = 'payment' AND e.transaction_id IS NOT NULL AND e.created_at >= $2 AND e.created_at < $3
+	GROUP BY e.transaction_id ORDER BY 2`
+	rows, err := q.DB.Query(query, ahliID, from, to)
+	if err != nil {
+		return res, errors.New("unable to query settlement")
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var r models.SettlementRow
+		var payout sql.NullString
+		if err := rows.Scan(&r.TransactionID, &r.CompletedAt, &r.UserPayment, &r.Commission, &r.AhliEarnings, &r.Status, &payout); err != nil {
+			return res, err
+		}
+		if payout.Valid {
+			if pid, err := uuid.Parse(payout.String); err == nil {
+				r.PayoutID = &pid
+			}
+		}
+		res = append(res, r)
+	}
+	return res, rows.Err()
+}
+
+// GetPendingEarnings returns the number of unsettled earning entries and the net balance owed to the ahli
+func (q *LedgerQueries) GetPendingEarnings(ahliID uuid.UUID) (int, int64, error) {
+	var count int
+	var total int64
+	query := `SELECT count(*) FILTER (WHERE credit > 0), COALESCE(sum(credit - debit), 0)
+	FROM ledger_entries WHERE ahli_id = $1 AND account = 'ahli_earnings' AND status = 'pending'`
+	if err := q.DB.QueryRow(query, ahliID).Scan(&count, &total); err != nil {
+		return 0, 0, errors.New("unable to get pending payouts")
+	}
+	return count, total, nil
+}
@@ -0,0 +1,151 @@
+package queries
+
+import (
+	"database/sql"
+	"errors"
+
+	"github.com/gilanghuda/sobi-backend/app/models"
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+)
+
+type RefundQueries struct {
+	DB *sql.DB
+}
+
+const refundColumns = `r.id, r.transaction_id, r.user_id, t.ahli_id, r.requested_by, r.amount, r.reason, r.status, r.decided_by, r.decided_at, r.decision_note, r.error, r.created_at, r.updated_at`
+
+func scanRefund(row interface{ Scan(...interface{}) error }, r *models.RefundRequest) error {
+	var requestedBy, decidedBy uuid.NullUUID
+	var decidedAt sql.NullTime
+	var note, errMsg sql.NullString
+	if err := row.Scan(&r.ID, &r.TransactionID, &r.UserID, &r.AhliID, &requestedBy, &r.Amount, &r.Reason, &r.Status, &decidedBy, &decidedAt, &note, &errMsg, &r.CreatedAt, &r.UpdatedAt); err != nil {
+		return err
+	}
+	if requestedBy.Valid {
+		r.RequestedBy = &requestedBy.UUID
+	}
+	if decidedBy.Valid {
+		r.DecidedBy = &decidedBy.UUID
+	}
+	if decidedAt.Valid {
+		r.DecidedAt = &decidedAt.Time
+	}
+	if note.Valid {
+		r.DecisionNote = &note.String
+	}
+	if errMsg.Valid {
+		r.Error = &errMsg.String
+	}
+	return nil
+}
+
+func (q *RefundQueries) CreateRefundRequest(r *models.RefundRequest) error {
+	query := `INSERT INTO refund_requests (id, transaction_id, user_id, requested_by, amount, reason, status, created_at, updated_at) VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9)`
+	_, err := q.DB.Exec(query, r.ID, r.TransactionID, r.UserID, r.RequestedBy, r.Amount, r.Reason, r.Status, r.CreatedAt, r.UpdatedAt)
+	if err != nil {
+		if pqErr, ok := err.(*pq.Error); ok && pqErr.Code == "23505" {
+			return errors.New("a refund for this transaction is already open")
+		}
+		return errors.New("unable to create refund request")
+	}
+	return nil
+}
+
+func (q *RefundQueries) GetRefundRequest(id uuid.UUID) (models.RefundRequest, error) {
+	r := models.RefundRequest{}
+	query := `SELECT ` + refundColumns + ` FROM refund_requests r JOIN transactions t ON t.id = r.transaction_id WHERE r.id = $1`
+	if err := scanRefund(q.DB.QueryRow(query, id), &r); err != nil {
+		if err == sql.ErrNoRows {
+			return r, errors.New("refund request not found")
+		}
+		return r, errors.New("unable to get refund request")
+	}
+	return r, nil
+}
+
+// GetRefundRequests lists refund requests, newest first. Every filter is optional.
+func (q *RefundQueries) GetRefundRequests(status string, transactionID, ahliID *uuid.UUID, limit int) ([]models.RefundRequest, error) {
+	res := []models.RefundRequest{}
+	query := `SELECT ` + refundColumns + ` FROM refund_requests r JOIN transactions t ON t.id = r.transaction_id
+	WHERE ($1 = '' OR r.status = $1) AND ($2::uuid IS NULL OR r.transaction_id = $2) AND ($3::uuid IS NULL OR t.ahli_id = $3)
+	ORDER BY r.created_at DESC LIMIT $4`
+	rows, err := q.DB.Query(query, status, transactionID, ahliID, limit)
+	if err != nil {
+		return res, errors.New("unable to query refund requests")
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var r models.RefundRequest
+		if err := scanRefund(rows, &r); err != nil {
+			return res, err
+		}
+		res = append(res, r)
+	}
+	return res, rows.Err()
+}
+
+// DecideRefundRequest moves a pending request to approved or rejected. It reports false
+// when the request was no longer pending, so two reviewers cannot both decide it.
+func (q *RefundQueries) DecideRefundRequest(id uuid.UUID, status string, amount int64, decidedBy uuid.UUID, note *string) (bool, error) {
+	query := `UPDATE refund_requests SET status = $2, amount = $3, decided_by = $4, decision_note = $5, decided_at = now(), updated_at = now()
+	WHERE id = $1 AND status = 'pending'`
+	res, err := q.DB.Exec(query, id, status, amount, decidedBy, note)
+	if err != nil {
+		return false, errors.New("unable to update refund request")
+	}
+	rows, err := res.RowsAffected()
+	if err != nil {
+		return false, errors.New("unable to update refund request")
+	}
+	return rows > 0, nil
+}
+
+func (q *RefundQueries) FailRefundRequest(id uuid.UUID, errMsg string) error {
+	query := `UPDATE refund_requests SET status = 'failed', error = $2, updated_at = now() WHERE id = $1 AND status = 'approved'`
+	if _, err := q.DB.Exec(query, id, errMsg); err != nil {
+		return errors.New("unable to update refund request")
+	}
+	return nil
+}
+
+// MarkRefundProviderRefunded records that the gateway accepted an approved refund that could not be booked yet
+func (q *RefundQueries) MarkRefundProviderRefunded(id uuid.UUID, errMsg string) error {
+	query := `UPDATE refund_requests SET status = 'provider_refunded', error = $2, updated_at = now() WHERE id = $1 AND status = 'approved'`
+	if _, err := q.DB.Exec(query, id, errMsg); err != nil {
+		return errors.New("unable to update refund request")
+	}
+	return nil
+}
+
+// CompleteRefund records an approved refund accepted by the gateway: the refunded amount is added
+// to the transaction, which becomes partially_refunded or refunded, and the request is completed.
+func (q *RefundQueries) CompleteRefund(r models.RefundRequest) (models.Transaction, error) {
+	t := models.Transaction{}
+	tx, err := q.DB.Begin()
+	if err != nil {
+		return t, errors.New("unable to start transaction")
+	}
+	defer tx.Rollback()
+
+	query := `UPDATE transactions SET refunded_amount = refunded_amount + $2,
+	status = CASE WHEN refunded_amount + $2 >= amount THEN 'refunded' ELSE 'partially_refunded' END, updated_at = now()
+	WHERE id = $1 AND status IN ('completed', 'partially_refunded') AND refunded_amount + $2 <= amount
+	RETURNING id, user_id, ahli_id, kind, amount, refunded_amount, status, payment_url, created_at, updated_at`
+	err = tx.QueryRow(query, r.TransactionID, r.Amount).Scan(&t.ID, &t.UserID, &t.AhliID, &t.Kind, &t.Amount, &t.RefundedAmount, &t.Status, &t.PaymentURL, &t.CreatedAt, &t.UpdatedAt)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return t, errors.New("refund exceeds the refundable amount")
+		}
+		return t, errors.New("unable to update transaction")
+	}
+
+	if _, err := tx.Exec(`UPDATE refund_requests SET status = 'completed', error = NULL, updated_at = now() WHERE id = $1`, r.ID); err != nil {
+		return t, errors.New("unable to update refund request")
+	}
+
+	if err := tx.Commit(); err != nil {
+		return t, errors.New("unable to commit transaction")
+	}
+	return t, nil
+}
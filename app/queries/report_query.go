@@ -0,0 +1,67 @@
+package queries
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/gilanghuda/sobi-backend/app/models"
+)
+
+type ReportQueries struct {
+	DB *sql.DB
+}
+
+// revenueGroups maps a report grouping to its key and label expressions. Categories come from the
+// ahli's primary category; tips go to listeners, who have none, so they form their own group.
+var revenueGroups = map[string][2]string{
+	"day":      {`to_char(date_trunc('day', t.created_at), 'YYYY-MM-DD')`, `''`},
+	"week":     {`to_char(date_trunc('week', t.created_at), 'YYYY-MM-DD')`, `''`},
+	"month":    {`to_char(date_trunc('month', t.created_at), 'YYYY-MM')`, `''`},
+	"ahli":     {`t.ahli_id::text`, `COALESCE(max(u.username), '')`},
+	"category": {`CASE WHEN t.kind = 'tip' THEN 'tip' ELSE COALESCE(c.slug, 'uncategorized') END`, `CASE WHEN bool_and(t.kind = 'tip') THEN 'Tip' ELSE COALESCE(max(c.name), 'Uncategorized') END`},
+}
+
+// ValidRevenueGroup reports whether groupBy is a supported report grouping
+func ValidRevenueGroup(groupBy string) bool {
+	_, ok := revenueGroups[groupBy]
+	return ok
+}
+
+// GetRevenueReport aggregates the transactions created within [from, to) by period, ahli or category,
+// optionally restricted to one transaction kind. Refunded and partially refunded transactions count as paid.
+func (q *ReportQueries) GetRevenueReport(groupBy string, from, to time.Time, kind string) ([]models.RevenueRow, error) {
+	res := []models.RevenueRow{}
+	group, ok := revenueGroups[groupBy]
+	if !ok {
+		return res, errors.New("unknown report grouping")
+	}
+	query := `SELECT ` + group[0] + ` AS key, ` + group[1] + `,
+	count(*),
+	count(*) FILTER (WHERE t.status IN ('completed', 'partially_refunded', 'refunded')),
+	count(*) FILTER (WHERE t.status = 'failed'),
+	count(*) FILTER (WHERE t.status = 'expired'),
+	count(*) FILTER (WHERE t.status = 'pending'),
+	COALESCE(sum(t.amount) FILTER (WHERE t.status IN ('completed', 'partially_refunded', 'refunded')), 0),
+	COALESCE(sum(t.discount_amount) FILTER (WHERE t.status IN ('completed', 'partially_refunded', 'refunded')), 0),
+	COALESCE(sum(t.refunded_amount), 0)
+	FROM transactions t LEFT JOIN users u ON u.uid = t.ahli_id LEFT JOIN ahli a ON a.uid = t.ahli_id
+	LEFT JOIN categories c ON c.slug = a.category
+	WHERE t.created_at >= $1 AND t.created_at < $2 AND ($3 = '' OR t.kind = $3)
+	GROUP BY key ORDER BY key`
+	rows, err := q.DB.Query(query, from, to, kind)
+	if err != nil {
+		return res, errors.New("unable to query revenue report")
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var r models.RevenueRow
+		if err := rows.Scan(&r.Key, &r.Label, &r.Transactions, &r.Paid, &r.Failed, &r.Expired, &r.Pending, &r.Gross, &r.Discounts, &r.Refunded); err != nil {
+			return res, err
+		}
+		r.Net = r.Gross - r.Refunded
+		r.ComputeSuccessRate()
+		res = append(res, r)
+	}
+	return res, rows.Err()
+}
@@ -0,0 +1,393 @@
+package queries
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/gilanghuda/sobi-backend/app/models"
+	"github.com/google/uuid"
+)
+
+type SubscriptionQueries struct {
+	DB *sql.DB
+}
+
+const packageColumns = `id, ahli_id, name, description, sessions, price, period_days, recurring, active, created_at, updated_at`
+
+func scanPackage(row interface{ Scan(...interface{}) error }, p *models.SessionPackage) error {
+	return row.Scan(&p.ID, &p.AhliID, &p.Name, &p.Description, &p.Sessions, &p.Price, &p.PeriodDays, &p.Recurring, &p.Active, &p.CreatedAt, &p.UpdatedAt)
+}
+
+func (q *SubscriptionQueries) CreatePackage(p *models.SessionPackage) error {
+	query := `INSERT INTO session_packages (id, ahli_id, name, description, sessions, price, period_days, recurring, active, created_at, updated_at) VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9,$10,$11)`
+	_, err := q.DB.Exec(query, p.ID, p.AhliID, p.Name, p.Description, p.Sessions, p.Price, p.PeriodDays, p.Recurring, p.Active, p.CreatedAt, p.UpdatedAt)
+	if err != nil {
+		return errors.New("unable to create package")
+	}
+	return nil
+}
+
+// UpdatePackage changes a package owned by p.AhliID. Existing subscriptions keep their
+// credits; the new terms apply from their next renewal.
+func (q *SubscriptionQueries) UpdatePackage(p *models.SessionPackage) error {
+	query := `UPDATE session_packages SET name = $3, description = $4, sessions = $5, price = $6, period_days = $7, recurring = $8, active = $9, updated_at = now()
+	WHERE id = $1 AND ahli_id = $2`
+	res, err := q.DB.Exec(query, p.ID, p.AhliID, p.Name, p.Description, p.Sessions, p.Price, p.PeriodDays, p.Recurring, p.Active)
+	if err != nil {
+		return errors.New("unable to update package")
+	}
+	if rows, _ := res.RowsAffected(); rows == 0 {
+		return errors.New("package not found")
+	}
+	return nil
+}
+
+func (q *SubscriptionQueries) GetPackageByID(id uuid.UUID) (models.SessionPackage, error) {
+	p := models.SessionPackage{}
+	query := `SELECT ` + packageColumns + ` FROM session_packages WHERE id = $1`
+	if err := scanPackage(q.DB.QueryRow(query, id), &p); err != nil {
+		if err == sql.ErrNoRows {
+			return p, errors.New("package not found")
+		}
+		return p, errors.New("unable to get package")
+	}
+	return p, nil
+}
+
+// GetPackages lists packages, optionally for one ahli, cheapest first
+func (q *SubscriptionQueries) GetPackages(ahliID *uuid.UUID, includeInactive bool) ([]models.SessionPackage, error) {
+	res := []models.SessionPackage{}
+	query := `SELECT ` + packageColumns + ` FROM session_packages
+	WHERE ($1::uuid IS NULL OR ahli_id = $1) AND ($2 OR active) ORDER BY price, name`
+	rows, err := q.DB.Query(query, ahliID, includeInactive)
+	if err != nil {
+		return res, errors.New("unable to query packages")
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var p models.SessionPackage
+		if err := scanPackage(rows, &p); err != nil {
+			return res, err
+		}
+		res = append(res, p)
+	}
+	return res, rows.Err()
+}
+
+const subscriptionColumns = `s.id, s.user_id, s.ahli_id, s.package_id, s.status, s.auto_renew, s.credits_total, s.credits_remaining,
+	s.current_period_start, s.current_period_end, s.renewal_transaction_id, s.next_period_credits, s.next_period_end, s.created_at, s.updated_at, p.name, p.price, COALESCE(t.payment_url, '')`
+
+const subscriptionFrom = ` FROM subscriptions s JOIN session_packages p ON p.id = s.package_id
+	LEFT JOIN transactions t ON t.id = s.renewal_transaction_id AND t.status = 'pending'`
+
+func scanSubscription(row interface{ Scan(...interface{}) error }, s *models.Subscription) error {
+	var start, end, nextEnd sql.NullTime
+	var renewal uuid.NullUUID
+	var nextCredits sql.NullInt64
+	if err := row.Scan(&s.ID, &s.UserID, &s.AhliID, &s.PackageID, &s.Status, &s.AutoRenew, &s.CreditsTotal, &s.CreditsRemaining,
+		&start, &end, &renewal, &nextCredits, &nextEnd, &s.CreatedAt, &s.UpdatedAt, &s.PackageName, &s.PackagePrice, &s.PaymentURL); err != nil {
+		return err
+	}
+	if nextCredits.Valid {
+		n := int(nextCredits.Int64)
+		s.NextPeriodCredits = &n
+	}
+	if nextEnd.Valid {
+		s.NextPeriodEnd = &nextEnd.Time
+	}
+	if start.Valid {
+		s.CurrentPeriodStart = &start.Time
+	}
+	if end.Valid {
+		s.CurrentPeriodEnd = &end.Time
+	}
+	if renewal.Valid {
+		s.RenewalTransactionID = &renewal.UUID
+	}
+	return nil
+}
+
+// CreateSubscription stores a pending subscription together with the transaction paying for it
+func (q *SubscriptionQueries) CreateSubscription(s *models.Subscription, t *models.Transaction) error {
+	tx, err := q.DB.Begin()
+	if err != nil {
+		return errors.New("unable to start transaction")
+	}
+	defer tx.Rollback()
+
+	query := `INSERT INTO subscriptions (id, user_id, ahli_id, package_id, status, auto_renew, created_at, updated_at) VALUES ($1,$2,$3,$4,$5,$6,$7,$8)`
+	if _, err := tx.Exec(query, s.ID, s.UserID, s.AhliID, s.PackageID, s.Status, s.AutoRenew, s.CreatedAt, s.UpdatedAt); err != nil {
+		return errors.New("unable to create subscription")
+	}
+	if _, err := tx.Exec(insertTransaction, transactionInsertArgs(t)...); err != nil {
+		return errors.New("unable to create transaction")
+	}
+
+	if err := tx.Commit(); err != nil {
+		return errors.New("unable to commit transaction")
+	}
+	return nil
+}
+
+func (q *SubscriptionQueries) GetSubscriptionByID(id uuid.UUID) (models.Subscription, error) {
+	s := models.Subscription{}
+	query := `SELECT ` + subscriptionColumns + subscriptionFrom + ` WHERE s.id = $1`
+	if err := scanSubscription(q.DB.QueryRow(query, id), &s); err != nil {
+		if err == sql.ErrNoRows {
+			return s, errors.New("subscription not found")
+		}
+		return s, errors.New("unable to get subscription")
+	}
+	return s, nil
+}
+
+func (q *SubscriptionQueries) GetSubscriptionsByUser(userID uuid.UUID) ([]models.Subscription, error) {
+	res := []models.Subscription{}
+	query := `SELECT ` + subscriptionColumns + subscriptionFrom + ` WHERE s.user_id = $1 ORDER BY s.created_at DESC`
+	rows, err := q.DB.Query(query, userID)
+	if err != nil {
+		return res, errors.New("unable to query subscriptions")
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var s models.Subscription
+		if err := scanSubscription(rows, &s); err != nil {
+			return res, err
+		}
+		res = append(res, s)
+	}
+	return res, rows.Err()
+}
+
+func (q *SubscriptionQueries) SetAutoRenew(id, userID uuid.UUID, autoRenew bool) error {
+	query := `UPDATE subscriptions SET auto_renew = $3, updated_at = now() WHERE id = $1 AND user_id = $2 AND status IN ('pending', 'active')`
+	res, err := q.DB.Exec(query, id, userID, autoRenew)
+	if err != nil {
+		return errors.New("unable to update subscription")
+	}
+	if rows, _ := res.RowsAffected(); rows == 0 {
+		return errors.New("subscription not found")
+	}
+	return nil
+}
+
+// ActivateSubscription applies a paid package transaction. A first payment starts the first
+// period; a renewal starts the next period where the current one ends. Either way the
+// credits are reset to the package's sessions, so unused credits do not roll over.
+func (q *SubscriptionQueries) ActivateSubscription(txID uuid.UUID) (models.Subscription, error) {
+	s := models.Subscription{}
+	tx, err := q.DB.Begin()
+	if err != nil {
+		return s, errors.New("unable to start transaction")
+	}
+	defer tx.Rollback()
+
+	var id uuid.UUID
+	var status string
+	var end sql.NullTime
+	var sessions, periodDays int
+	query := `SELECT s.id, s.status, s.current_period_end, p.sessions, p.period_days
+	FROM transactions t JOIN subscriptions s ON s.id = t.subscription_id JOIN session_packages p ON p.id = s.package_id
+	WHERE t.id = $1 FOR UPDATE OF s`
+	if err := tx.QueryRow(query, txID).Scan(&id, &status, &end, &sessions, &periodDays); err != nil {
+		if err == sql.ErrNoRows {
+			return s, errors.New("subscription not found")
+		}
+		return s, errors.New("unable to get subscription")
+	}
+
+	if status == models.SubscriptionActive && end.Valid && end.Time.After(time.Now()) {
+		// renewed before the period is over: the current credits stay until RollOverSubscriptions
+		query = `UPDATE subscriptions SET next_period_credits = $2, next_period_end = $3, renewal_transaction_id = NULL, updated_at = now() WHERE id = $1`
+		if _, err := tx.Exec(query, id, sessions, end.Time.AddDate(0, 0, periodDays)); err != nil {
+			return s, errors.New("unable to renew subscription")
+		}
+	} else {
+		start := time.Now()
+		query = `UPDATE subscriptions SET status = 'active', credits_total = $2, credits_remaining = $2, current_period_start = $3, current_period_end = $4,
+		next_period_credits = NULL, next_period_end = NULL, renewal_transaction_id = NULL, updated_at = now() WHERE id = $1`
+		if _, err := tx.Exec(query, id, sessions, start, start.AddDate(0, 0, periodDays)); err != nil {
+			return s, errors.New("unable to activate subscription")
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return s, errors.New("unable to commit transaction")
+	}
+	return q.GetSubscriptionByID(id)
+}
+
+// FailSubscriptionPayment handles a package transaction that failed or expired: a subscription
+// that never started is cancelled, and a failed renewal is cleared so it can be retried.
+func (q *SubscriptionQueries) FailSubscriptionPayment(txID uuid.UUID) error {
+	query := `UPDATE subscriptions s SET
+	status = CASE WHEN s.status = 'pending' THEN 'cancelled' ELSE s.status END,
+	renewal_transaction_id = CASE WHEN s.renewal_transaction_id = $1 THEN NULL ELSE s.renewal_transaction_id END,
+	updated_at = now()
+	FROM transactions t WHERE t.id = $1 AND s.id = t.subscription_id`
+	if _, err := q.DB.Exec(query, txID); err != nil {
+		return errors.New("unable to update subscription")
+	}
+	return nil
+}
+
+// RevokeRefundedCredits takes back what a refunded package payment bought. A full refund cancels the
+// subscription and clears its credits; a partial one removes the refunded share of the package's
+// sessions, rounded up, from the credits left.
+func (q *SubscriptionQueries) RevokeRefundedCredits(txID uuid.UUID, amount int64, full bool) error {
+	var err error
+	if full {
+		query := `UPDATE subscriptions s SET status = 'cancelled', auto_renew = false, credits_remaining = 0,
+		next_period_credits = NULL, next_period_end = NULL, updated_at = now()
+		FROM transactions t WHERE t.id = $1 AND s.id = t.subscription_id`
+		_, err = q.DB.Exec(query, txID)
+	} else {
+		query := `UPDATE subscriptions s SET credits_remaining = GREATEST(s.credits_remaining - CEIL(p.sessions::numeric * $2 / t.amount)::int, 0), updated_at = now()
+		FROM transactions t, session_packages p
+		WHERE t.id = $1 AND s.id = t.subscription_id AND p.id = s.package_id`
+		_, err = q.DB.Exec(query, txID, amount)
+	}
+	if err != nil {
+		return errors.New("unable to update subscription")
+	}
+	return nil
+}
+
+// GetSubscriptionsDueForRenewal returns active auto-renewing subscriptions of recurring packages
+// whose period ends before the given time and that have no renewal payment open
+func (q *SubscriptionQueries) GetSubscriptionsDueForRenewal(before time.Time, limit int) ([]models.Subscription, error) {
+	res := []models.Subscription{}
+	query := `SELECT ` + subscriptionColumns + subscriptionFrom + `
+	WHERE s.status = 'active' AND s.auto_renew AND p.recurring AND p.active AND s.current_period_end < $1 AND s.renewal_transaction_id IS NULL
+	AND s.next_period_credits IS NULL
+	ORDER BY s.current_period_end LIMIT $2`
+	rows, err := q.DB.Query(query, before, limit)
+	if err != nil {
+		return res, errors.New("unable to query subscriptions")
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var s models.Subscription
+		if err := scanSubscription(rows, &s); err != nil {
+			return res, err
+		}
+		res = append(res, s)
+	}
+	return res, rows.Err()
+}
+
+// CreateRenewalTransaction stores the transaction paying for the next period of a subscription
+func (q *SubscriptionQueries) CreateRenewalTransaction(subID uuid.UUID, t *models.Transaction) error {
+	tx, err := q.DB.Begin()
+	if err != nil {
+		return errors.New("unable to start transaction")
+	}
+	defer tx.Rollback()
+
+	t.SubscriptionID = &subID
+	if _, err := tx.Exec(insertTransaction, transactionInsertArgs(t)...); err != nil {
+		return errors.New("unable to create transaction")
+	}
+	res, err := tx.Exec(`UPDATE subscriptions SET renewal_transaction_id = $2, updated_at = now() WHERE id = $1 AND renewal_transaction_id IS NULL`, subID, t.ID)
+	if err != nil {
+		return errors.New("unable to update subscription")
+	}
+	if rows, _ := res.RowsAffected(); rows == 0 {
+		return errors.New("subscription already has a renewal open")
+	}
+
+	if err := tx.Commit(); err != nil {
+		return errors.New("unable to commit transaction")
+	}
+	return nil
+}
+
+// RollOverSubscriptions starts the prepaid next period of subscriptions whose current period is over
+func (q *SubscriptionQueries) RollOverSubscriptions(now time.Time) (int64, error) {
+	query := `UPDATE subscriptions SET credits_total = next_period_credits, credits_remaining = next_period_credits,
+	current_period_start = current_period_end, current_period_end = next_period_end,
+	next_period_credits = NULL, next_period_end = NULL, updated_at = now()
+	WHERE status = 'active' AND next_period_credits IS NOT NULL AND current_period_end <= $1`
+	res, err := q.DB.Exec(query, now)
+	if err != nil {
+		return 0, errors.New("unable to roll over subscriptions")
+	}
+	return res.RowsAffected()
+}
+
+// ExpireSubscriptions ends active subscriptions whose period is over and drops their credits.
+// Pending subscriptions whose first payment never arrived are cancelled after the same grace.
+func (q *SubscriptionQueries) ExpireSubscriptions(now time.Time, pendingBefore time.Time) (int64, error) {
+	query := `UPDATE subscriptions SET
+	status = CASE WHEN status = 'active' THEN 'expired' ELSE 'cancelled' END,
+	credits_remaining = 0, updated_at = now()
+	WHERE (status = 'active' AND current_period_end <= $1 AND next_period_credits IS NULL) OR (status = 'pending' AND created_at < $2)`
+	res, err := q.DB.Exec(query, now, pendingBefore)
+	if err != nil {
+		return 0, errors.New("unable to expire subscriptions")
+	}
+	return res.RowsAffected()
+}
+
+// CreateCreditBooking takes one credit from an active subscription and books a session with its ahli.
+// The credit and the schedule are checked under a row lock so concurrent bookings cannot overspend.
+func (q *SubscriptionQueries) CreateCreditBooking(subID, userID uuid.UUID, b *models.Booking) error {
+	tx, err := q.DB.Begin()
+	if err != nil {
+		return errors.New("unable to start transaction")
+	}
+	defer tx.Rollback()
+
+	var ahliID uuid.UUID
+	var status string
+	var credits int
+	var end sql.NullTime
+	query := `SELECT ahli_id, status, credits_remaining, current_period_end FROM subscriptions WHERE id = $1 AND user_id = $2 FOR UPDATE`
+	if err := tx.QueryRow(query, subID, userID).Scan(&ahliID, &status, &credits, &end); err != nil {
+		if err == sql.ErrNoRows {
+			return errors.New("subscription not found")
+		}
+		return errors.New("unable to get subscription")
+	}
+	if status != models.SubscriptionActive {
+		return errors.New("subscription is not active")
+	}
+	if credits <= 0 {
+		return errors.New("no credits left in this period")
+	}
+	if !end.Valid || !b.StartAt.Before(end.Time) {
+		return errors.New("session must start before the subscription period ends")
+	}
+
+	var overlap bool
+	query = `SELECT EXISTS (SELECT 1 FROM bookings WHERE ahli_id = $1 AND status IN ('pending', 'confirmed') AND start_at < $3 AND end_at > $2)`
+	if err := tx.QueryRow(query, ahliID, b.StartAt, b.EndAt).Scan(&overlap); err != nil {
+		return errors.New("unable to check bookings")
+	}
+	if overlap {
+		return errors.New("ahli already has a session at that time")
+	}
+
+	if _, err := tx.Exec(`UPDATE subscriptions SET credits_remaining = credits_remaining - 1, updated_at = now() WHERE id = $1`, subID); err != nil {
+		return errors.New("unable to use credit")
+	}
+	b.AhliID = ahliID
+	b.SubscriptionID = &subID
+	if err := createBooking(tx, b); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return errors.New("unable to commit transaction")
+	}
+	return nil
+}
+
+// RestoreCredit gives back the credit of a cancelled booking while the subscription is still active
+func (q *SubscriptionQueries) RestoreCredit(subID uuid.UUID) error {
+	query := `UPDATE subscriptions SET credits_remaining = LEAST(credits_remaining + 1, credits_total), updated_at = now() WHERE id = $1 AND status = 'active'`
+	if _, err := q.DB.Exec(query, subID); err != nil {
+		return errors.New("unable to restore credit")
+	}
+	return nil
+}
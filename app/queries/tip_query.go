@@ -0,0 +1,51 @@
+package queries
+
+import (
+	"database/sql"
+	"errors"
+
+	"github.com/gilanghuda/sobi-backend/app/models"
+	"github.com/google/uuid"
+)
+
+type TipQueries struct {
+	DB *sql.DB
+}
+
+// GetTipTotals returns the number of paid tips received by a user, their net amount and the amount already paid out
+func (q *TipQueries) GetTipTotals(recipientID uuid.UUID) (int, int64, int64, error) {
+	var count int
+	var total, paidOut int64
+	query := `SELECT count(*), COALESCE(sum(amount - refunded_amount), 0),
+	(SELECT COALESCE(sum(amount), 0) FROM payouts WHERE ahli_id = $1)
+	FROM transactions WHERE ahli_id = $1 AND kind = 'tip' AND status IN ('completed', 'partially_refunded')`
+	if err := q.DB.QueryRow(query, recipientID).Scan(&count, &total, &paidOut); err != nil {
+		return 0, 0, 0, errors.New("unable to get tip totals")
+	}
+	return count, total, paidOut, nil
+}
+
+// GetReceivedTips returns the latest paid tips received by a user
+func (q *TipQueries) GetReceivedTips(recipientID uuid.UUID, limit int) ([]models.ReceivedTip, error) {
+	res := []models.ReceivedTip{}
+	query := `SELECT id, room_id, amount - refunded_amount, status, created_at FROM transactions
+	WHERE ahli_id = $1 AND kind = 'tip' AND status IN ('completed', 'partially_refunded')
+	ORDER BY created_at DESC LIMIT $2`
+	rows, err := q.DB.Query(query, recipientID, limit)
+	if err != nil {
+		return res, errors.New("unable to query tips")
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var t models.ReceivedTip
+		var roomID uuid.NullUUID
+		if err := rows.Scan(&t.TransactionID, &roomID, &t.Amount, &t.Status, &t.CreatedAt); err != nil {
+			return res, err
+		}
+		if roomID.Valid {
+			t.RoomID = &roomID.UUID
+		}
+		res = append(res, t)
+	}
+	return res, rows.Err()
+}
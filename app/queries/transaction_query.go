@@ -3,8 +3,11 @@ package queries
 import (
 	"database/sql"
 	"errors"
+	"strings"
+	"time"
 
 	"github.com/gilanghuda/sobi-backend/app/models"
+	"github.com/gilanghuda/sobi-backend/pkg/utils"
 	"github.com/google/uuid"
 )
 
@@ -12,25 +15,75 @@ type TransactionQueries struct {
 	DB *sql.DB
 }
 
+const insertTransaction = `INSERT INTO transactions (id, user_id, ahli_id, kind, subscription_id, room_id, amount, voucher_id, discount_amount, status, payment_url, snap_token, created_at, updated_at)
+VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9,$10,$11,NULLIF($12, ''),$13,$14)`
+
+// transactionInsertArgs returns the arguments of insertTransaction, defaulting the kind to a consultation
+func transactionInsertArgs(t *models.Transaction) []interface{} {
+	if t.Kind == "" {
+		t.Kind = models.TransactionKindConsultation
+	}
+	return []interface{}{t.ID, t.UserID, t.AhliID, t.Kind, t.SubscriptionID, t.RoomID, t.Amount, t.VoucherID, t.DiscountAmount, t.Status, t.PaymentURL, t.SnapToken, t.CreatedAt, t.UpdatedAt}
+}
+
 func (q *TransactionQueries) CreateTransaction(t *models.Transaction) error {
-	query := `INSERT INTO transactions (id, user_id, ahli_id, amount, status, payment_url, created_at, updated_at) VALUES ($1,$2,$3,$4,$5,$6,$7,$8)`
-	_, err := q.DB.Exec(query, t.ID, t.UserID, t.AhliID, t.Amount, t.Status, t.PaymentURL, t.CreatedAt, t.UpdatedAt)
+	_, err := q.DB.Exec(insertTransaction, transactionInsertArgs(t)...)
 	if err != nil {
 		return errors.New("unable to create transaction")
 	}
 	return nil
 }
 
+// CreateBookedTransaction stores a consultation payment together with the booking it pays for,
+// so a slot is never held without a payment nor paid without a slot
+func (q *TransactionQueries) CreateBookedTransaction(t *models.Transaction, b *models.Booking) error {
+	tx, err := q.DB.Begin()
+	if err != nil {
+		return errors.New("unable to start transaction")
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(insertTransaction, transactionInsertArgs(t)...); err != nil {
+		return errors.New("unable to create transaction")
+	}
+	if err := createBooking(tx, b); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return errors.New("unable to commit transaction")
+	}
+	return nil
+}
+
+// SetTransactionPayment stores the payment page of a charge opened for a transaction
+func (q *TransactionQueries) SetTransactionPayment(id uuid.UUID, paymentURL, snapToken string) error {
+	query := `UPDATE transactions SET payment_url = $2, snap_token = NULLIF($3, ''), updated_at = now() WHERE id = $1`
+	if _, err := q.DB.Exec(query, id, paymentURL, snapToken); err != nil {
+		return errors.New("unable to update transaction")
+	}
+	return nil
+}
+
 func (q *TransactionQueries) GetTransactionByID(id uuid.UUID) (models.Transaction, error) {
 	t := models.Transaction{}
-	query := `SELECT id, user_id, ahli_id, amount, status, payment_url, created_at, updated_at FROM transactions WHERE id = $1`
-	err := q.DB.QueryRow(query, id).Scan(&t.ID, &t.UserID, &t.AhliID, &t.Amount, &t.Status, &t.PaymentURL, &t.CreatedAt, &t.UpdatedAt)
+	var subID, roomID, voucherID uuid.NullUUID
+	query := `SELECT id, user_id, ahli_id, kind, subscription_id, room_id, amount, voucher_id, discount_amount, refunded_amount, status, payment_url, COALESCE(snap_token, ''), created_at, updated_at FROM transactions WHERE id = $1`
+	err := q.DB.QueryRow(query, id).Scan(&t.ID, &t.UserID, &t.AhliID, &t.Kind, &subID, &roomID, &t.Amount, &voucherID, &t.DiscountAmount, &t.RefundedAmount, &t.Status, &t.PaymentURL, &t.SnapToken, &t.CreatedAt, &t.UpdatedAt)
 	if err != nil {
 		if err == sql.ErrNoRows {
 			return t, errors.New("transaction not found")
 		}
 		return t, errors.New("unable to get transaction")
 	}
+	if subID.Valid {
+		t.SubscriptionID = &subID.UUID
+	}
+	if roomID.Valid {
+		t.RoomID = &roomID.UUID
+	}
+	if voucherID.Valid {
+		t.VoucherID = &voucherID.UUID
+	}
 	return t, nil
 }
 
@@ -49,3 +102,182 @@ func (q *TransactionQueries) UpdateTransactionStatus(id uuid.UUID, status string
 	}
 	return nil
 }
+
+// UpdateTransactionStatusFrom moves a transaction to status only if it is still in from,
+// so concurrent notifications cannot both apply a transition.
+func (q *TransactionQueries) UpdateTransactionStatusFrom(id uuid.UUID, from, status string) (bool, error) {
+	query := `UPDATE transactions SET status = $3, updated_at = now() WHERE id = $1 AND status = $2`
+	res, err := q.DB.Exec(query, id, from, status)
+	if err != nil {
+		return false, errors.New("unable to update transaction")
+	}
+	rows, err := res.RowsAffected()
+	if err != nil {
+		return false, errors.New("unable to update transaction")
+	}
+	return rows > 0, nil
+}
+
+// GetTransactionsByUser lists a user's transactions newest first, keyset paginated on (created_at, id)
+func (q *TransactionQueries) GetTransactionsByUser(userID uuid.UUID, status, cursor string, limit int) ([]models.TransactionHistoryItem, string, error) {
+	res := []models.TransactionHistoryItem{}
+	args := []interface{}{userID, status, limit + 1}
+	where := ""
+	if cursor != "" {
+		cur, err := utils.DecodeCursor(cursor)
+		if err != nil {
+			return res, "", err
+		}
+		if err := cur.CheckTimeID(); err != nil {
+			return res, "", err
+		}
+		where = " AND (t.created_at, t.id) < ($4::timestamp, $5::uuid)"
+		args = append(args, cur.Value, cur.ID)
+	}
+
+	query := `SELECT t.id, t.user_id, t.ahli_id, t.kind, t.amount, t.refunded_amount, t.status, COALESCE(t.payment_url, ''), t.created_at, t.updated_at,
+	COALESCE(u.username, ''), COALESCE(u.avatar::text, ''), COALESCE(a.category, '')
+	FROM transactions t LEFT JOIN users u ON u.uid = t.ahli_id LEFT JOIN ahli a ON a.uid = t.ahli_id
+	WHERE t.user_id = $1 AND ($2 = '' OR t.status = $2)` + where + `
+	ORDER BY t.created_at DESC, t.id DESC LIMIT $3`
+	rows, err := q.DB.Query(query, args...)
+	if err != nil {
+		return res, "", errors.New("unable to query transactions")
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var t models.TransactionHistoryItem
+		if err := rows.Scan(&t.ID, &t.UserID, &t.AhliID, &t.Kind, &t.Amount, &t.RefundedAmount, &t.Status, &t.PaymentURL, &t.CreatedAt, &t.UpdatedAt,
+			&t.AhliName, &t.AhliAvatar, &t.AhliCategory); err != nil {
+			return res, "", err
+		}
+		res = append(res, t)
+	}
+	if err := rows.Err(); err != nil {
+		return res, "", err
+	}
+
+	next := ""
+	if len(res) > limit {
+		res = res[:limit]
+		last := res[len(res)-1]
+		next = utils.EncodeCursor(last.CreatedAt.Format(utils.TimeCursorLayout), last.ID.String())
+	}
+	return res, next, nil
+}
+
+// GetReceipt loads what is printed on the receipt of a transaction
+func (q *TransactionQueries) GetReceipt(id uuid.UUID) (models.Receipt, error) {
+	r := models.Receipt{}
+	t := &r.Transaction
+	var start, end sql.NullTime
+	query := `SELECT t.id, t.user_id, t.ahli_id, t.amount, t.discount_amount, t.refunded_amount, t.status, COALESCE(t.payment_url, ''), t.created_at, t.updated_at,
+	COALESCE(u.username, ''), COALESCE(u.email, ''), COALESCE(ah.username, ''), COALESCE(a.category, ''), b.start_at, b.end_at
+	FROM transactions t
+	LEFT JOIN users u ON u.uid = t.user_id
+	LEFT JOIN users ah ON ah.uid = t.ahli_id
+	LEFT JOIN ahli a ON a.uid = t.ahli_id
+	LEFT JOIN bookings b ON b.transaction_id = t.id
+	WHERE t.id = $1`
+	err := q.DB.QueryRow(query, id).Scan(&t.ID, &t.UserID, &t.AhliID, &t.Amount, &t.DiscountAmount, &t.RefundedAmount, &t.Status, &t.PaymentURL, &t.CreatedAt, &t.UpdatedAt,
+		&r.UserName, &r.UserEmail, &r.AhliName, &r.AhliCategory, &start, &end)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return r, errors.New("transaction not found")
+		}
+		return r, errors.New("unable to get transaction")
+	}
+	if start.Valid {
+		r.SessionStartAt = &start.Time
+	}
+	if end.Valid {
+		r.SessionEndAt = &end.Time
+	}
+	r.Number = "SOBI-" + t.CreatedAt.Format("20060102") + "-" + strings.ToUpper(t.ID.String()[:8])
+	r.IssuedAt = time.Now()
+	return r, nil
+}
+
+// GetStalePendingTransactions returns pending transactions created before the given time, oldest first
+func (q *TransactionQueries) GetStalePendingTransactions(before time.Time, limit int) ([]models.Transaction, error) {
+	res := []models.Transaction{}
+	query := `SELECT id, user_id, ahli_id, amount, refunded_amount, status, payment_url, created_at, updated_at FROM transactions
+	WHERE status = 'pending' AND created_at < $1 ORDER BY created_at LIMIT $2`
+	rows, err := q.DB.Query(query, before, limit)
+	if err != nil {
+		return res, errors.New("unable to query pending transactions")
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var t models.Transaction
+		if err := rows.Scan(&t.ID, &t.UserID, &t.AhliID, &t.Amount, &t.RefundedAmount, &t.Status, &t.PaymentURL, &t.CreatedAt, &t.UpdatedAt); err != nil {
+			return res, err
+		}
+		res = append(res, t)
+	}
+	return res, rows.Err()
+}
+
+func (q *TransactionQueries) CreatePaymentNotification(n *models.PaymentNotification) error {
+	query := `INSERT INTO payment_notifications (id, order_id, transaction_status, signature_valid, payload, outcome, received_at) VALUES ($1,$2,$3,$4,$5,$6,$7)`
+	_, err := q.DB.Exec(query, n.ID, n.OrderID, n.TransactionStatus, n.SignatureValid, []byte(n.Payload), n.Outcome, n.ReceivedAt)
+	if err != nil {
+		return errors.New("unable to store payment notification")
+	}
+	return nil
+}
+
+func (q *TransactionQueries) UpdatePaymentNotificationOutcome(id uuid.UUID, signatureValid bool, outcome string, errMsg *string) error {
+	query := `UPDATE payment_notifications SET signature_valid = $2, outcome = $3, error = $4, processed_at = now() WHERE id = $1`
+	if _, err := q.DB.Exec(query, id, signatureValid, outcome, errMsg); err != nil {
+		return errors.New("unable to update payment notification")
+	}
+	return nil
+}
+
+const paymentNotificationColumns = `id, order_id, transaction_status, signature_valid, payload, outcome, error, received_at, processed_at`
+
+func scanPaymentNotification(row interface{ Scan(...interface{}) error }, n *models.PaymentNotification) error {
+	var payload []byte
+	var processed sql.NullTime
+	if err := row.Scan(&n.ID, &n.OrderID, &n.TransactionStatus, &n.SignatureValid, &payload, &n.Outcome, &n.Error, &n.ReceivedAt, &processed); err != nil {
+		return err
+	}
+	n.Payload = payload
+	if processed.Valid {
+		n.ProcessedAt = &processed.Time
+	}
+	return nil
+}
+
+func (q *TransactionQueries) GetPaymentNotification(id uuid.UUID) (models.PaymentNotification, error) {
+	n := models.PaymentNotification{}
+	query := `SELECT ` + paymentNotificationColumns + ` FROM payment_notifications WHERE id = $1`
+	if err := scanPaymentNotification(q.DB.QueryRow(query, id), &n); err != nil {
+		if err == sql.ErrNoRows {
+			return n, errors.New("notification not found")
+		}
+		return n, errors.New("unable to get notification")
+	}
+	return n, nil
+}
+
+// GetPaymentNotifications lists stored notifications newest first, optionally for one order
+func (q *TransactionQueries) GetPaymentNotifications(orderID string, limit int) ([]models.PaymentNotification, error) {
+	res := []models.PaymentNotification{}
+	query := `SELECT ` + paymentNotificationColumns + ` FROM payment_notifications
+	WHERE ($1 = '' OR order_id = $1) ORDER BY received_at DESC LIMIT $2`
+	rows, err := q.DB.Query(query, orderID, limit)
+	if err != nil {
+		return res, errors.New("unable to query notifications")
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var n models.PaymentNotification
+		if err := scanPaymentNotification(rows, &n); err != nil {
+			return res, err
+		}
+		res = append(res, n)
+	}
+	return res, rows.Err()
+}
@@ -5,9 +5,11 @@ import (
 	"errors"
 	"fmt"
 	"strings"
+	"time"
 
 	"github.com/gilanghuda/sobi-backend/app/models"
 	"github.com/google/uuid"
+	"github.com/lib/pq"
 )
 
 type UserQueries struct {
@@ -17,13 +19,14 @@ type UserQueries struct {
 func (q *UserQueries) GetUserByID(id uuid.UUID) (models.User, error) {
 	user := models.User{}
 
-	query := `SELECT uid, username, user_role, email, phone_number, gender, avatar, password_hash, verified,  created_at, updated_at
+	query := `SELECT uid, username, user_role, accepts_tips, email, phone_number, gender, avatar, password_hash, verified,  created_at, updated_at
 			  FROM users WHERE uid = $1`
 
 	err := q.DB.QueryRow(query, id).Scan(
 		&user.ID,
 		&user.Username,
 		&user.UserRole,
+		&user.AcceptsTips,
 		&user.Email,
 		&user.PhoneNumber,
 		&user.Gender,
@@ -45,6 +48,19 @@ func (q *UserQueries) GetUserByID(id uuid.UUID) (models.User, error) {
 	return user, nil
 }
 
+// GetUserContact returns only the username, email and phone number of a user
+func (q *UserQueries) GetUserContact(id uuid.UUID) (models.User, error) {
+	user := models.User{ID: id}
+	query := `SELECT username, email, COALESCE(phone_number, '') FROM users WHERE uid = $1`
+	if err := q.DB.QueryRow(query, id).Scan(&user.Username, &user.Email, &user.PhoneNumber); err != nil {
+		if err == sql.ErrNoRows {
+			return user, errors.New("user not found")
+		}
+		return user, errors.New("unable to get user, DB error")
+	}
+	return user, nil
+}
+
 func (q *UserQueries) GetUserByEmail(email string) (models.User, error) {
 	user := models.User{}
 
@@ -158,6 +174,11 @@ func (q *UserQueries) UpdateUser(userID uuid.UUID, req *models.UpdateUserRequest
 		args = append(args, *req.Avatar)
 		argID++
 	}
+	if req.AcceptsTips != nil {
+		setClauses = append(setClauses, fmt.Sprintf("accepts_tips = $%d", argID))
+		args = append(args, *req.AcceptsTips)
+		argID++
+	}
 
 	if len(setClauses) == 0 {
 		return errors.New("no fields to update")
@@ -179,6 +200,37 @@ func (q *UserQueries) UpdateUser(userID uuid.UUID, req *models.UpdateUserRequest
 	return nil
 }
 
+// UpdateLastSeen records when a user's websocket connection closed
+func (q *UserQueries) UpdateLastSeen(id uuid.UUID, at time.Time) error {
+	if _, err := q.DB.Exec(`UPDATE users SET last_seen_at = $2 WHERE uid = $1`, id, at); err != nil {
+		return errors.New("unable to update last seen")
+	}
+	return nil
+}
+
+// GetLastSeen returns the recorded last seen time of the given users; users never seen are left out
+func (q *UserQueries) GetLastSeen(ids []uuid.UUID) (map[uuid.UUID]time.Time, error) {
+	res := map[uuid.UUID]time.Time{}
+	strs := make([]string, 0, len(ids))
+	for _, id := range ids {
+		strs = append(strs, id.String())
+	}
+	rows, err := q.DB.Query(`SELECT uid, last_seen_at FROM users WHERE uid = ANY($1::uuid[]) AND last_seen_at IS NOT NULL`, pq.Array(strs))
+	if err != nil {
+		return res, errors.New("unable to query last seen")
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var id uuid.UUID
+		var at time.Time
+		if err := rows.Scan(&id, &at); err != nil {
+			return res, err
+		}
+		res[id] = at
+	}
+	return res, rows.Err()
+}
+
 func (q *UserQueries) DeleteUser(id uuid.UUID) error {
 	query := `DELETE FROM users WHERE uid = $1`
 
@@ -271,6 +323,16 @@ func (q *UserQueries) CreateAhli(uid uuid.UUID, req *models.PromoteAhliRequest)
 		return errors.New("unable to create ahli, DB error")
 	}
 
+	// primary category is always one of the specializations
+	_, err = tx.Exec(`INSERT INTO ahli_specializations (ahli_id, category_id)
+		SELECT $1, id FROM categories WHERE slug = ANY($2) ON CONFLICT DO NOTHING`,
+		uid, pq.Array(append([]string{req.Category}, req.Specializations...)),
+	)
+	if err != nil {
+		tx.Rollback()
+		return errors.New("unable to save ahli specializations, DB error")
+	}
+
 	if err := tx.Commit(); err != nil {
 		return errors.New("unable to commit transaction")
 	}
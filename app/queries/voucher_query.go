@@ -0,0 +1,272 @@
+package queries
+
+import (
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/gilanghuda/sobi-backend/app/models"
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+)
+
+type VoucherQueries struct {
+	DB *sql.DB
+}
+
+const voucherColumns = `id, code, description, discount_type, discount_value, max_discount, min_amount, max_uses, max_uses_per_user, used_count,
+	starts_at, ends_at, ahli_id, category, active, created_at, updated_at`
+
+func scanVoucher(row interface{ Scan(...interface{}) error }, v *models.Voucher) error {
+	var maxDiscount sql.NullInt64
+	var maxUses sql.NullInt32
+	var endsAt sql.NullTime
+	var ahliID uuid.NullUUID
+	var category sql.NullString
+	if err := row.Scan(&v.ID, &v.Code, &v.Description, &v.DiscountType, &v.DiscountValue, &maxDiscount, &v.MinAmount, &maxUses, &v.MaxUsesPerUser, &v.UsedCount,
+		&v.StartsAt, &endsAt, &ahliID, &category, &v.Active, &v.CreatedAt, &v.UpdatedAt); err != nil {
+		return err
+	}
+	if maxDiscount.Valid {
+		v.MaxDiscount = &maxDiscount.Int64
+	}
+	if maxUses.Valid {
+		n := int(maxUses.Int32)
+		v.MaxUses = &n
+	}
+	if endsAt.Valid {
+		v.EndsAt = &endsAt.Time
+	}
+	if ahliID.Valid {
+		v.AhliID = &ahliID.UUID
+	}
+	if category.Valid {
+		v.Category = &category.String
+	}
+	return nil
+}
+
+// NormalizeVoucherCode makes codes case insensitive
+func NormalizeVoucherCode(code string) string {
+	return strings.ToUpper(strings.TrimSpace(code))
+}
+
+func (q *VoucherQueries) CreateVoucher(v *models.Voucher) error {
+	query := `INSERT INTO vouchers (id, code, description, discount_type, discount_value, max_discount, min_amount, max_uses, max_uses_per_user,
+	starts_at, ends_at, ahli_id, category, active, created_at, updated_at) VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9,$10,$11,$12,$13,$14,$15,$16)`
+	_, err := q.DB.Exec(query, v.ID, v.Code, v.Description, v.DiscountType, v.DiscountValue, v.MaxDiscount, v.MinAmount, v.MaxUses, v.MaxUsesPerUser,
+		v.StartsAt, v.EndsAt, v.AhliID, v.Category, v.Active, v.CreatedAt, v.UpdatedAt)
+	if err != nil {
+		if pqErr, ok := err.(*pq.Error); ok && pqErr.Code == "23505" {
+			return errors.New("voucher code already exists")
+		}
+		return errors.New("unable to create voucher")
+	}
+	return nil
+}
+
+func (q *VoucherQueries) UpdateVoucher(v *models.Voucher) error {
+	query := `UPDATE vouchers SET code = $2, description = $3, discount_type = $4, discount_value = $5, max_discount = $6, min_amount = $7, max_uses = $8,
+	max_uses_per_user = $9, starts_at = $10, ends_at = $11, ahli_id = $12, category = $13, active = $14, updated_at = now() WHERE id = $1`
+	res, err := q.DB.Exec(query, v.ID, v.Code, v.Description, v.DiscountType, v.DiscountValue, v.MaxDiscount, v.MinAmount, v.MaxUses,
+		v.MaxUsesPerUser, v.StartsAt, v.EndsAt, v.AhliID, v.Category, v.Active)
+	if err != nil {
+		if pqErr, ok := err.(*pq.Error); ok && pqErr.Code == "23505" {
+			return errors.New("voucher code already exists")
+		}
+		return errors.New("unable to update voucher")
+	}
+	if rows, _ := res.RowsAffected(); rows == 0 {
+		return errors.New("voucher not found")
+	}
+	return nil
+}
+
+func (q *VoucherQueries) GetVoucherByID(id uuid.UUID) (models.Voucher, error) {
+	v := models.Voucher{}
+	query := `SELECT ` + voucherColumns + ` FROM vouchers WHERE id = $1`
+	if err := scanVoucher(q.DB.QueryRow(query, id), &v); err != nil {
+		if err == sql.ErrNoRows {
+			return v, errors.New("voucher not found")
+		}
+		return v, errors.New("unable to get voucher")
+	}
+	return v, nil
+}
+
+func (q *VoucherQueries) GetVouchers(includeInactive bool) ([]models.Voucher, error) {
+	res := []models.Voucher{}
+	query := `SELECT ` + voucherColumns + ` FROM vouchers WHERE ($1 OR active) ORDER BY created_at DESC`
+	rows, err := q.DB.Query(query, includeInactive)
+	if err != nil {
+		return res, errors.New("unable to query vouchers")
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var v models.Voucher
+		if err := scanVoucher(rows, &v); err != nil {
+			return res, err
+		}
+		res = append(res, v)
+	}
+	return res, rows.Err()
+}
+
+func (q *VoucherQueries) DeactivateVoucher(id uuid.UUID) error {
+	res, err := q.DB.Exec(`UPDATE vouchers SET active = FALSE, updated_at = now() WHERE id = $1`, id)
+	if err != nil {
+		return errors.New("unable to deactivate voucher")
+	}
+	if rows, _ := res.RowsAffected(); rows == 0 {
+		return errors.New("voucher not found")
+	}
+	return nil
+}
+
+func (q *VoucherQueries) GetRedemptions(voucherID uuid.UUID, limit int) ([]models.VoucherRedemption, error) {
+	res := []models.VoucherRedemption{}
+	query := `SELECT id, voucher_id, user_id, transaction_id, discount, status, created_at, updated_at FROM voucher_redemptions
+	WHERE voucher_id = $1 ORDER BY created_at DESC LIMIT $2`
+	rows, err := q.DB.Query(query, voucherID, limit)
+	if err != nil {
+		return res, errors.New("unable to query redemptions")
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var r models.VoucherRedemption
+		if err := rows.Scan(&r.ID, &r.VoucherID, &r.UserID, &r.TransactionID, &r.Discount, &r.Status, &r.CreatedAt, &r.UpdatedAt); err != nil {
+			return res, err
+		}
+		res = append(res, r)
+	}
+	return res, rows.Err()
+}
+
+type queryRower interface {
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+// checkVoucher loads the voucher by code and validates it for a purchase of amount from the ahli,
+// whose categories are given. With lock set the voucher row is locked for the caller's transaction.
+func checkVoucher(db queryRower, code string, userID, ahliID uuid.UUID, categories []string, amount int64, lock bool) (models.Voucher, int64, error) {
+	v := models.Voucher{}
+	query := `SELECT ` + voucherColumns + ` FROM vouchers WHERE code = $1`
+	if lock {
+		query += ` FOR UPDATE`
+	}
+	if err := scanVoucher(db.QueryRow(query, NormalizeVoucherCode(code)), &v); err != nil {
+		if err == sql.ErrNoRows {
+			return v, 0, errors.New("voucher not found")
+		}
+		return v, 0, errors.New("unable to get voucher")
+	}
+
+	now := time.Now()
+	if !v.Active || now.Before(v.StartsAt) || (v.EndsAt != nil && !now.Before(*v.EndsAt)) {
+		return v, 0, errors.New("voucher is not valid at this time")
+	}
+	if v.AhliID != nil && *v.AhliID != ahliID {
+		return v, 0, errors.New("voucher does not apply to this ahli")
+	}
+	if v.Category != nil {
+		found := false
+		for _, c := range categories {
+			if c == *v.Category {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return v, 0, errors.New("voucher does not apply to this category")
+		}
+	}
+	if amount < v.MinAmount {
+		return v, 0, errors.New("amount is below the voucher minimum")
+	}
+	if v.MaxUses != nil && v.UsedCount >= *v.MaxUses {
+		return v, 0, errors.New("voucher has been fully used")
+	}
+
+	var uses int
+	query = `SELECT count(*) FROM voucher_redemptions WHERE voucher_id = $1 AND user_id = $2 AND status <> 'released'`
+	if err := db.QueryRow(query, v.ID, userID).Scan(&uses); err != nil {
+		return v, 0, errors.New("unable to count voucher uses")
+	}
+	if uses >= v.MaxUsesPerUser {
+		return v, 0, errors.New("voucher already used")
+	}
+
+	discount := v.Discount(amount)
+	if discount >= amount {
+		return v, 0, errors.New("voucher cannot cover the full amount")
+	}
+	return v, discount, nil
+}
+
+// QuoteVoucher validates a voucher for a purchase without using it
+func (q *VoucherQueries) QuoteVoucher(code string, userID, ahliID uuid.UUID, categories []string, amount int64) (models.Voucher, int64, error) {
+	return checkVoucher(q.DB, code, userID, ahliID, categories, amount, false)
+}
+
+// CreateTransactionWithVoucher stores t with the voucher reserved for it. t.Amount is the charged
+// amount and t.DiscountAmount the discount quoted earlier; the voucher is revalidated under lock
+// and the quote must still hold. The booking b, if any, is stored in the same database transaction.
+func (q *VoucherQueries) CreateTransactionWithVoucher(t *models.Transaction, code string, categories []string, b *models.Booking) error {
+	tx, err := q.DB.Begin()
+	if err != nil {
+		return errors.New("unable to start transaction")
+	}
+	defer tx.Rollback()
+
+	v, discount, err := checkVoucher(tx, code, t.UserID, t.AhliID, categories, t.Amount+t.DiscountAmount, true)
+	if err != nil {
+		return err
+	}
+	if discount != t.DiscountAmount {
+		return errors.New("voucher changed, please try again")
+	}
+	t.VoucherID = &v.ID
+
+	if _, err := tx.Exec(insertTransaction, transactionInsertArgs(t)...); err != nil {
+		return errors.New("unable to create transaction")
+	}
+	query := `INSERT INTO voucher_redemptions (voucher_id, user_id, transaction_id, discount, status) VALUES ($1,$2,$3,$4,'reserved')`
+	if _, err := tx.Exec(query, v.ID, t.UserID, t.ID, discount); err != nil {
+		return errors.New("unable to redeem voucher")
+	}
+	if _, err := tx.Exec(`UPDATE vouchers SET used_count = used_count + 1, updated_at = now() WHERE id = $1`, v.ID); err != nil {
+		return errors.New("unable to redeem voucher")
+	}
+	if b != nil {
+		if err := createBooking(tx, b); err != nil {
+			return err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return errors.New("unable to commit transaction")
+	}
+	return nil
+}
+
+// SettleVoucherRedemption finalizes the voucher reserved by a transaction: it is redeemed when the
+// payment completes and released, freeing the use, when the payment fails or expires.
+func (q *VoucherQueries) SettleVoucherRedemption(txID uuid.UUID, paid bool) error {
+	if paid {
+		_, err := q.DB.Exec(`UPDATE voucher_redemptions SET status = 'redeemed', updated_at = now() WHERE transaction_id = $1 AND status = 'reserved'`, txID)
+		if err != nil {
+			return errors.New("unable to update redemption")
+		}
+		return nil
+	}
+
+	query := `WITH released AS (
+	  UPDATE voucher_redemptions SET status = 'released', updated_at = now() WHERE transaction_id = $1 AND status = 'reserved' RETURNING voucher_id
+	)
+	UPDATE vouchers v SET used_count = GREATEST(used_count - 1, 0), updated_at = now() FROM released r WHERE v.id = r.voucher_id`
+	if _, err := q.DB.Exec(query, txID); err != nil {
+		return errors.New("unable to release voucher")
+	}
+	return nil
+}
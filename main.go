@@ -4,6 +4,7 @@ import (
 	"log"
 
 	"github.com/gilanghuda/sobi-backend/pkg/database"
+	"github.com/gilanghuda/sobi-backend/pkg/middleware"
 	"github.com/gilanghuda/sobi-backend/pkg/routes"
 	"github.com/gofiber/fiber/v2"
 	"github.com/gofiber/fiber/v2/middleware/cors"
@@ -18,12 +19,16 @@ func main() {
 	// 	log.Fatalf("Error loading .env file: %v", err)
 	// }
 
-	app := fiber.New()
+	// the server-wide limit only has to fit chat attachments, which are capped per type in the
+	// handler; every other route is held to Fiber's default limit below
+	app := fiber.New(fiber.Config{BodyLimit: controllers.AttachmentBodyLimit})
+	app.Use(middleware.BodyLimit(fiber.DefaultBodyLimit, "/chat/messages/attachments"))
 
 	app.Use(cors.New(cors.Config{
-		AllowOrigins: "http://localhost:3001, http://localhost:3002, http://localhost:3003, https://sobi.gilanghuda.my.id",
-		AllowHeaders: "Origin, Content-Type, Accept, Authorization",
-		AllowMethods: "GET,POST,PUT,DELETE,OPTIONS",
+		AllowOrigins:  "http://localhost:3001, http://localhost:3002, http://localhost:3003, https://sobi.gilanghuda.my.id",
+		AllowHeaders:  "Origin, Content-Type, Accept, Authorization",
+		AllowMethods:  "GET,POST,PUT,DELETE,OPTIONS",
+		ExposeHeaders: "X-Next-Cursor, X-Prev-Cursor",
 	}))
 
 	app.Get("/", func(c *fiber.Ctx) error {
@@ -40,8 +45,14 @@ func main() {
 	routes.RegisterChatRoutes(app)
 	routes.RegisterEducationRoutes(app)
 	routes.RegisterTransactionRoutes(app)
+	routes.RegisterSubscriptionRoutes(app)
+	routes.RegisterAhliRoutes(app)
+	routes.RegisterAdminRoutes(app)
 
 	controllers.StartMessageDispatcher()
+	controllers.StartTransactionReconciler()
+	controllers.StartSubscriptionJobs()
+	controllers.StartRoomJobs()
 
 	log.Fatal(app.Listen(":8000"))
 }
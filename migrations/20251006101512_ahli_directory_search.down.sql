@@ -0,0 +1,7 @@
+DROP INDEX IF EXISTS idx_users_role_gender;
+DROP INDEX IF EXISTS idx_ahli_languages;
+DROP INDEX IF EXISTS idx_ahli_rating;
+DROP INDEX IF EXISTS idx_ahli_price;
+DROP INDEX IF EXISTS idx_ahli_category;
+DROP TABLE IF EXISTS ahli_availability;
+ALTER TABLE ahli DROP COLUMN IF EXISTS languages;
@@ -0,0 +1,18 @@
+ALTER TABLE ahli ADD COLUMN languages TEXT[] NOT NULL DEFAULT ARRAY['id'];
+
+CREATE TABLE ahli_availability (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    ahli_id UUID NOT NULL,
+    day_of_week SMALLINT NOT NULL CHECK (day_of_week BETWEEN 0 AND 6),
+    start_time TIME NOT NULL,
+    end_time TIME NOT NULL,
+    CHECK (end_time > start_time),
+    FOREIGN KEY (ahli_id) REFERENCES ahli(uid) ON DELETE CASCADE
+);
+
+CREATE INDEX idx_ahli_availability_day ON ahli_availability (day_of_week, ahli_id);
+CREATE INDEX idx_ahli_category ON ahli (category);
+CREATE INDEX idx_ahli_price ON ahli (price, uid);
+CREATE INDEX idx_ahli_rating ON ahli (rating DESC, uid);
+CREATE INDEX idx_ahli_languages ON ahli USING GIN (languages);
+CREATE INDEX idx_users_role_gender ON users (user_role, gender);
@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS idx_rooms_target;
+DROP INDEX IF EXISTS idx_transactions_ahli_status;
+DROP TABLE IF EXISTS bookings;
@@ -0,0 +1,22 @@
+CREATE TABLE bookings (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    user_id UUID NOT NULL,
+    ahli_id UUID NOT NULL,
+    transaction_id UUID,
+    start_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    end_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    price BIGINT NOT NULL DEFAULT 0,
+    status VARCHAR(20) NOT NULL DEFAULT 'pending' CHECK (status IN ('pending', 'confirmed', 'cancelled', 'completed')),
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT now(),
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT now(),
+    CHECK (end_at > start_at),
+    FOREIGN KEY (user_id) REFERENCES users(uid) ON DELETE CASCADE,
+    FOREIGN KEY (ahli_id) REFERENCES users(uid) ON DELETE CASCADE,
+    FOREIGN KEY (transaction_id) REFERENCES transactions(id) ON DELETE SET NULL
+);
+
+CREATE INDEX idx_bookings_ahli_start ON bookings (ahli_id, start_at);
+CREATE INDEX idx_bookings_user_start ON bookings (user_id, start_at);
+CREATE UNIQUE INDEX idx_bookings_transaction ON bookings (transaction_id) WHERE transaction_id IS NOT NULL;
+CREATE INDEX idx_transactions_ahli_status ON transactions (ahli_id, status, created_at);
+CREATE INDEX idx_rooms_target ON rooms (target_id);
@@ -0,0 +1,34 @@
+CREATE TABLE payouts (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    batch_id UUID NOT NULL,
+    ahli_id UUID NOT NULL,
+    amount BIGINT NOT NULL CHECK (amount > 0),
+    entries INT NOT NULL DEFAULT 0,
+    status VARCHAR(20) NOT NULL DEFAULT 'paid',
+    created_by UUID,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT now(),
+    FOREIGN KEY (ahli_id) REFERENCES users(uid) ON DELETE RESTRICT,
+    FOREIGN KEY (created_by) REFERENCES users(uid) ON DELETE SET NULL
+);
+
+CREATE TABLE ledger_entries (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    journal_id UUID NOT NULL,
+    entry_type VARCHAR(20) NOT NULL,
+    transaction_id UUID,
+    payout_id UUID,
+    ahli_id UUID,
+    account VARCHAR(30) NOT NULL CHECK (account IN ('user_payment', 'platform_commission', 'ahli_earnings', 'ahli_payout')),
+    debit BIGINT NOT NULL DEFAULT 0 CHECK (debit >= 0),
+    credit BIGINT NOT NULL DEFAULT 0 CHECK (credit >= 0),
+    status VARCHAR(20) NOT NULL DEFAULT 'posted' CHECK (status IN ('posted', 'pending', 'paid')),
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT now(),
+    FOREIGN KEY (transaction_id) REFERENCES transactions(id) ON DELETE SET NULL,
+    FOREIGN KEY (payout_id) REFERENCES payouts(id) ON DELETE RESTRICT,
+    FOREIGN KEY (ahli_id) REFERENCES users(uid) ON DELETE SET NULL
+);
+
+CREATE UNIQUE INDEX idx_ledger_payment_once ON ledger_entries (transaction_id, account) WHERE entry_type = 'payment';
+CREATE INDEX idx_ledger_ahli_account ON ledger_entries (ahli_id, account, status);
+CREATE INDEX idx_ledger_journal ON ledger_entries (journal_id);
+CREATE INDEX idx_payouts_ahli ON payouts (ahli_id, created_at);
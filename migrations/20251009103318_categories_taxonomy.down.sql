@@ -0,0 +1,5 @@
+DROP TABLE IF EXISTS ahli_specializations;
+DROP TABLE IF EXISTS categories;
+
+ALTER TABLE ahli ALTER COLUMN category SET DEFAULT 'ahli agama';
+UPDATE ahli SET category = 'ahli agama' WHERE category = 'agama';
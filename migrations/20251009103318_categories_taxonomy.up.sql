@@ -0,0 +1,48 @@
+CREATE TABLE categories (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    slug VARCHAR(100) NOT NULL UNIQUE,
+    name VARCHAR(100) NOT NULL,
+    description TEXT,
+    scopes TEXT[] NOT NULL DEFAULT ARRAY['ahli', 'room'],
+    active BOOLEAN NOT NULL DEFAULT TRUE,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT now(),
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT now()
+);
+
+CREATE TABLE ahli_specializations (
+    ahli_id UUID NOT NULL,
+    category_id UUID NOT NULL,
+    PRIMARY KEY (ahli_id, category_id),
+    FOREIGN KEY (ahli_id) REFERENCES ahli(uid) ON DELETE CASCADE,
+    FOREIGN KEY (category_id) REFERENCES categories(id) ON DELETE CASCADE
+);
+
+CREATE INDEX idx_ahli_specializations_category ON ahli_specializations (category_id);
+
+INSERT INTO categories (slug, name, scopes) VALUES
+    ('agama', 'Ahli Agama', ARRAY['ahli', 'room']),
+    ('psikolog', 'Psikolog', ARRAY['ahli', 'room']),
+    ('konselor', 'Konselor', ARRAY['ahli', 'room']);
+
+UPDATE ahli SET category = 'agama' WHERE category = 'ahli agama';
+ALTER TABLE ahli ALTER COLUMN category SET DEFAULT 'agama';
+
+-- keep every free-text value already stored valid for the place it is used
+INSERT INTO categories (slug, name, scopes)
+SELECT DISTINCT category, category, ARRAY['ahli'] FROM ahli WHERE category IS NOT NULL
+ON CONFLICT (slug) DO UPDATE SET scopes = ARRAY(SELECT DISTINCT unnest(categories.scopes || EXCLUDED.scopes));
+
+INSERT INTO categories (slug, name, scopes)
+SELECT DISTINCT category, category, ARRAY['room'] FROM rooms WHERE category <> ''
+ON CONFLICT (slug) DO UPDATE SET scopes = ARRAY(SELECT DISTINCT unnest(categories.scopes || EXCLUDED.scopes));
+
+INSERT INTO categories (slug, name, scopes)
+SELECT DISTINCT c, c, ARRAY['goal'] FROM (
+    SELECT category AS c FROM missions
+    UNION SELECT goal_category FROM user_goals
+) g WHERE c <> ''
+ON CONFLICT (slug) DO UPDATE SET scopes = ARRAY(SELECT DISTINCT unnest(categories.scopes || EXCLUDED.scopes));
+
+INSERT INTO ahli_specializations (ahli_id, category_id)
+SELECT a.uid, c.id FROM ahli a JOIN categories c ON c.slug = a.category
+ON CONFLICT DO NOTHING;
@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS ahli_price_history;
+ALTER TABLE ahli DROP COLUMN IF EXISTS updated_at, DROP COLUMN IF EXISTS bio;
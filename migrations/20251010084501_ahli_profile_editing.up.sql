@@ -0,0 +1,15 @@
+ALTER TABLE ahli
+    ADD COLUMN bio TEXT,
+    ADD COLUMN updated_at TIMESTAMP WITH TIME ZONE DEFAULT now();
+
+CREATE TABLE ahli_price_history (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    ahli_id UUID NOT NULL,
+    old_price DECIMAL(10,2),
+    new_price DECIMAL(10,2) NOT NULL,
+    changed_by UUID NOT NULL,
+    changed_at TIMESTAMP WITH TIME ZONE DEFAULT now(),
+    FOREIGN KEY (ahli_id) REFERENCES ahli(uid) ON DELETE CASCADE
+);
+
+CREATE INDEX idx_ahli_price_history_ahli ON ahli_price_history (ahli_id, changed_at DESC);
@@ -0,0 +1,13 @@
+CREATE TABLE payment_notifications (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    order_id VARCHAR(100) NOT NULL DEFAULT '',
+    transaction_status VARCHAR(30) NOT NULL DEFAULT '',
+    signature_valid BOOLEAN NOT NULL DEFAULT FALSE,
+    payload JSONB NOT NULL,
+    outcome VARCHAR(30) NOT NULL DEFAULT 'received',
+    error TEXT,
+    received_at TIMESTAMP WITH TIME ZONE DEFAULT now(),
+    processed_at TIMESTAMP WITH TIME ZONE
+);
+
+CREATE INDEX idx_payment_notifications_order ON payment_notifications (order_id, received_at DESC);
@@ -0,0 +1,4 @@
+DROP INDEX IF EXISTS idx_ledger_refund_once;
+ALTER TABLE ledger_entries DROP COLUMN IF EXISTS refund_id;
+DROP TABLE IF EXISTS refund_requests;
+ALTER TABLE transactions DROP COLUMN IF EXISTS refunded_amount;
@@ -0,0 +1,28 @@
+ALTER TABLE transactions ADD COLUMN refunded_amount BIGINT NOT NULL DEFAULT 0;
+
+CREATE TABLE refund_requests (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    transaction_id UUID NOT NULL,
+    user_id UUID NOT NULL,
+    requested_by UUID,
+    amount BIGINT NOT NULL CHECK (amount > 0),
+    reason TEXT NOT NULL,
+    status VARCHAR(20) NOT NULL DEFAULT 'pending' CHECK (status IN ('pending', 'approved', 'rejected', 'completed', 'failed')),
+    decided_by UUID,
+    decided_at TIMESTAMP WITH TIME ZONE,
+    decision_note TEXT,
+    error TEXT,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT now(),
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT now(),
+    FOREIGN KEY (transaction_id) REFERENCES transactions(id) ON DELETE CASCADE,
+    FOREIGN KEY (user_id) REFERENCES users(uid) ON DELETE CASCADE,
+    FOREIGN KEY (requested_by) REFERENCES users(uid) ON DELETE SET NULL,
+    FOREIGN KEY (decided_by) REFERENCES users(uid) ON DELETE SET NULL
+);
+
+-- at most one open request per transaction
+CREATE UNIQUE INDEX idx_refund_requests_open ON refund_requests (transaction_id) WHERE status IN ('pending', 'approved');
+CREATE INDEX idx_refund_requests_status ON refund_requests (status, created_at);
+
+ALTER TABLE ledger_entries ADD COLUMN refund_id UUID REFERENCES refund_requests(id) ON DELETE SET NULL;
+CREATE UNIQUE INDEX idx_ledger_refund_once ON ledger_entries (refund_id, account) WHERE entry_type = 'refund';
@@ -0,0 +1 @@
+ALTER TABLE transactions ADD COLUMN snap_token VARCHAR(100);
@@ -0,0 +1,5 @@
+ALTER TABLE bookings DROP COLUMN IF EXISTS subscription_id;
+ALTER TABLE transactions DROP COLUMN IF EXISTS subscription_id;
+ALTER TABLE transactions DROP COLUMN IF EXISTS kind;
+DROP TABLE IF EXISTS subscriptions;
+DROP TABLE IF EXISTS session_packages;
@@ -0,0 +1,44 @@
+CREATE TABLE session_packages (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    ahli_id UUID NOT NULL,
+    name VARCHAR(100) NOT NULL,
+    description TEXT NOT NULL DEFAULT '',
+    sessions INT NOT NULL CHECK (sessions > 0),
+    price BIGINT NOT NULL CHECK (price > 0),
+    period_days INT NOT NULL DEFAULT 30 CHECK (period_days > 0),
+    recurring BOOLEAN NOT NULL DEFAULT FALSE,
+    active BOOLEAN NOT NULL DEFAULT TRUE,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT now(),
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT now(),
+    FOREIGN KEY (ahli_id) REFERENCES users(uid) ON DELETE CASCADE
+);
+
+CREATE INDEX idx_session_packages_ahli ON session_packages (ahli_id) WHERE active;
+
+CREATE TABLE subscriptions (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    user_id UUID NOT NULL,
+    ahli_id UUID NOT NULL,
+    package_id UUID NOT NULL,
+    status VARCHAR(20) NOT NULL DEFAULT 'pending' CHECK (status IN ('pending', 'active', 'expired', 'cancelled')),
+    auto_renew BOOLEAN NOT NULL DEFAULT FALSE,
+    credits_total INT NOT NULL DEFAULT 0,
+    credits_remaining INT NOT NULL DEFAULT 0 CHECK (credits_remaining >= 0),
+    current_period_start TIMESTAMP WITH TIME ZONE,
+    current_period_end TIMESTAMP WITH TIME ZONE,
+    renewal_transaction_id UUID,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT now(),
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT now(),
+    FOREIGN KEY (user_id) REFERENCES users(uid) ON DELETE CASCADE,
+    FOREIGN KEY (ahli_id) REFERENCES users(uid) ON DELETE CASCADE,
+    FOREIGN KEY (package_id) REFERENCES session_packages(id) ON DELETE RESTRICT
+);
+
+CREATE INDEX idx_subscriptions_user ON subscriptions (user_id, status);
+CREATE INDEX idx_subscriptions_period_end ON subscriptions (current_period_end) WHERE status = 'active';
+
+ALTER TABLE transactions ADD COLUMN kind VARCHAR(20) NOT NULL DEFAULT 'consultation';
+ALTER TABLE transactions ADD COLUMN subscription_id UUID REFERENCES subscriptions(id) ON DELETE SET NULL;
+ALTER TABLE subscriptions ADD FOREIGN KEY (renewal_transaction_id) REFERENCES transactions(id) ON DELETE SET NULL;
+
+ALTER TABLE bookings ADD COLUMN subscription_id UUID REFERENCES subscriptions(id) ON DELETE SET NULL;
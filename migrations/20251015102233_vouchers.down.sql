@@ -0,0 +1,4 @@
+DROP TABLE IF EXISTS voucher_redemptions;
+ALTER TABLE transactions DROP COLUMN IF EXISTS discount_amount;
+ALTER TABLE transactions DROP COLUMN IF EXISTS voucher_id;
+DROP TABLE IF EXISTS vouchers;
@@ -0,0 +1,37 @@
+CREATE TABLE vouchers (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    code VARCHAR(40) NOT NULL UNIQUE,
+    description TEXT NOT NULL DEFAULT '',
+    discount_type VARCHAR(10) NOT NULL CHECK (discount_type IN ('percent', 'fixed')),
+    discount_value BIGINT NOT NULL CHECK (discount_value > 0),
+    max_discount BIGINT,
+    min_amount BIGINT NOT NULL DEFAULT 0,
+    max_uses INT,
+    max_uses_per_user INT NOT NULL DEFAULT 1,
+    used_count INT NOT NULL DEFAULT 0,
+    starts_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT now(),
+    ends_at TIMESTAMP WITH TIME ZONE,
+    ahli_id UUID REFERENCES users(uid) ON DELETE CASCADE,
+    category VARCHAR(50),
+    active BOOLEAN NOT NULL DEFAULT TRUE,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT now(),
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT now(),
+    CHECK (discount_type <> 'percent' OR discount_value <= 100)
+);
+
+ALTER TABLE transactions ADD COLUMN voucher_id UUID REFERENCES vouchers(id) ON DELETE SET NULL;
+ALTER TABLE transactions ADD COLUMN discount_amount BIGINT NOT NULL DEFAULT 0;
+
+-- reserved while the payment is pending, redeemed once paid, released if the payment fails
+CREATE TABLE voucher_redemptions (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    voucher_id UUID NOT NULL REFERENCES vouchers(id) ON DELETE CASCADE,
+    user_id UUID NOT NULL REFERENCES users(uid) ON DELETE CASCADE,
+    transaction_id UUID NOT NULL UNIQUE REFERENCES transactions(id) ON DELETE CASCADE,
+    discount BIGINT NOT NULL,
+    status VARCHAR(20) NOT NULL DEFAULT 'reserved' CHECK (status IN ('reserved', 'redeemed', 'released')),
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT now(),
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT now()
+);
+
+CREATE INDEX idx_voucher_redemptions_voucher_user ON voucher_redemptions (voucher_id, user_id) WHERE status <> 'released';
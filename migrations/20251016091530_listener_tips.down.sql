@@ -0,0 +1,4 @@
+DROP INDEX IF EXISTS idx_transactions_kind_recipient;
+ALTER TABLE transactions DROP COLUMN IF EXISTS room_id;
+ALTER TABLE rooms DROP COLUMN IF EXISTS listener_id;
+ALTER TABLE users DROP COLUMN IF EXISTS accepts_tips;
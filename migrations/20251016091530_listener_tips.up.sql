@@ -0,0 +1,8 @@
+ALTER TABLE users ADD COLUMN accepts_tips BOOLEAN NOT NULL DEFAULT false;
+
+-- the pendengar of a matched room, the only member who can receive a tip for it
+ALTER TABLE rooms ADD COLUMN listener_id UUID REFERENCES users(uid) ON DELETE SET NULL;
+
+ALTER TABLE transactions ADD COLUMN room_id UUID REFERENCES rooms(id) ON DELETE SET NULL;
+
+CREATE INDEX idx_transactions_kind_recipient ON transactions (kind, ahli_id, status, created_at);
@@ -0,0 +1 @@
+CREATE INDEX idx_messages_room_created ON messages (room_id, created_at, id);
@@ -0,0 +1,22 @@
+ALTER TABLE rooms ADD COLUMN is_group BOOLEAN NOT NULL DEFAULT false;
+
+CREATE TABLE room_members (
+    room_id UUID NOT NULL,
+    user_id UUID NOT NULL,
+    role VARCHAR(20) NOT NULL DEFAULT 'member' CHECK (role IN ('owner', 'moderator', 'member')),
+    invited_by UUID,
+    joined_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (room_id, user_id),
+    FOREIGN KEY (room_id) REFERENCES rooms(id) ON DELETE CASCADE,
+    FOREIGN KEY (user_id) REFERENCES users(uid) ON DELETE CASCADE,
+    FOREIGN KEY (invited_by) REFERENCES users(uid) ON DELETE SET NULL
+);
+
+CREATE INDEX idx_room_members_user ON room_members (user_id);
+
+INSERT INTO room_members (room_id, user_id, role, joined_at)
+SELECT id, owner_id, 'owner', created_at FROM rooms;
+
+INSERT INTO room_members (room_id, user_id, role, joined_at)
+SELECT id, target_id, 'member', created_at FROM rooms WHERE target_id IS NOT NULL
+ON CONFLICT DO NOTHING;
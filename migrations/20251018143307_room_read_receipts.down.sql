@@ -0,0 +1,2 @@
+ALTER TABLE room_members DROP COLUMN IF EXISTS last_read_at;
+ALTER TABLE room_members DROP COLUMN IF EXISTS last_read_message_id;
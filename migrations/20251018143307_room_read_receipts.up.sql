@@ -0,0 +1,2 @@
+ALTER TABLE room_members ADD COLUMN last_read_message_id UUID REFERENCES messages(id) ON DELETE SET NULL;
+ALTER TABLE room_members ADD COLUMN last_read_at TIMESTAMP;
@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS message_audits;
+ALTER TABLE messages DROP COLUMN IF EXISTS deleted_at;
+ALTER TABLE messages DROP COLUMN IF EXISTS edited_at;
@@ -0,0 +1,19 @@
+ALTER TABLE messages ADD COLUMN edited_at TIMESTAMP;
+ALTER TABLE messages ADD COLUMN deleted_at TIMESTAMP;
+
+-- original text of edited and deleted messages, kept for moderation
+CREATE TABLE message_audits (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    message_id UUID NOT NULL,
+    room_id UUID NOT NULL,
+    actor_id UUID,
+    action VARCHAR(20) NOT NULL CHECK (action IN ('edit', 'delete')),
+    old_text TEXT NOT NULL,
+    new_text TEXT,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    FOREIGN KEY (message_id) REFERENCES messages(id) ON DELETE CASCADE,
+    FOREIGN KEY (room_id) REFERENCES rooms(id) ON DELETE CASCADE,
+    FOREIGN KEY (actor_id) REFERENCES users(uid) ON DELETE SET NULL
+);
+
+CREATE INDEX idx_message_audits_message ON message_audits (message_id, created_at);
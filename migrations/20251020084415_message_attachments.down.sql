@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS message_attachments;
+ALTER TABLE messages DROP COLUMN IF EXISTS type;
@@ -0,0 +1,17 @@
+ALTER TABLE messages ADD COLUMN type VARCHAR(10) NOT NULL DEFAULT 'text' CHECK (type IN ('text', 'image', 'audio', 'file'));
+
+CREATE TABLE message_attachments (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    message_id UUID NOT NULL,
+    storage_key TEXT NOT NULL,
+    thumbnail_key TEXT,
+    file_name TEXT NOT NULL,
+    mime_type VARCHAR(100) NOT NULL,
+    size_bytes BIGINT NOT NULL,
+    width INT,
+    height INT,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    FOREIGN KEY (message_id) REFERENCES messages(id) ON DELETE CASCADE
+);
+
+CREATE INDEX idx_message_attachments_message ON message_attachments (message_id);
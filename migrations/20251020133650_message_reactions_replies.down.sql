@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS message_reactions;
+ALTER TABLE messages DROP COLUMN IF EXISTS reply_to_message_id;
@@ -0,0 +1,15 @@
+-- the indonesian snowball stemmer ships with Postgres 12+; older servers fall back to simple
+DO $$
+BEGIN
+    IF EXISTS (SELECT 1 FROM pg_ts_config WHERE cfgname = 'indonesian') THEN
+        CREATE TEXT SEARCH CONFIGURATION chat_search (COPY = indonesian);
+    ELSE
+        CREATE TEXT SEARCH CONFIGURATION chat_search (COPY = simple);
+    END IF;
+END
+$$;
+
+ALTER TABLE messages ADD COLUMN search_vector TSVECTOR
+    GENERATED ALWAYS AS (to_tsvector('chat_search', COALESCE(text, ''))) STORED;
+
+CREATE INDEX idx_messages_search ON messages USING GIN (search_vector);
@@ -0,0 +1,9 @@
+DROP INDEX IF EXISTS idx_rooms_booking;
+DROP INDEX IF EXISTS idx_rooms_active;
+ALTER TABLE rooms
+    DROP CONSTRAINT IF EXISTS rooms_status_check,
+    DROP COLUMN IF EXISTS booking_id,
+    DROP COLUMN IF EXISTS end_reason,
+    DROP COLUMN IF EXISTS ended_by,
+    DROP COLUMN IF EXISTS ended_at,
+    DROP COLUMN IF EXISTS status;
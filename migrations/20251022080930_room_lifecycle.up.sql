@@ -0,0 +1,10 @@
+ALTER TABLE rooms
+    ADD COLUMN status VARCHAR(16) NOT NULL DEFAULT 'active',
+    ADD COLUMN ended_at TIMESTAMP,
+    ADD COLUMN ended_by UUID REFERENCES users(uid) ON DELETE SET NULL,
+    ADD COLUMN end_reason VARCHAR(32),
+    ADD COLUMN booking_id UUID REFERENCES bookings(id) ON DELETE SET NULL,
+    ADD CONSTRAINT rooms_status_check CHECK (status IN ('active', 'ended', 'archived'));
+
+CREATE INDEX idx_rooms_active ON rooms (created_at) WHERE status = 'active';
+CREATE INDEX idx_rooms_booking ON rooms (booking_id) WHERE booking_id IS NOT NULL;
@@ -0,0 +1,6 @@
+CREATE EXTENSION IF NOT EXISTS btree_gist;
+
+-- live bookings of one ahli may never overlap, even when two requests race past the application check
+ALTER TABLE bookings ADD CONSTRAINT bookings_no_overlap
+    EXCLUDE USING gist (ahli_id WITH =, tstzrange(start_at, end_at) WITH &&)
+    WHERE (status IN ('pending', 'confirmed'));
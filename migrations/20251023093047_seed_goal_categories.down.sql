@@ -0,0 +1,4 @@
+DELETE FROM categories c
+WHERE c.slug IN ('spiritual', 'kesehatan-mental', 'pengembangan-diri') AND c.scopes = ARRAY['goal']
+AND NOT EXISTS (SELECT 1 FROM missions m WHERE m.category = c.slug)
+AND NOT EXISTS (SELECT 1 FROM user_goals g WHERE g.goal_category = c.slug);
@@ -0,0 +1,7 @@
+-- goal categories used to exist only where missions or user goals already named them,
+-- so a fresh database rejected every goal and mission until an admin added some
+INSERT INTO categories (slug, name, scopes) VALUES
+    ('spiritual', 'Spiritual', ARRAY['goal']),
+    ('kesehatan-mental', 'Kesehatan Mental', ARRAY['goal']),
+    ('pengembangan-diri', 'Pengembangan Diri', ARRAY['goal'])
+ON CONFLICT (slug) DO UPDATE SET scopes = ARRAY(SELECT DISTINCT unnest(categories.scopes || EXCLUDED.scopes));
@@ -0,0 +1,7 @@
+DROP INDEX idx_refund_requests_open;
+CREATE UNIQUE INDEX idx_refund_requests_open ON refund_requests (transaction_id) WHERE status IN ('pending', 'approved');
+
+UPDATE refund_requests SET status = 'failed' WHERE status = 'provider_refunded';
+ALTER TABLE refund_requests DROP CONSTRAINT refund_requests_status_check;
+ALTER TABLE refund_requests ADD CONSTRAINT refund_requests_status_check
+    CHECK (status IN ('pending', 'approved', 'rejected', 'completed', 'failed'));
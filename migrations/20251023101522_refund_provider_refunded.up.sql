@@ -0,0 +1,7 @@
+-- provider_refunded: the gateway returned the money but booking it locally failed; it is retried
+ALTER TABLE refund_requests DROP CONSTRAINT refund_requests_status_check;
+ALTER TABLE refund_requests ADD CONSTRAINT refund_requests_status_check
+    CHECK (status IN ('pending', 'approved', 'rejected', 'provider_refunded', 'completed', 'failed'));
+
+DROP INDEX idx_refund_requests_open;
+CREATE UNIQUE INDEX idx_refund_requests_open ON refund_requests (transaction_id) WHERE status IN ('pending', 'approved', 'provider_refunded');
@@ -0,0 +1,3 @@
+ALTER TABLE subscriptions
+    DROP COLUMN IF EXISTS next_period_end,
+    DROP COLUMN IF EXISTS next_period_credits;
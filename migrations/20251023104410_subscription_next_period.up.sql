@@ -0,0 +1,5 @@
+-- a renewal paid before the current period ends is held here until the period rolls over,
+-- so the credits left in the current period stay usable
+ALTER TABLE subscriptions
+    ADD COLUMN next_period_credits INT,
+    ADD COLUMN next_period_end TIMESTAMP WITH TIME ZONE;
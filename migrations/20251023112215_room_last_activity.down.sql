@@ -0,0 +1 @@
+ALTER TABLE rooms DROP COLUMN IF EXISTS last_activity_at;
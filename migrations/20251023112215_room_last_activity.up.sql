@@ -0,0 +1,8 @@
+-- idle rooms are closed by last activity, which also covers chats only routed over websocket
+ALTER TABLE rooms ADD COLUMN last_activity_at TIMESTAMP;
+
+UPDATE rooms r SET last_activity_at = COALESCE((SELECT max(m.created_at) FROM messages m WHERE m.room_id = r.id), r.created_at, NOW());
+
+ALTER TABLE rooms
+    ALTER COLUMN last_activity_at SET DEFAULT NOW(),
+    ALTER COLUMN last_activity_at SET NOT NULL;
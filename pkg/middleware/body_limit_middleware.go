@@ -0,0 +1,23 @@
+package middleware
+
+import (
+	"github.com/gofiber/fiber/v2"
+)
+
+// BodyLimit rejects requests whose body is larger than limit, except on the given paths.
+// It lets the server-wide limit stay high enough for uploads while every other route keeps a small one.
+func BodyLimit(limit int, exceptPaths ...string) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		for _, p := range exceptPaths {
+			if c.Path() == p {
+				return c.Next()
+			}
+		}
+		if len(c.Request().Body()) > limit {
+			return c.Status(fiber.StatusRequestEntityTooLarge).JSON(fiber.Map{
+				"error": "Request body too large",
+			})
+		}
+		return c.Next()
+	}
+}
@@ -0,0 +1,147 @@
+package payment
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/google/uuid"
+)
+
+const fakeServerKey = "fake-server-key"
+
+// Fake is an in-process provider for local development and tests. It never touches
+// the network and delivers Midtrans shaped notifications through Callback when a
+// payment outcome is simulated.
+type Fake struct {
+	mu      sync.Mutex
+	charges map[string]*fakeCharge
+
+	// Callback receives the raw notification body produced by Simulate
+	Callback func(body []byte)
+}
+
+type fakeCharge struct {
+	amount   int64
+	status   string
+	refunded int64
+}
+
+func NewFake() *Fake {
+	return &Fake{charges: map[string]*fakeCharge{}}
+}
+
+func (f *Fake) Name() string {
+	return "fake"
+}
+
+func (f *Fake) CreateCharge(ctx context.Context, req ChargeRequest) (*Charge, error) {
+	if req.OrderID == "" || req.Amount <= 0 {
+		return nil, errors.New("order id and positive amount are required")
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.charges[req.OrderID] = &fakeCharge{amount: req.Amount, status: "pending"}
+	token := uuid.NewString()
+	return &Charge{OrderID: req.OrderID, Token: token, PaymentURL: "/payments/fake/" + req.OrderID}, nil
+}
+
+func (f *Fake) QueryStatus(ctx context.Context, orderID string) (*StatusResult, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	ch, ok := f.charges[orderID]
+	if !ok {
+		return nil, errors.New("order not found")
+	}
+	return &StatusResult{OrderID: orderID, TransactionStatus: ch.status, Status: MidtransStatus(ch.status, ""), GrossAmount: fakeAmount(ch.amount)}, nil
+}
+
+func (f *Fake) Refund(ctx context.Context, orderID, refundKey string, amount int64, reason string) (*Refund, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	ch, ok := f.charges[orderID]
+	if !ok {
+		return nil, errors.New("order not found")
+	}
+	if ch.status != "settlement" {
+		return nil, errors.New("only settled payments can be refunded")
+	}
+	if amount <= 0 || ch.refunded+amount > ch.amount {
+		return nil, errors.New("refund amount exceeds the paid amount")
+	}
+	ch.refunded += amount
+	return &Refund{OrderID: orderID, RefundKey: refundKey, Amount: amount}, nil
+}
+
+func (f *Fake) Expire(ctx context.Context, orderID string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	ch, ok := f.charges[orderID]
+	if !ok {
+		return errors.New("order not found")
+	}
+	if MidtransStatus(ch.status, "") != StatusPending {
+		return fmt.Errorf("payment is already %s", ch.status)
+	}
+	ch.status = "expire"
+	return nil
+}
+
+func (f *Fake) VerifyWebhook(body []byte) (*Notification, error) {
+	return verifyMidtransPayload(body, fakeServerKey)
+}
+
+// Simulate moves a charge to a Midtrans transaction_status such as settlement, expire
+// or deny, and delivers the signed notification to Callback. It returns the body sent.
+func (f *Fake) Simulate(orderID, txStatus string) ([]byte, error) {
+	if MidtransStatus(txStatus, "") == "" {
+		return nil, fmt.Errorf("unsupported transaction_status %q", txStatus)
+	}
+
+	f.mu.Lock()
+	ch, ok := f.charges[orderID]
+	if !ok {
+		f.mu.Unlock()
+		return nil, errors.New("order not found")
+	}
+	ch.status = txStatus
+	amount := fakeAmount(ch.amount)
+	callback := f.Callback
+	f.mu.Unlock()
+
+	statusCode := "200"
+	if MidtransStatus(txStatus, "") == StatusPending {
+		statusCode = "201"
+	} else if MidtransStatus(txStatus, "") != StatusCompleted {
+		statusCode = "202"
+	}
+	body, err := json.Marshal(map[string]string{
+		"order_id":           orderID,
+		"status_code":        statusCode,
+		"gross_amount":       amount,
+		"transaction_status": txStatus,
+		"signature_key":      midtransSignature(orderID, statusCode, amount, fakeServerKey),
+	})
+	if err != nil {
+		return nil, err
+	}
+	if callback != nil {
+		callback(body)
+	}
+	return body, nil
+}
+
+// Track registers a charge created before the process started, so it can still be simulated
+func (f *Fake) Track(orderID string, amount int64) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if _, ok := f.charges[orderID]; !ok {
+		f.charges[orderID] = &fakeCharge{amount: amount, status: "pending"}
+	}
+}
+
+func fakeAmount(amount int64) string {
+	return fmt.Sprintf("%d.00", amount)
+}
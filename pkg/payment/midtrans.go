@@ -0,0 +1,318 @@
+package payment
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha512"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	midtransSandboxSnapURL    = "https://app.sandbox.midtrans.com/snap/v1/transactions"
+	midtransProductionSnapURL = "https://app.midtrans.com/snap/v1/transactions"
+	midtransSandboxAPIURL     = "https://api.sandbox.midtrans.com/v2"
+	midtransProductionAPIURL  = "https://api.midtrans.com/v2"
+)
+
+// Midtrans talks to the Midtrans Snap and Core APIs
+type Midtrans struct {
+	ServerKey  string
+	SnapURL    string
+	APIURL     string
+	HTTPClient *http.Client
+
+	// EnabledPayments limits the payment methods offered by Snap; empty offers all active methods
+	EnabledPayments []string
+	// ExpiryMinutes sets how long a Snap payment stays payable; zero keeps the Midtrans default
+	ExpiryMinutes int
+	FinishURL     string
+	ErrorURL      string
+}
+
+// NewMidtrans creates a Midtrans provider for the sandbox or production environment
+func NewMidtrans(serverKey string, production bool) *Midtrans {
+	m := &Midtrans{ServerKey: serverKey, SnapURL: midtransSandboxSnapURL, APIURL: midtransSandboxAPIURL, HTTPClient: &http.Client{Timeout: 15 * time.Second}}
+	if production {
+		m.SnapURL = midtransProductionSnapURL
+		m.APIURL = midtransProductionAPIURL
+	}
+	return m
+}
+
+// NewMidtransFromEnv reads MIDTRANS_SERVER_KEY, MIDTRANS_ENV (sandbox or production),
+// MIDTRANS_ENABLED_PAYMENTS (comma separated), MIDTRANS_EXPIRY_MINUTES, MIDTRANS_FINISH_URL and MIDTRANS_ERROR_URL
+func NewMidtransFromEnv() *Midtrans {
+	m := NewMidtrans(os.Getenv("MIDTRANS_SERVER_KEY"), os.Getenv("MIDTRANS_ENV") == "production")
+	for _, p := range strings.Split(os.Getenv("MIDTRANS_ENABLED_PAYMENTS"), ",") {
+		if p = strings.TrimSpace(p); p != "" {
+			m.EnabledPayments = append(m.EnabledPayments, p)
+		}
+	}
+	if v, err := strconv.Atoi(os.Getenv("MIDTRANS_EXPIRY_MINUTES")); err == nil && v > 0 {
+		m.ExpiryMinutes = v
+	}
+	m.FinishURL = os.Getenv("MIDTRANS_FINISH_URL")
+	m.ErrorURL = os.Getenv("MIDTRANS_ERROR_URL")
+	return m
+}
+
+func (m *Midtrans) Name() string {
+	return "midtrans"
+}
+
+func (m *Midtrans) do(ctx context.Context, method, url string, body interface{}, out interface{}) error {
+	if m.ServerKey == "" {
+		return errors.New("midtrans server key not set")
+	}
+
+	var reader *bytes.Reader
+	if body != nil {
+		b, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(b)
+	} else {
+		reader = bytes.NewReader(nil)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, url, reader)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/json")
+	auth := base64.StdEncoding.EncodeToString([]byte(m.ServerKey + ":"))
+	req.Header.Set("Authorization", "Basic "+auth)
+
+	res, err := m.HTTPClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode >= 400 {
+		return fmt.Errorf("midtrans returned status %d", res.StatusCode)
+	}
+	return json.NewDecoder(res.Body).Decode(out)
+}
+
+// snapPayload builds the Snap transaction request body
+func (m *Midtrans) snapPayload(req ChargeRequest) (map[string]interface{}, error) {
+	payload := map[string]interface{}{
+		"transaction_details": map[string]interface{}{
+			"order_id":     req.OrderID,
+			"gross_amount": req.Amount,
+		},
+	}
+
+	if c := req.Customer; c != nil {
+		customer := map[string]interface{}{"first_name": truncate(c.FirstName, 255)}
+		if c.LastName != "" {
+			customer["last_name"] = truncate(c.LastName, 255)
+		}
+		if c.Email != "" {
+			customer["email"] = c.Email
+		}
+		if c.Phone != "" {
+			customer["phone"] = c.Phone
+		}
+		payload["customer_details"] = customer
+	}
+
+	if len(req.Items) > 0 {
+		var total int64
+		items := make([]map[string]interface{}, 0, len(req.Items))
+		for _, it := range req.Items {
+			qty := it.Quantity
+			if qty <= 0 {
+				qty = 1
+			}
+			total += it.Price * int64(qty)
+			item := map[string]interface{}{"id": truncate(it.ID, 50), "name": truncate(it.Name, 50), "price": it.Price, "quantity": qty}
+			if it.Category != "" {
+				item["category"] = truncate(it.Category, 50)
+			}
+			if it.MerchantName != "" {
+				item["merchant_name"] = truncate(it.MerchantName, 50)
+			}
+			items = append(items, item)
+		}
+		// Midtrans rejects charges whose items do not add up to gross_amount
+		if total != req.Amount {
+			return nil, fmt.Errorf("item total %d does not match amount %d", total, req.Amount)
+		}
+		payload["item_details"] = items
+	}
+
+	if len(m.EnabledPayments) > 0 {
+		payload["enabled_payments"] = m.EnabledPayments
+	}
+	if m.ExpiryMinutes > 0 {
+		payload["expiry"] = map[string]interface{}{
+			"start_time": time.Now().Format("2006-01-02 15:04:05 -0700"),
+			"unit":       "minutes",
+			"duration":   m.ExpiryMinutes,
+		}
+	}
+	if m.FinishURL != "" || m.ErrorURL != "" {
+		callbacks := map[string]interface{}{}
+		if m.FinishURL != "" {
+			callbacks["finish"] = m.FinishURL
+		}
+		if m.ErrorURL != "" {
+			callbacks["error"] = m.ErrorURL
+		}
+		payload["callbacks"] = callbacks
+	}
+	return payload, nil
+}
+
+func truncate(s string, n int) string {
+	r := []rune(s)
+	if len(r) > n {
+		return string(r[:n])
+	}
+	return s
+}
+
+func (m *Midtrans) CreateCharge(ctx context.Context, req ChargeRequest) (*Charge, error) {
+	payload, err := m.snapPayload(req)
+	if err != nil {
+		return nil, err
+	}
+
+	var resp struct {
+		Token       string `json:"token"`
+		RedirectURL string `json:"redirect_url"`
+	}
+	if err := m.do(ctx, http.MethodPost, m.SnapURL, payload, &resp); err != nil {
+		return nil, err
+	}
+
+	charge := &Charge{OrderID: req.OrderID, Token: resp.Token, PaymentURL: resp.RedirectURL}
+	if charge.PaymentURL == "" && resp.Token != "" {
+		charge.PaymentURL = strings.TrimSuffix(m.SnapURL, "/v1/transactions") + "/v2/vtweb/" + resp.Token
+	}
+	if charge.PaymentURL == "" {
+		return nil, errors.New("no redirect_url returned from midtrans")
+	}
+	return charge, nil
+}
+
+func (m *Midtrans) QueryStatus(ctx context.Context, orderID string) (*StatusResult, error) {
+	var resp struct {
+		StatusCode        string `json:"status_code"`
+		StatusMessage     string `json:"status_message"`
+		OrderID           string `json:"order_id"`
+		TransactionStatus string `json:"transaction_status"`
+		FraudStatus       string `json:"fraud_status"`
+		GrossAmount       string `json:"gross_amount"`
+	}
+	if err := m.do(ctx, http.MethodGet, m.APIURL+"/"+orderID+"/status", nil, &resp); err != nil {
+		return nil, err
+	}
+	// the Core API answers 200 with status_code 404 for unknown orders
+	if resp.StatusCode == "404" {
+		return nil, errors.New("order not found at midtrans")
+	}
+	return &StatusResult{OrderID: orderID, TransactionStatus: resp.TransactionStatus, Status: MidtransStatus(resp.TransactionStatus, resp.FraudStatus), GrossAmount: resp.GrossAmount}, nil
+}
+
+func (m *Midtrans) Refund(ctx context.Context, orderID, refundKey string, amount int64, reason string) (*Refund, error) {
+	payload := map[string]interface{}{"refund_key": refundKey, "amount": amount, "reason": reason}
+	var resp struct {
+		StatusCode    string `json:"status_code"`
+		StatusMessage string `json:"status_message"`
+		RefundKey     string `json:"refund_key"`
+	}
+	if err := m.do(ctx, http.MethodPost, m.APIURL+"/"+orderID+"/refund", payload, &resp); err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != "200" && resp.StatusCode != "201" {
+		return nil, fmt.Errorf("midtrans refund rejected: %s", resp.StatusMessage)
+	}
+	return &Refund{OrderID: orderID, RefundKey: refundKey, Amount: amount}, nil
+}
+
+func (m *Midtrans) Expire(ctx context.Context, orderID string) error {
+	var resp struct {
+		StatusCode    string `json:"status_code"`
+		StatusMessage string `json:"status_message"`
+	}
+	if err := m.do(ctx, http.MethodPost, m.APIURL+"/"+orderID+"/expire", nil, &resp); err != nil {
+		return err
+	}
+	// midtrans answers a successful expiry with status_code 407
+	if resp.StatusCode != "200" && resp.StatusCode != "407" {
+		return fmt.Errorf("midtrans expire rejected: %s", resp.StatusMessage)
+	}
+	return nil
+}
+
+func (m *Midtrans) VerifyWebhook(body []byte) (*Notification, error) {
+	return verifyMidtransPayload(body, m.ServerKey)
+}
+
+// verifyMidtransPayload decodes a Midtrans style notification and checks its signature_key,
+// which is SHA512(order_id + status_code + gross_amount + server key) in hex.
+func verifyMidtransPayload(body []byte, serverKey string) (*Notification, error) {
+	var p struct {
+		OrderID           string `json:"order_id"`
+		StatusCode        string `json:"status_code"`
+		GrossAmount       string `json:"gross_amount"`
+		SignatureKey      string `json:"signature_key"`
+		TransactionStatus string `json:"transaction_status"`
+		FraudStatus       string `json:"fraud_status"`
+	}
+	if err := json.Unmarshal(body, &p); err != nil {
+		return nil, errors.New("payload is not a JSON object")
+	}
+	if p.OrderID == "" {
+		return nil, errors.New("missing order_id")
+	}
+	if serverKey == "" || p.SignatureKey == "" {
+		return nil, ErrInvalidSignature
+	}
+	expected := midtransSignature(p.OrderID, p.StatusCode, p.GrossAmount, serverKey)
+	if subtle.ConstantTimeCompare([]byte(expected), []byte(strings.ToLower(p.SignatureKey))) != 1 {
+		return nil, ErrInvalidSignature
+	}
+	return &Notification{OrderID: p.OrderID, TransactionStatus: p.TransactionStatus, FraudStatus: p.FraudStatus, Status: MidtransStatus(p.TransactionStatus, p.FraudStatus), GrossAmount: p.GrossAmount}, nil
+}
+
+func midtransSignature(orderID, statusCode, grossAmount, serverKey string) string {
+	sum := sha512.Sum512([]byte(orderID + statusCode + grossAmount + serverKey))
+	return hex.EncodeToString(sum[:])
+}
+
+// MidtransStatus maps a Midtrans transaction_status to a normalized status.
+// An empty result means the status carries no change for us.
+func MidtransStatus(txStatus, fraudStatus string) string {
+	switch txStatus {
+	case "capture":
+		if fraudStatus == "challenge" {
+			return StatusPending
+		}
+		return StatusCompleted
+	case "settlement":
+		return StatusCompleted
+	case "pending":
+		return StatusPending
+	case "deny", "cancel", "failure":
+		return StatusFailed
+	case "expire":
+		return StatusExpired
+	}
+	return ""
+}
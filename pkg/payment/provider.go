@@ -0,0 +1,94 @@
+package payment
+
+import (
+	"context"
+	"errors"
+	"os"
+)
+
+// Normalized payment statuses, matching the transaction statuses stored locally
+const (
+	StatusPending   = "pending"
+	StatusCompleted = "completed"
+	StatusFailed    = "failed"
+	StatusExpired   = "expired"
+)
+
+var ErrInvalidSignature = errors.New("invalid signature_key")
+
+// Customer is the payer shown on the gateway's payment page
+type Customer struct {
+	FirstName string
+	LastName  string
+	Email     string
+	Phone     string
+}
+
+// Item is one line of a charge; item prices times quantities must add up to the charge amount
+type Item struct {
+	ID           string
+	Name         string
+	Price        int64
+	Quantity     int
+	Category     string
+	MerchantName string
+}
+
+// ChargeRequest describes a payment to be created at the gateway
+type ChargeRequest struct {
+	OrderID  string
+	Amount   int64
+	Customer *Customer
+	Items    []Item
+}
+
+// Charge is a payment created at the gateway
+type Charge struct {
+	OrderID    string
+	Token      string
+	PaymentURL string
+}
+
+// StatusResult is the state of a payment as reported by the gateway
+type StatusResult struct {
+	OrderID           string
+	TransactionStatus string
+	Status            string
+	GrossAmount       string
+}
+
+// Refund is a refund accepted by the gateway
+type Refund struct {
+	OrderID   string
+	RefundKey string
+	Amount    int64
+}
+
+// Notification is a verified webhook payload
+type Notification struct {
+	OrderID           string
+	TransactionStatus string
+	FraudStatus       string
+	Status            string
+	GrossAmount       string
+}
+
+// Provider is a payment gateway
+type Provider interface {
+	Name() string
+	CreateCharge(ctx context.Context, req ChargeRequest) (*Charge, error)
+	QueryStatus(ctx context.Context, orderID string) (*StatusResult, error)
+	Refund(ctx context.Context, orderID, refundKey string, amount int64, reason string) (*Refund, error)
+	// Expire closes a payment that was not paid yet, so it can no longer be paid
+	Expire(ctx context.Context, orderID string) error
+	// VerifyWebhook checks the authenticity of a raw notification body and decodes it
+	VerifyWebhook(body []byte) (*Notification, error)
+}
+
+// FromEnv builds the provider selected by PAYMENT_PROVIDER (midtrans or fake, default midtrans)
+func FromEnv() Provider {
+	if os.Getenv("PAYMENT_PROVIDER") == "fake" {
+		return NewFake()
+	}
+	return NewMidtransFromEnv()
+}
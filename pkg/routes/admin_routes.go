@@ -0,0 +1,40 @@
+package routes
+
+import (
+	"github.com/gilanghuda/sobi-backend/app/controllers"
+	"github.com/gilanghuda/sobi-backend/pkg/middleware"
+	"github.com/gilanghuda/sobi-backend/pkg/utils"
+	"github.com/gofiber/fiber/v2"
+)
+
+func RegisterAdminRoutes(app *fiber.App) {
+	admin := app.Group("/admin", middleware.JWTProtected(), middleware.RoleRequired(utils.RoleAdmin))
+	admin.Post("/payouts", controllers.CreatePayoutBatch)
+	admin.Get("/payouts", controllers.GetPayouts)
+	admin.Get("/payouts/settlement", controllers.ExportSettlement)
+
+	admin.Get("/payments/notifications", controllers.GetPaymentNotifications)
+	admin.Post("/payments/notifications/:id/replay", controllers.ReplayPaymentNotification)
+	admin.Post("/payments/fake/:id/simulate", controllers.SimulatePayment)
+	admin.Get("/payments/reconcile", controllers.GetReconcileReport)
+	admin.Post("/payments/reconcile", controllers.RunReconcile)
+
+	admin.Get("/reports/revenue", controllers.GetRevenueReport)
+
+	admin.Get("/refunds", controllers.AdminGetRefunds)
+	admin.Post("/refunds/:id/approve", controllers.AdminApproveRefund)
+	admin.Post("/refunds/:id/reject", controllers.AdminRejectRefund)
+
+	admin.Get("/vouchers", controllers.AdminGetVouchers)
+	admin.Post("/vouchers", controllers.CreateVoucher)
+	admin.Put("/vouchers/:id", controllers.UpdateVoucher)
+	admin.Delete("/vouchers/:id", controllers.DeleteVoucher)
+	admin.Get("/vouchers/:id/redemptions", controllers.GetVoucherRedemptions)
+
+	admin.Get("/messages/:id/audits", controllers.GetMessageAudits)
+
+	admin.Get("/categories", controllers.AdminGetCategories)
+	admin.Post("/categories", controllers.CreateCategory)
+	admin.Put("/categories/:id", controllers.UpdateCategory)
+	admin.Delete("/categories/:id", controllers.DeleteCategory)
+}
@@ -0,0 +1,27 @@
+package routes
+
+import (
+	"github.com/gilanghuda/sobi-backend/app/controllers"
+	"github.com/gilanghuda/sobi-backend/pkg/middleware"
+	"github.com/gilanghuda/sobi-backend/pkg/utils"
+	"github.com/gofiber/fiber/v2"
+)
+
+func RegisterAhliRoutes(app *fiber.App) {
+	me := app.Group("/ahli/me", middleware.JWTProtected(), middleware.RoleRequired(utils.RoleAhli))
+	me.Get("/", controllers.GetAhliProfile)
+	me.Put("/", controllers.UpdateAhliProfile)
+	me.Get("/price-history", controllers.GetAhliPriceHistory)
+	me.Get("/bookings", controllers.GetAhliBookings)
+	me.Post("/bookings/:id/cancel", controllers.CancelAhliBooking)
+	me.Get("/refunds", controllers.GetAhliRefunds)
+	me.Post("/refunds/:id/approve", controllers.AhliApproveRefund)
+	me.Post("/refunds/:id/reject", controllers.AhliRejectRefund)
+	me.Get("/earnings", controllers.GetAhliEarnings)
+	me.Get("/payouts/pending", controllers.GetAhliPendingPayouts)
+	me.Get("/clients", controllers.GetAhliClients)
+	me.Get("/packages", controllers.GetAhliPackages)
+	me.Post("/packages", controllers.CreateAhliPackage)
+	me.Put("/packages/:id", controllers.UpdateAhliPackage)
+	me.Delete("/packages/:id", controllers.DeleteAhliPackage)
+}
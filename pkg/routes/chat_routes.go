@@ -2,6 +2,7 @@ package routes
 
 import (
 	"github.com/gilanghuda/sobi-backend/app/controllers"
+	"github.com/gilanghuda/sobi-backend/pkg/middleware"
 	"github.com/gofiber/fiber/v2"
 	"github.com/gofiber/websocket/v2"
 	"github.com/valyala/fasthttp/fasthttpadaptor"
@@ -11,8 +12,23 @@ func RegisterChatRoutes(app *fiber.App) {
 	chat := app.Group("/chat")
 	chat.Post("/rooms", controllers.CreateRoom)
 	chat.Get("/rooms", controllers.GetRoomsByUser)
+	chat.Get("/rooms/:id/members", controllers.GetRoomMembers)
+	chat.Post("/rooms/:id/members", controllers.InviteRoomMember)
+	chat.Post("/rooms/:id/join", controllers.JoinRoom)
+	chat.Post("/rooms/:id/leave", controllers.LeaveRoom)
+	chat.Post("/rooms/:id/read", controllers.MarkRoomRead)
+	chat.Post("/rooms/:id/end", controllers.EndRoomSession)
+	chat.Post("/rooms/:id/archive", controllers.ArchiveRoom)
+	chat.Post("/rooms/:id/tips", middleware.JWTProtected(), controllers.SendTip)
 	chat.Post("/messages", controllers.PostMessage)
 	chat.Get("/messages", controllers.GetMessagesByRoom)
+	chat.Get("/messages/search", controllers.SearchMessages)
+	chat.Post("/messages/attachments", controllers.PostAttachmentMessage)
+	chat.Get("/attachments/:id", controllers.GetAttachment)
+	chat.Put("/messages/:id", controllers.EditMessage)
+	chat.Delete("/messages/:id", controllers.DeleteMessage)
+	chat.Post("/messages/:id/reactions", controllers.AddReaction)
+	chat.Delete("/messages/:id/reactions", controllers.RemoveReaction)
 	chat.Post("/find-match", controllers.FindMatch)
 
 	// Use fasthttp adaptor to wire the net/http MatchmakingHandler to Fiber
@@ -25,6 +41,7 @@ func RegisterChatRoutes(app *fiber.App) {
 	chat.Get("/recent", controllers.GetRecentChats)
 	chat.Get("/recent/target", controllers.GetRecentChatsAsTarget)
 	chat.Get("/active", controllers.GetActiveRoom)
+	chat.Get("/presence", controllers.GetPresence)
 	chat.Post("/bot-message", controllers.ChatWithGemini)
 
 	chat.Get("/ws", websocket.New(func(c *websocket.Conn) {
@@ -0,0 +1,16 @@
+package routes
+
+import (
+	"github.com/gilanghuda/sobi-backend/app/controllers"
+	"github.com/gilanghuda/sobi-backend/pkg/middleware"
+	"github.com/gofiber/fiber/v2"
+)
+
+func RegisterSubscriptionRoutes(app *fiber.App) {
+	app.Get("/packages", controllers.GetPackages)
+
+	app.Post("/subscriptions", middleware.JWTProtected(), controllers.CreateSubscription)
+	app.Get("/subscriptions", middleware.JWTProtected(), controllers.GetMySubscriptions)
+	app.Post("/subscriptions/:id/cancel", middleware.JWTProtected(), controllers.CancelSubscription)
+	app.Post("/bookings", middleware.JWTProtected(), controllers.CreateCreditBooking)
+}
@@ -2,11 +2,17 @@ package routes
 
 import (
 	"github.com/gilanghuda/sobi-backend/app/controllers"
+	"github.com/gilanghuda/sobi-backend/pkg/middleware"
 	"github.com/gofiber/fiber/v2"
 )
 
 func RegisterTransactionRoutes(app *fiber.App) {
 	app.Post("/transactions", controllers.CreateTransaction)
-	app.Get("/transactions/:id", controllers.GetTransactionByID)
+	app.Get("/transactions", middleware.JWTProtected(), controllers.GetTransactions)
+	app.Get("/transactions/:id", middleware.JWTProtected(), controllers.GetTransactionByID)
+	app.Get("/transactions/:id/receipt", middleware.JWTProtected(), controllers.GetTransactionReceipt)
 	app.Post("/transactions/notify", controllers.MidtransNotification)
+	app.Post("/vouchers/validate", middleware.JWTProtected(), controllers.ValidateVoucher)
+	app.Post("/transactions/:id/refunds", middleware.JWTProtected(), controllers.RequestRefund)
+	app.Get("/transactions/:id/refunds", middleware.JWTProtected(), controllers.GetTransactionRefunds)
 }
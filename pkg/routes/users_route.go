@@ -12,6 +12,7 @@ func RegisterUserRoutes(app *fiber.App) {
 	user.Put("/profile", controllers.UpdateUser)
 	user.Delete("/profile", controllers.DeleteUser)
 	user.Post("/logout", controllers.UserLogout)
+	user.Get("/tips", controllers.GetListenerEarnings)
 
 	app.Post("/signup", controllers.UserSignUp)
 	app.Post("/signin", controllers.UserSignIn)
@@ -19,6 +20,7 @@ func RegisterUserRoutes(app *fiber.App) {
 	app.Post("/verify-otp", controllers.UserVerifyOTP)
 	app.Post("/refresh-token", controllers.RefreshToken)
 	app.Get("/get-ahli", controllers.GetAhliWithDetails)
+	app.Get("/categories", controllers.GetCategories)
 	app.Get("/user/:id", controllers.GetUserByID)
 	app.Post("/ahli", controllers.PromoteToAhli)
 
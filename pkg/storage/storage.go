@@ -0,0 +1,29 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+)
+
+// ErrNotFound is returned when no blob is stored under a key
+var ErrNotFound = errors.New("blob not found")
+
+// Store keeps uploaded blobs such as chat attachments. Keys are slash separated paths
+// chosen by the caller and never come from user input directly.
+type Store interface {
+	Put(ctx context.Context, key string, r io.Reader) (int64, error)
+	Get(ctx context.Context, key string) (io.ReadCloser, error)
+	Delete(ctx context.Context, key string) error
+}
+
+// FromEnv returns the store configured for this deployment; only local disk storage under
+// STORAGE_DIR (default ./uploads) is available for now.
+func FromEnv() Store {
+	dir := os.Getenv("STORAGE_DIR")
+	if dir == "" {
+		dir = "./uploads"
+	}
+	return NewLocal(dir)
+}
@@ -0,0 +1,51 @@
+package utils
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Cursor is an opaque keyset pagination position: the sort value of the last
+// returned row plus its id as a tie breaker.
+type Cursor struct {
+	Value string `json:"v"`
+	ID    string `json:"id"`
+}
+
+// EncodeCursor serializes a cursor into a URL-safe string.
+func EncodeCursor(value, id string) string {
+	b, _ := json.Marshal(Cursor{Value: value, ID: id})
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// DecodeCursor parses a cursor produced by EncodeCursor.
+func DecodeCursor(s string) (Cursor, error) {
+	var cur Cursor
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return cur, errors.New("invalid cursor")
+	}
+	if err := json.Unmarshal(b, &cur); err != nil || cur.ID == "" {
+		return cur, errors.New("invalid cursor")
+	}
+	return cur, nil
+}
+
+// TimeCursorLayout is how created_at is written into cursors that paginate on (created_at, id).
+const TimeCursorLayout = "2006-01-02T15:04:05.999999"
+
+// CheckTimeID rejects a (created_at, id) cursor whose value is not a TimeCursorLayout timestamp
+// or whose id is not a UUID, before either reaches a query.
+func (c Cursor) CheckTimeID() error {
+	if _, err := time.Parse(TimeCursorLayout, c.Value); err != nil {
+		return errors.New("invalid cursor")
+	}
+	if _, err := uuid.Parse(c.ID); err != nil {
+		return errors.New("invalid cursor")
+	}
+	return nil
+}
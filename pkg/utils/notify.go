@@ -12,8 +12,11 @@ import (
 
 // Notifier manages active WebSocket connections and sending notifications.
 type Notifier struct {
-	mu    sync.RWMutex
-	conns map[uuid.UUID]*websocket.Conn
+	mu       sync.RWMutex
+	conns    map[uuid.UUID]*websocket.Conn
+	lastSeen map[uuid.UUID]time.Time
+	// writeMu serializes writes per connection; a websocket allows only one concurrent writer
+	writeMu map[*websocket.Conn]*sync.Mutex
 }
 
 // DefaultNotifier is the package-level notifier instance.
@@ -22,7 +25,9 @@ var DefaultNotifier = NewNotifier()
 // NewNotifier creates a new Notifier.
 func NewNotifier() *Notifier {
 	return &Notifier{
-		conns: make(map[uuid.UUID]*websocket.Conn),
+		conns:    make(map[uuid.UUID]*websocket.Conn),
+		lastSeen: make(map[uuid.UUID]time.Time),
+		writeMu:  make(map[*websocket.Conn]*sync.Mutex),
 	}
 }
 
@@ -30,7 +35,11 @@ func NewNotifier() *Notifier {
 func (n *Notifier) Register(userID uuid.UUID, conn *websocket.Conn) {
 	n.mu.Lock()
 	defer n.mu.Unlock()
+	if old, ok := n.conns[userID]; ok {
+		delete(n.writeMu, old)
+	}
 	n.conns[userID] = conn
+	n.writeMu[conn] = &sync.Mutex{}
 	log.Printf("event=ws_register user=%s total_connections=%d", userID.String(), len(n.conns))
 }
 
@@ -41,16 +50,50 @@ func (n *Notifier) Unregister(userID uuid.UUID) {
 	if conn, ok := n.conns[userID]; ok {
 		_ = conn.Close()
 		delete(n.conns, userID)
+		delete(n.writeMu, conn)
+		n.lastSeen[userID] = time.Now()
+	}
+	log.Printf("event=ws_unregister user=%s total_connections=%d", userID.String(), len(n.conns))
+}
+
+// UnregisterConn removes the user's connection only if it is still conn, so a closing
+// connection does not drop a newer one opened by the same user. It reports whether it did.
+func (n *Notifier) UnregisterConn(userID uuid.UUID, conn *websocket.Conn) bool {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if current, ok := n.conns[userID]; !ok || current != conn {
+		return false
 	}
+	delete(n.conns, userID)
+	delete(n.writeMu, conn)
+	n.lastSeen[userID] = time.Now()
 	log.Printf("event=ws_unregister user=%s total_connections=%d", userID.String(), len(n.conns))
+	return true
+}
+
+// IsOnline reports whether the user has a registered connection.
+func (n *Notifier) IsOnline(userID uuid.UUID) bool {
+	n.mu.RLock()
+	defer n.mu.RUnlock()
+	_, ok := n.conns[userID]
+	return ok
+}
+
+// LastSeen returns when the user's last connection closed since this process started.
+func (n *Notifier) LastSeen(userID uuid.UUID) (time.Time, bool) {
+	n.mu.RLock()
+	defer n.mu.RUnlock()
+	t, ok := n.lastSeen[userID]
+	return t, ok
 }
 
 // Send sends a JSON-serializable payload to the user's websocket connection.
 func (n *Notifier) Send(userID uuid.UUID, payload interface{}) error {
 	n.mu.RLock()
 	conn, ok := n.conns[userID]
+	wmu := n.writeMu[conn]
 	n.mu.RUnlock()
-	if !ok || conn == nil {
+	if !ok || conn == nil || wmu == nil {
 		log.Printf("event=notify_skip user=%s reason=no_connection", userID.String())
 		return ErrNoConnection
 	}
@@ -64,6 +107,8 @@ func (n *Notifier) Send(userID uuid.UUID, payload interface{}) error {
 	// log payload string for debug
 	log.Printf("event=notify_send user=%s payload=%s", userID.String(), string(msg))
 
+	wmu.Lock()
+	defer wmu.Unlock()
 	conn.SetWriteDeadline(time.Now().Add(5 * time.Second))
 	if err := conn.WriteMessage(websocket.TextMessage, msg); err != nil {
 		log.Printf("event=notify_error_write user=%s error=%v", userID.String(), err)
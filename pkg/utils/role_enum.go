@@ -3,6 +3,8 @@ package utils
 const (
 	RoleAdmin = "admin"
 	RoleUser  = "user"
+	RoleAhli  = "ahli"
 )
 
+// ValidRoles lists the roles that can be chosen at sign up; ahli is granted by promotion only
 var ValidRoles = []string{RoleAdmin, RoleUser}